@@ -7,6 +7,7 @@ import (
 	"flag"
 
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -140,12 +141,28 @@ func runCLI(args []string) {
 			os.Exit(2)
 		}
 		raw, err = client.DoJSON(ctx, http.MethodPost, "/scanners/trigger/"+name, nil)
+	case "accept":
+		name := *plugin
+		if name == "" && sub != "" {
+			name = sub
+		}
+		if name == "" {
+			_, _ = os.Stderr.WriteString("ctl error: plugin name is required\n")
+			os.Exit(2)
+		}
+		raw, err = client.DoJSON(ctx, http.MethodPost, "/scanners/accept/"+name, nil)
 	case "signatures":
 		switch sub {
 		case "status":
 			raw, err = client.DoJSON(ctx, http.MethodGet, "/signatures/status", nil)
 		case "update":
 			raw, err = client.DoJSON(ctx, http.MethodPost, "/signatures/update", nil)
+		case "lookup":
+			if fs.NArg() < 3 {
+				_, _ = os.Stderr.WriteString("ctl error: lookup query is required (CVE, technique, advisory, or package)\n")
+				os.Exit(2)
+			}
+			raw, err = client.DoJSON(ctx, http.MethodGet, "/signatures/lookup/"+url.PathEscape(fs.Arg(2)), nil)
 		default:
 			usageCLI()
 			os.Exit(2)
@@ -196,7 +213,8 @@ func usageCLI() {
 		"  baselines",
 		"  results [latest|history]",
 		"  trigger <plugin>",
-		"  signatures status|update",
+		"  accept <plugin>",
+		"  signatures status|update|lookup <query>",
 		"  export results|baselines",
 		"  metrics",
 		"  validate",
@@ -205,7 +223,7 @@ func usageCLI() {
 		"Flags:",
 		"  -addr http://127.0.0.1:8788",
 		"  -token <token> (or ARCSENT_TOKEN)",
-		"  -plugin <plugin> (for trigger)",
+		"  -plugin <plugin> (for trigger/accept)",
 		"  -format json|csv (for export)",
 		"  -pretty (pretty-print JSON)",
 		"  -config <path> (for validate/storage-check)",
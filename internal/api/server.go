@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -32,9 +33,10 @@ type Server struct {
 	resultsStore *storage.ResultsStore
 	sigStore     *signatures.Store
 	sigUpdater   *signatures.Updater
+	procDeltas   *storage.ProcessDeltaStore
 }
 
-func New(cfg config.APIConfig, logger *logging.Logger, mgr *scanner.Manager, sched *scheduler.Scheduler, results *state.ResultCache, baseline *detection.Manager, resultsStore *storage.ResultsStore, sigStore *signatures.Store, sigUpdater *signatures.Updater) *Server {
+func New(cfg config.APIConfig, logger *logging.Logger, mgr *scanner.Manager, sched *scheduler.Scheduler, results *state.ResultCache, baseline *detection.Manager, resultsStore *storage.ResultsStore, sigStore *signatures.Store, sigUpdater *signatures.Updater, procDeltas *storage.ProcessDeltaStore) *Server {
 	return &Server{
 		cfg:          cfg,
 		logger:       logger,
@@ -45,6 +47,7 @@ func New(cfg config.APIConfig, logger *logging.Logger, mgr *scanner.Manager, sch
 		resultsStore: resultsStore,
 		sigStore:     sigStore,
 		sigUpdater:   sigUpdater,
+		procDeltas:   procDeltas,
 	}
 }
 
@@ -92,6 +95,7 @@ func (s *Server) buildHandler() http.Handler {
 	register("/status", s.handleStatus)
 	register("/scanners", s.handleScanners)
 	register("/scanners/trigger/", s.handleTrigger)
+	register("/scanners/accept/", s.handleAccept)
 	register("/results/latest", s.handleResultsLatest)
 	register("/results/history", s.handleResultsHistory)
 	register("/findings", s.handleFindings)
@@ -100,7 +104,9 @@ func (s *Server) buildHandler() http.Handler {
 	register("/export/baselines", s.handleExportBaselines)
 	register("/signatures/status", s.handleSignaturesStatus)
 	register("/signatures/update", s.handleSignaturesUpdate)
+	register("/signatures/lookup/", s.handleSignaturesLookup)
 	register("/metrics", s.handleMetrics)
+	register("/processes/delta", s.handleProcessDelta)
 	return mux
 }
 
@@ -170,6 +176,33 @@ func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, result)
 }
 
+func (s *Server) handleAccept(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return
+	}
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api"), "/scanners/accept/")
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "scanner name required"})
+		return
+	}
+	p, err := s.mgr.Get(name)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	baseliner, ok := p.(scanner.Baseliner)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("plugin %q does not keep a baseline", name)})
+		return
+	}
+	if err := baseliner.AcceptBaseline(r.Context()); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "accepted", "plugin": name})
+}
+
 func (s *Server) handleResultsLatest(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, s.results.Latest())
 }
@@ -261,6 +294,55 @@ func (s *Server) handleSignaturesUpdate(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, status)
 }
 
+func (s *Server) handleSignaturesLookup(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api"), "/signatures/lookup/")
+	if query == "" {
+		query = r.URL.Query().Get("q")
+	}
+	if query == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "lookup query required"})
+		return
+	}
+	if s.sigStore == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "signatures index not configured"})
+		return
+	}
+	result, err := s.sigStore.Lookup(query)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%q not found in the signatures index", query)})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleProcessDelta(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-24 * time.Hour)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil {
+			since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			since = t
+		} else {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be a duration or RFC3339 time"})
+			return
+		}
+	}
+	if s.procDeltas == nil {
+		writeJSON(w, http.StatusOK, []interface{}{})
+		return
+	}
+	deltas, err := s.procDeltas.Since(since)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, deltas)
+}
+
 func (s *Server) handleMetrics(w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
 	w.WriteHeader(http.StatusOK)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/ipsix/arcsent/internal/logging"
 	"github.com/ipsix/arcsent/internal/scanner"
 	"github.com/ipsix/arcsent/internal/scheduler"
+	"github.com/ipsix/arcsent/internal/signatures"
 	"github.com/ipsix/arcsent/internal/state"
 	"github.com/ipsix/arcsent/internal/storage"
 )
@@ -41,7 +43,7 @@ func TestAPIAuth(t *testing.T) {
 	baseline := detection.NewManager(store)
 
 	cfg := config.APIConfig{Enabled: true, BindAddr: "127.0.0.1:0", AuthToken: "secret"}
-	server := New(cfg, logging.New("text"), mgr, sched, results, baseline, nil, nil, nil)
+	server := New(cfg, logging.New("text"), mgr, sched, results, baseline, nil, nil, nil, nil)
 	handler := server.buildHandler()
 
 	req := httptest.NewRequest(http.MethodGet, "/status", nil)
@@ -75,7 +77,7 @@ func TestMetricsEndpoint(t *testing.T) {
 	baseline := detection.NewManager(store)
 
 	cfg := config.APIConfig{Enabled: true, BindAddr: "127.0.0.1:0", AuthToken: "secret"}
-	server := New(cfg, logging.New("text"), mgr, sched, results, baseline, nil, nil, nil)
+	server := New(cfg, logging.New("text"), mgr, sched, results, baseline, nil, nil, nil, nil)
 	handler := server.buildHandler()
 
 	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
@@ -89,3 +91,40 @@ func TestMetricsEndpoint(t *testing.T) {
 		t.Fatalf("expected metrics body to include arcsent_up, got: %s", got)
 	}
 }
+
+func TestSignaturesLookupEndpoint(t *testing.T) {
+	mgr := scanner.NewManager()
+	sched := scheduler.New(logging.New("text"), mgr)
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	cache := t.TempDir()
+	kev := `{"catalogVersion":"2024.06.01","vulnerabilities":[{"cveID":"CVE-2024-3400","vulnerabilityName":"PAN-OS Command Injection"}]}`
+	if err := os.WriteFile(filepath.Join(cache, "kev.json"), []byte(kev), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	sigStore := signatures.NewStore(store)
+	if _, err := sigStore.RebuildIndex(signatures.SourceCISAKEV, cache); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+
+	cfg := config.APIConfig{Enabled: true, BindAddr: "127.0.0.1:0"}
+	server := New(cfg, logging.New("text"), mgr, sched, state.NewResultCache(10), nil, nil, sigStore, nil, nil)
+	handler := server.buildHandler()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/signatures/lookup/CVE-2024-3400", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "PAN-OS Command Injection") {
+		t.Fatalf("lookup: %d %s", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/signatures/lookup/CVE-1999-0001", nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown CVE, got %d", rr.Code)
+	}
+}
@@ -22,6 +22,10 @@ import (
 	"github.com/ipsix/arcsent/internal/webui"
 )
 
+type storeAware interface {
+	WithStore(store storage.Store)
+}
+
 type Runner struct {
 	cfg        config.Config
 	logger     *logging.Logger
@@ -83,6 +87,17 @@ func (r *Runner) Run(ctx context.Context) error {
 		&system.ProcessMonitor{},
 		&system.AuthLogMonitor{},
 		&system.NetworkListeners{},
+		&system.PackageIntegrity{},
+		&system.Accounts{},
+		&system.SSHAudit{},
+		&system.Persistence{},
+		&system.Kernel{},
+		&system.FilePermissions{},
+		&system.Vulnerabilities{},
+		&system.ContentScan{},
+		&system.RootkitHeuristics{},
+		&system.Auditd{},
+		&system.WebAccessLog{},
 		&system.Uptime{},
 	}
 	for _, plugin := range plugins {
@@ -109,6 +124,11 @@ func (r *Runner) Run(ctx context.Context) error {
 		return err
 	}
 	defer store.Close()
+	for _, plugin := range plugins {
+		if aware, ok := plugin.(storeAware); ok {
+			aware.WithStore(store)
+		}
+	}
 
 	signatureStore := signatures.NewStore(store)
 	signatureUpdater := signatures.NewUpdater(signatures.Config{
@@ -122,6 +142,7 @@ func (r *Runner) Run(ctx context.Context) error {
 
 	baselineMgr := detection.NewManager(store)
 	resultsStore := storage.NewResultsStore(store)
+	processDeltas := storage.NewProcessDeltaStore(store)
 	ruleEngine := detection.NewRuleEngine(buildRules(r.cfg.Detection.Rules))
 	correlator := detection.NewCorrelator(r.cfg.Detection.CorrelationWindowDuration(), r.cfg.Detection.CorrelationMinScanners, r.cfg.Detection.CorrelationCooldownDuration())
 	resultCache := state.NewResultCache(50)
@@ -193,10 +214,11 @@ func (r *Runner) Run(ctx context.Context) error {
 	}
 
 	sched.Start(ctx)
+	stopStreams := r.startStreams(ctx, plugins, sched)
 
 	go signatureUpdater.Start(ctx)
 
-	apiServer := api.New(r.cfg.API, r.logger, manager, sched, resultCache, baselineMgr, resultsStore, signatureStore, signatureUpdater)
+	apiServer := api.New(r.cfg.API, r.logger, manager, sched, resultCache, baselineMgr, resultsStore, signatureStore, signatureUpdater, processDeltas)
 	go func() {
 		if err := apiServer.Start(ctx); err != nil {
 			r.logger.Error("api server exited", logging.Field{Key: "error", Value: err.Error()})
@@ -293,6 +315,8 @@ func (r *Runner) Run(ctx context.Context) error {
 		if err := sched.ReplaceJobs(ctx, jobs); err != nil {
 			r.logger.Error("scheduler reload failed", logging.Field{Key: "error", Value: err.Error()})
 		}
+		stopStreams()
+		stopStreams = r.startStreams(ctx, plugins, sched)
 
 		r.logger.Info("config reload complete")
 	}
@@ -300,6 +324,7 @@ func (r *Runner) Run(ctx context.Context) error {
 	go r.handleSignals(sigCh, cancel, reload)
 
 	<-ctx.Done()
+	stopStreams()
 
 	_ = apiServer.Shutdown(context.Background())
 	_ = webServer.Shutdown(context.Background())
@@ -307,6 +332,28 @@ func (r *Runner) Run(ctx context.Context) error {
 	return r.shutdown(r.cfg.Daemon.ShutdownTimeoutDuration())
 }
 
+func (r *Runner) startStreams(ctx context.Context, plugins []scanner.Plugin, sched *scheduler.Scheduler) context.CancelFunc {
+	streamCtx, cancel := context.WithCancel(ctx)
+	enabled := map[string]bool{}
+	for _, sc := range r.cfg.Scanners {
+		if sc.Enabled {
+			enabled[sc.Plugin] = true
+		}
+	}
+	for _, plugin := range plugins {
+		streamer, ok := plugin.(scanner.Streamer)
+		if !ok || !enabled[plugin.Name()] {
+			continue
+		}
+		go func(name string, streamer scanner.Streamer) {
+			if err := streamer.Stream(streamCtx, sched.Emit); err != nil && streamCtx.Err() == nil {
+				r.logger.Error("plugin stream failed", logging.Field{Key: "plugin", Value: name}, logging.Field{Key: "error", Value: err.Error()})
+			}
+		}(plugin.Name(), streamer)
+	}
+	return cancel
+}
+
 func toFloat(value interface{}) (float64, bool) {
 	switch v := value.(type) {
 	case float64:
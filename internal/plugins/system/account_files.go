@@ -0,0 +1,246 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type passwdEntry struct {
+	Name     string
+	Password string
+	UID      int
+	GID      int
+	Home     string
+	Shell    string
+}
+
+type groupEntry struct {
+	Name    string
+	GID     int
+	Members []string
+}
+
+// sudoRule is one user specification, alias definition or Defaults entry from
+// sudoers, with continuation lines joined and whitespace collapsed. Aliases
+// and Defaults are kept because widening an alias or adding
+// "Defaults:user !authenticate" grants as much as a new user specification.
+type sudoRule struct {
+	File string
+	Rule string
+}
+
+func readPasswd(path string) ([]passwdEntry, error) {
+	entries := []passwdEntry{}
+	err := readLines(path, func(line string) {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || strings.HasPrefix(line, "#") {
+			return
+		}
+		uid, errUID := strconv.Atoi(fields[2])
+		gid, errGID := strconv.Atoi(fields[3])
+		if errUID != nil || errGID != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{
+			Name:     fields[0],
+			Password: fields[1],
+			UID:      uid,
+			GID:      gid,
+			Home:     fields[5],
+			Shell:    fields[6],
+		})
+	})
+	return entries, err
+}
+
+// readShadow returns the password field of every shadow entry by user name.
+func readShadow(path string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := readLines(path, func(line string) {
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || strings.HasPrefix(line, "#") {
+			return
+		}
+		hashes[fields[0]] = fields[1]
+	})
+	return hashes, err
+}
+
+func readGroups(path string) ([]groupEntry, error) {
+	groups := []groupEntry{}
+	err := readLines(path, func(line string) {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || strings.HasPrefix(line, "#") {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		members := []string{}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				members = append(members, member)
+			}
+		}
+		sort.Strings(members)
+		groups = append(groups, groupEntry{Name: fields[0], GID: gid, Members: members})
+	})
+	return groups, err
+}
+
+// hashScheme names the crypt(3) scheme of a password field. "locked" covers
+// "*", "!" and "!"-prefixed hashes, "empty" a field that allows login without
+// a password.
+func hashScheme(hash string) string {
+	switch {
+	case hash == "":
+		return "empty"
+	case strings.HasPrefix(hash, "!") || strings.HasPrefix(hash, "*"):
+		return "locked"
+	case strings.HasPrefix(hash, "$1$"):
+		return "md5"
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return "bcrypt"
+	case strings.HasPrefix(hash, "$3$"):
+		return "nthash"
+	case strings.HasPrefix(hash, "$5$"):
+		return "sha256"
+	case strings.HasPrefix(hash, "$6$"):
+		return "sha512"
+	case strings.HasPrefix(hash, "$7$"):
+		return "scrypt"
+	case strings.HasPrefix(hash, "$y$"), strings.HasPrefix(hash, "$gy$"):
+		return "yescrypt"
+	case strings.HasPrefix(hash, "_") && len(hash) == 20:
+		return "bsdi"
+	case len(hash) == 13 && !strings.HasPrefix(hash, "$"):
+		return "des"
+	default:
+		return "unknown"
+	}
+}
+
+func weakHashScheme(scheme string) bool {
+	switch scheme {
+	case "des", "bsdi", "md5", "nthash":
+		return true
+	}
+	return false
+}
+
+// loginUIDMin reads UID_MIN from login.defs, the first UID handed to regular
+// users.
+func loginUIDMin(path string) int {
+	min := 1000
+	_ = readLines(path, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "UID_MIN" {
+			if v, err := strconv.Atoi(fields[1]); err == nil {
+				min = v
+			}
+		}
+	})
+	return min
+}
+
+// readSudoers collects user specifications from a sudoers file and the files
+// it includes. Paths in include directives are resolved under root.
+func readSudoers(root, path string) ([]sudoRule, error) {
+	rules := []sudoRule{}
+	seen := map[string]bool{}
+	var read func(path string, depth int) error
+	read = func(path string, depth int) error {
+		if depth > 8 || seen[path] {
+			return nil
+		}
+		seen[path] = true
+		logical := ""
+		var includeErr error
+		err := readLines(path, func(line string) {
+			if strings.HasSuffix(line, "\\") {
+				logical += strings.TrimSuffix(line, "\\") + " "
+				return
+			}
+			line, logical = strings.TrimSpace(logical+line), ""
+			if line == "" {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "#include", "@include", "#includedir", "@includedir":
+				if len(fields) < 2 {
+					return
+				}
+				target := fields[1]
+				if filepath.IsAbs(target) {
+					target = filepath.Join(root, target)
+				} else {
+					target = filepath.Join(filepath.Dir(path), target)
+				}
+				if strings.HasSuffix(fields[0], "dir") {
+					includeErr = readSudoersDir(target, func(file string) error { return read(file, depth+1) })
+				} else if err := read(target, depth+1); err != nil && !os.IsNotExist(err) {
+					includeErr = err
+				}
+				return
+			}
+			if idx := sudoersComment(line); idx == 0 {
+				return
+			} else if idx > 0 {
+				line = strings.TrimSpace(line[:idx])
+			}
+			rules = append(rules, sudoRule{File: path, Rule: strings.Join(strings.Fields(line), " ")})
+		})
+		if err != nil {
+			return err
+		}
+		return includeErr
+	}
+	if err := read(path, 0); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// sudoersComment returns where a comment starts in line, or -1. A "#"
+// followed by a digit is a numeric UID or GID ("#1000 ALL=(ALL) ALL",
+// "(#0)"), not a comment.
+func sudoersComment(line string) int {
+	for i := 0; i < len(line); i++ {
+		if line[i] != '#' || i > 0 && line[i-1] != ' ' && line[i-1] != '\t' {
+			continue
+		}
+		if i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// readSudoersDir visits the files sudo would load from an includedir: names
+// containing a dot or ending in "~" are skipped.
+func readSudoersDir(dir string, fn func(path string) error) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.Contains(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		if err := fn(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
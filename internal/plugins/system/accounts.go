@@ -0,0 +1,387 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const accountsBucket = "accounts_state"
+
+// defaultPrivilegedGroups are groups whose members can become root.
+var defaultPrivilegedGroups = []string{"sudo", "wheel", "admin", "root", "docker"}
+
+// Accounts audits local accounts, password hashes, group membership and sudo
+// grants, and reports drift against the previous run.
+type Accounts struct {
+	root             string
+	uidMin           int
+	nologinShells    map[string]bool
+	privilegedGroups map[string]bool
+	store            storage.Store
+}
+
+type accountUser struct {
+	UID   int    `json:"uid"`
+	GID   int    `json:"gid"`
+	Home  string `json:"home"`
+	Shell string `json:"shell"`
+}
+
+type accountSnapshot struct {
+	Users     map[string]accountUser `json:"users"`
+	Groups    map[string][]string    `json:"groups"`
+	SudoRules []string               `json:"sudo_rules"`
+}
+
+type accountData struct {
+	users          []passwdEntry
+	groups         []groupEntry
+	shadow         map[string]string
+	sudoRules      []sudoRule
+	shadowReadable bool
+	sudoReadable   bool
+}
+
+func (a *Accounts) Name() string { return "system.accounts" }
+
+func (a *Accounts) WithStore(store storage.Store) {
+	a.store = store
+}
+
+func (a *Accounts) Init(config map[string]interface{}) error {
+	a.root = "/"
+	a.uidMin = 0
+	if v, ok := config["root"].(string); ok && v != "" {
+		a.root = v
+	}
+	if v, ok := config["uid_min"].(float64); ok && v > 0 {
+		a.uidMin = int(v)
+	}
+	shells := []string{"/usr/sbin/nologin", "/sbin/nologin", "/bin/false", "/usr/bin/false", "/bin/sync", "/sbin/shutdown", "/sbin/halt"}
+	if v, ok := configStrings(config, "nologin_shells"); ok {
+		shells = v
+	}
+	a.nologinShells = map[string]bool{"": true}
+	for _, shell := range shells {
+		a.nologinShells[shell] = true
+	}
+	groups := defaultPrivilegedGroups
+	if v, ok := configStrings(config, "privileged_groups"); ok {
+		groups = v
+	}
+	a.privilegedGroups = map[string]bool{}
+	for _, group := range groups {
+		a.privilegedGroups[group] = true
+	}
+	return nil
+}
+
+func (a *Accounts) Run(_ context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: a.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	data, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata["shadow_readable"] = data.shadowReadable
+	result.Metadata["sudoers_readable"] = data.sudoReadable
+
+	uidMin := a.uidMin
+	if uidMin == 0 {
+		uidMin = loginUIDMin(a.hostPath("/etc/login.defs"))
+	}
+	for _, user := range data.users {
+		evidence := accountEvidence(user)
+		if user.UID == 0 && user.Name != "root" {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "account_uid0",
+				Severity:    scanner.SeverityCritical,
+				Category:    "accounts",
+				Description: fmt.Sprintf("Account %s has UID 0", user.Name),
+				Evidence:    evidence,
+				Remediation: "Remove the account or give it a unique unprivileged UID.",
+			})
+		}
+		hash, known := user.Password, true
+		if hash == "x" {
+			hash, known = data.shadow[user.Name]
+		}
+		if known {
+			scheme := hashScheme(hash)
+			if scheme == "empty" {
+				result.Findings = append(result.Findings, scanner.Finding{
+					ID:          "account_empty_password",
+					Severity:    scanner.SeverityCritical,
+					Category:    "accounts",
+					Description: fmt.Sprintf("Account %s has an empty password", user.Name),
+					Evidence:    evidence,
+					Remediation: "Set a password or lock the account with passwd -l.",
+				})
+			} else if weakHashScheme(scheme) {
+				evidence := accountEvidence(user)
+				evidence["hash_scheme"] = scheme
+				result.Findings = append(result.Findings, scanner.Finding{
+					ID:          "account_weak_hash",
+					Severity:    scanner.SeverityHigh,
+					Category:    "accounts",
+					Description: fmt.Sprintf("Account %s uses the weak %s password hash scheme", user.Name, scheme),
+					Evidence:    evidence,
+					Remediation: "Reset the password so it is stored with yescrypt or SHA-512.",
+				})
+			}
+		}
+		if user.UID != 0 && user.UID < uidMin && !a.nologinShells[user.Shell] {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "account_service_shell",
+				Severity:    scanner.SeverityMedium,
+				Category:    "accounts",
+				Description: fmt.Sprintf("Service account %s has interactive shell %s", user.Name, user.Shell),
+				Evidence:    evidence,
+				Remediation: "Set the shell to /usr/sbin/nologin unless the account needs interactive logins.",
+			})
+		}
+	}
+
+	if a.store != nil {
+		previous, current, ok, err := a.snapshots(data)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result.Findings = append(result.Findings, a.diff(previous, current, data)...)
+		} else {
+			result.Metadata["baseline_created"] = true
+		}
+		if err := saveJSON(a.store, accountsBucket, "snapshot", current); err != nil {
+			return nil, fmt.Errorf("save accounts snapshot: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (a *Accounts) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline records the current accounts, groups and sudo grants as the
+// state later runs are compared against. Sudo grants from an unreadable
+// sudoers are kept from the stored snapshot; with none stored, accepting is
+// refused so the first readable run does not report every rule as new.
+func (a *Accounts) AcceptBaseline(_ context.Context) error {
+	if a.store == nil {
+		return fmt.Errorf("accounts baseline requires storage")
+	}
+	data, err := a.load()
+	if err != nil {
+		return err
+	}
+	return a.acceptSnapshot(data)
+}
+
+func (a *Accounts) acceptSnapshot(data accountData) error {
+	_, current, ok, err := a.snapshots(data)
+	if err != nil {
+		return err
+	}
+	if !ok && !data.sudoReadable {
+		return fmt.Errorf("sudoers is not readable; accept the accounts baseline as root")
+	}
+	return saveJSON(a.store, accountsBucket, "snapshot", current)
+}
+
+// snapshots loads the stored snapshot and builds the current one, carrying
+// the stored sudo grants forward when sudoers could not be read.
+func (a *Accounts) snapshots(data accountData) (accountSnapshot, accountSnapshot, bool, error) {
+	var previous accountSnapshot
+	ok, err := loadJSON(a.store, accountsBucket, "snapshot", &previous)
+	if err != nil {
+		return previous, accountSnapshot{}, false, err
+	}
+	current := data.snapshot()
+	if ok && !data.sudoReadable {
+		current.SudoRules = previous.SudoRules
+	}
+	return previous, current, ok, nil
+}
+
+func (a *Accounts) hostPath(path string) string {
+	return filepath.Join(a.root, path)
+}
+
+// load reads the account databases. passwd and group are required; shadow and
+// sudoers are usually root-only and are skipped when unreadable.
+func (a *Accounts) load() (accountData, error) {
+	data := accountData{shadow: map[string]string{}}
+	var err error
+	if data.users, err = readPasswd(a.hostPath("/etc/passwd")); err != nil {
+		return data, fmt.Errorf("read passwd: %w", err)
+	}
+	if data.groups, err = readGroups(a.hostPath("/etc/group")); err != nil {
+		return data, fmt.Errorf("read group: %w", err)
+	}
+	if shadow, err := readShadow(a.hostPath("/etc/shadow")); err == nil {
+		data.shadow, data.shadowReadable = shadow, true
+	} else if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, os.ErrPermission) {
+		return data, fmt.Errorf("read shadow: %w", err)
+	}
+	rules, err := readSudoers(a.root, a.hostPath("/etc/sudoers"))
+	switch {
+	case err == nil:
+		data.sudoRules, data.sudoReadable = rules, true
+	case errors.Is(err, os.ErrNotExist):
+		data.sudoReadable = true
+	case !errors.Is(err, os.ErrPermission):
+		return data, fmt.Errorf("read sudoers: %w", err)
+	}
+	return data, nil
+}
+
+func (d accountData) snapshot() accountSnapshot {
+	snap := accountSnapshot{Users: map[string]accountUser{}, Groups: map[string][]string{}, SudoRules: []string{}}
+	for _, user := range d.users {
+		snap.Users[user.Name] = accountUser{UID: user.UID, GID: user.GID, Home: user.Home, Shell: user.Shell}
+	}
+	for _, group := range d.groups {
+		snap.Groups[group.Name] = group.Members
+	}
+	for _, rule := range d.sudoRules {
+		snap.SudoRules = appendUnique(snap.SudoRules, rule.Rule)
+	}
+	sort.Strings(snap.SudoRules)
+	return snap
+}
+
+func (a *Accounts) diff(previous, current accountSnapshot, data accountData) []scanner.Finding {
+	findings := []scanner.Finding{}
+	for _, name := range sortedKeys(current.Users) {
+		user := current.Users[name]
+		before, ok := previous.Users[name]
+		evidence := map[string]interface{}{"user": name, "uid": user.UID, "gid": user.GID, "home": user.Home, "shell": user.Shell}
+		if !ok {
+			findings = append(findings, scanner.Finding{
+				ID:          "account_added",
+				Severity:    scanner.SeverityHigh,
+				Category:    "accounts",
+				Description: fmt.Sprintf("Account %s was added", name),
+				Evidence:    evidence,
+				Remediation: "Confirm the account was created through change management.",
+			})
+			continue
+		}
+		if before != user {
+			evidence["previous"] = map[string]interface{}{"uid": before.UID, "gid": before.GID, "home": before.Home, "shell": before.Shell}
+			findings = append(findings, scanner.Finding{
+				ID:          "account_changed",
+				Severity:    scanner.SeverityMedium,
+				Category:    "accounts",
+				Description: fmt.Sprintf("Account %s changed UID, GID, home or shell", name),
+				Evidence:    evidence,
+				Remediation: "Confirm the change was intended.",
+			})
+		}
+	}
+	for _, name := range sortedKeys(previous.Users) {
+		if _, ok := current.Users[name]; !ok {
+			findings = append(findings, scanner.Finding{
+				ID:          "account_removed",
+				Severity:    scanner.SeverityMedium,
+				Category:    "accounts",
+				Description: fmt.Sprintf("Account %s was removed", name),
+				Evidence:    map[string]interface{}{"user": name, "uid": previous.Users[name].UID},
+				Remediation: "Confirm the removal was intended and that no files are left owned by the old UID.",
+			})
+		}
+	}
+
+	for _, group := range sortedKeys(current.Groups) {
+		before := map[string]bool{}
+		for _, member := range previous.Groups[group] {
+			before[member] = true
+		}
+		after := map[string]bool{}
+		for _, member := range current.Groups[group] {
+			after[member] = true
+			if before[member] {
+				continue
+			}
+			severity := scanner.SeverityMedium
+			if a.privilegedGroups[group] {
+				severity = scanner.SeverityHigh
+			}
+			findings = append(findings, scanner.Finding{
+				ID:          "group_member_added",
+				Severity:    severity,
+				Category:    "accounts",
+				Description: fmt.Sprintf("%s was added to group %s", member, group),
+				Evidence:    map[string]interface{}{"user": member, "group": group},
+				Remediation: "Remove the membership unless it was approved.",
+			})
+		}
+		for _, member := range previous.Groups[group] {
+			if !after[member] {
+				findings = append(findings, scanner.Finding{
+					ID:          "group_member_removed",
+					Severity:    scanner.SeverityLow,
+					Category:    "accounts",
+					Description: fmt.Sprintf("%s was removed from group %s", member, group),
+					Evidence:    map[string]interface{}{"user": member, "group": group},
+					Remediation: "Confirm the change was intended.",
+				})
+			}
+		}
+	}
+
+	if data.sudoReadable {
+		before := map[string]bool{}
+		for _, rule := range previous.SudoRules {
+			before[rule] = true
+		}
+		for _, rule := range data.sudoRules {
+			if before[rule.Rule] {
+				continue
+			}
+			before[rule.Rule] = true
+			findings = append(findings, scanner.Finding{
+				ID:          "sudo_grant_added",
+				Severity:    scanner.SeverityHigh,
+				Category:    "accounts",
+				Description: fmt.Sprintf("New sudo grant in %s: %s", rule.File, rule.Rule),
+				Evidence:    map[string]interface{}{"file": rule.File, "rule": rule.Rule, "nopasswd": strings.Contains(rule.Rule, "NOPASSWD") || strings.Contains(rule.Rule, "!authenticate")},
+				Remediation: "Remove the grant unless it was approved; review who can write to sudoers.",
+			})
+		}
+	}
+	return findings
+}
+
+func accountEvidence(user passwdEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"user":  user.Name,
+		"uid":   user.UID,
+		"gid":   user.GID,
+		"home":  user.Home,
+		"shell": user.Shell,
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,149 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestAccounts(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("etc/passwd", strings.Join([]string{
+		"root:x:0:0:root:/root:/bin/bash",
+		"toor:x:0:0::/root:/bin/sh",
+		"www-data:x:33:33::/var/www:/bin/bash",
+		"sync:x:4:65534::/bin:/bin/sync",
+		"alice:x:1000:1000::/home/alice:/bin/bash",
+		"bob:x:1001:1001::/home/bob:/bin/bash",
+	}, "\n")+"\n")
+	write("etc/shadow", strings.Join([]string{
+		"root:$y$j9T$abc$def:19000:0:99999:7:::",
+		"toor:!:19000::::::",
+		"www-data:*:19000::::::",
+		"sync:*:19000::::::",
+		"alice:$1$salt$hash:19000::::::",
+		"bob::19000::::::",
+	}, "\n")+"\n")
+	write("etc/group", "root:x:0:\nsudo:x:27:alice\nusers:x:100:alice,bob\n")
+	write("etc/sudoers", "Defaults env_reset\nUser_Alias ADMINS = alice\nroot ALL=(ALL:ALL) ALL\n%sudo ALL=(ALL:ALL) ALL\nADMINS ALL=(ALL) ALL\n@includedir /etc/sudoers.d\n")
+	write("etc/sudoers.d/README", "# comment only\n")
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &Accounts{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{"root": root}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) []string {
+		t.Helper()
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		ids := []string{}
+		for _, finding := range result.Findings {
+			ids = append(ids, finding.ID+":"+finding.Evidence["user"].(string))
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	got := strings.Join(run("first"), ",")
+	want := "account_empty_password:bob,account_service_shell:www-data,account_uid0:toor,account_weak_hash:alice"
+	if got != want {
+		t.Fatalf("first run: got %s, want %s", got, want)
+	}
+
+	write("etc/passwd", "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/zsh\nbob:x:1001:1001::/home/bob:/bin/bash\nmallory:x:1002:1002::/home/mallory:/bin/bash\n")
+	write("etc/shadow", "root:*:1::::::\nalice:*:1::::::\nbob:*:1::::::\nmallory:*:1::::::\n")
+	write("etc/group", "root:x:0:\nsudo:x:27:alice,mallory\nusers:x:100:alice\n")
+	write("etc/sudoers.d/mallory", "mallory ALL=(ALL) NOPASSWD: \\\n    ALL\n")
+	write("etc/sudoers.d/ignored.bak", "eve ALL=(ALL) ALL\n")
+
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("drift: run: %v", err)
+	}
+	ids := []string{}
+	for _, finding := range result.Findings {
+		ids = append(ids, finding.ID)
+		if finding.ID == "sudo_grant_added" && finding.Evidence["rule"] != "mallory ALL=(ALL) NOPASSWD: ALL" {
+			t.Fatalf("unexpected sudo rule %v", finding.Evidence["rule"])
+		}
+	}
+	sort.Strings(ids)
+	got = strings.Join(ids, ",")
+	want = "account_added,account_changed,account_removed,account_removed,account_removed,group_member_added,group_member_removed,sudo_grant_added"
+	if got != want {
+		t.Fatalf("drift: got %s, want %s", got, want)
+	}
+
+	// Widening an alias, relaxing Defaults for a user or a rule keyed by UID
+	// grants as much as a new user specification.
+	write("etc/sudoers", "Defaults env_reset\nDefaults:mallory !authenticate\nUser_Alias ADMINS = alice, mallory\nroot ALL=(ALL:ALL) ALL\n%sudo ALL=(ALL:ALL) ALL\nADMINS ALL=(ALL) ALL\n#1002 ALL=(ALL) NOPASSWD: ALL # uid rule\n@includedir /etc/sudoers.d\n")
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("sudoers: run: %v", err)
+	}
+	rules := []string{}
+	for _, finding := range result.Findings {
+		if finding.ID == "sudo_grant_added" {
+			rules = append(rules, fmt.Sprintf("%s/%v", finding.Evidence["rule"], finding.Evidence["nopasswd"]))
+		}
+	}
+	sort.Strings(rules)
+	got = strings.Join(rules, ",")
+	want = "#1002 ALL=(ALL) NOPASSWD: ALL/true,Defaults:mallory !authenticate/true,User_Alias ADMINS = alice, mallory/false"
+	if got != want {
+		t.Fatalf("sudoers: got %s, want %s", got, want)
+	}
+}
+
+func TestAccountsAcceptUnreadableSudoers(t *testing.T) {
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &Accounts{}
+	plugin.WithStore(store)
+	users := []passwdEntry{{Name: "root", Shell: "/bin/bash", Home: "/root"}}
+
+	if err := plugin.acceptSnapshot(accountData{users: users}); err == nil {
+		t.Fatalf("expected accepting without readable sudoers or a stored snapshot to fail")
+	}
+	readable := accountData{users: users, sudoReadable: true, sudoRules: []sudoRule{{File: "/etc/sudoers", Rule: "root ALL=(ALL:ALL) ALL"}}}
+	if err := plugin.acceptSnapshot(readable); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if err := plugin.acceptSnapshot(accountData{users: users}); err != nil {
+		t.Fatalf("accept unreadable: %v", err)
+	}
+	var snap accountSnapshot
+	if _, err := loadJSON(store, accountsBucket, "snapshot", &snap); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if strings.Join(snap.SudoRules, ",") != "root ALL=(ALL:ALL) ALL" {
+		t.Fatalf("sudo rules not carried forward: %v", snap.SudoRules)
+	}
+}
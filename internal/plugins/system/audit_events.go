@@ -0,0 +1,363 @@
+package system
+
+import (
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditRecord is one line of audit.log: type=X msg=audit(<sec>.<ms>:<serial>): fields.
+type auditRecord struct {
+	Type   string
+	Time   time.Time
+	Serial uint64
+	Fields map[string]string
+}
+
+// auditEvent is the set of records sharing a serial number, e.g. SYSCALL,
+// EXECVE, CWD, PATH and PROCTITLE for one execve.
+type auditEvent struct {
+	Time    time.Time
+	Serial  uint64
+	Records []auditRecord
+}
+
+// auditKeySeparator joins multiple -k keys in one hex-encoded key field.
+const auditKeySeparator = "\x01"
+
+// parseAuditRecord parses a raw or enriched (log_format = ENRICHED) record.
+// The enriched tail after 0x1d only repeats interpreted ids and is dropped.
+func parseAuditRecord(line string) (auditRecord, bool) {
+	record := auditRecord{}
+	line, _, _ = strings.Cut(line, "\x1d")
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), "type=")
+	if !ok {
+		// ausearch --raw through syslog prefixes the record.
+		_, after, found := strings.Cut(line, " type=")
+		if !found {
+			return record, false
+		}
+		rest = after
+	}
+	recordType, rest, ok := strings.Cut(rest, " msg=audit(")
+	if !ok {
+		return record, false
+	}
+	stamp, rest, ok := strings.Cut(rest, "):")
+	if !ok {
+		return record, false
+	}
+	clock, serial, ok := strings.Cut(stamp, ":")
+	if !ok {
+		return record, false
+	}
+	seconds, err := strconv.ParseFloat(clock, 64)
+	if err != nil {
+		return record, false
+	}
+	record.Serial, err = strconv.ParseUint(serial, 10, 64)
+	if err != nil {
+		return record, false
+	}
+	record.Type = recordType
+	record.Time = time.UnixMilli(int64(seconds * 1000)).UTC()
+	record.Fields = parseAuditFields(recordType, rest)
+	return record, true
+}
+
+// parseAuditFields splits key=value pairs. Values are "quoted", hex encoded
+// (untrusted strings with spaces or control characters) or bare. User space
+// records carry their own fields inside msg='...', which are flattened.
+func parseAuditFields(recordType, raw string) map[string]string {
+	fields := map[string]string{}
+	for raw = strings.TrimSpace(raw); raw != ""; raw = strings.TrimSpace(raw) {
+		name, rest, ok := strings.Cut(raw, "=")
+		if !ok {
+			break
+		}
+		name = strings.TrimSpace(name)
+		var value string
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, raw = rest[1:], ""
+			} else {
+				value, raw = rest[1:end+1], rest[end+2:]
+			}
+			fields[name] = value
+			continue
+		case strings.HasPrefix(rest, "'"):
+			end := strings.IndexByte(rest[1:], '\'')
+			if end < 0 {
+				value, raw = rest[1:], ""
+			} else {
+				value, raw = rest[1:end+1], rest[end+2:]
+			}
+			for key, inner := range parseAuditFields(recordType, value) {
+				if _, exists := fields[key]; !exists {
+					fields[key] = inner
+				}
+			}
+			continue
+		}
+		value, raw, _ = strings.Cut(rest, " ")
+		fields[name] = decodeAuditValue(recordType, name, value)
+	}
+	return fields
+}
+
+// decodeAuditValue decodes an unquoted value that auditd hex encoded.
+// Numeric fields (uid, pid, arch, ...) are left as they are.
+func decodeAuditValue(recordType, name, value string) string {
+	if value == "(null)" || value == "(none)" {
+		return ""
+	}
+	if !auditEncodedField(recordType, name) || len(value)%2 != 0 {
+		return value
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	if name == "proctitle" {
+		// The argv of the process, NUL separated.
+		return strings.TrimSpace(strings.ReplaceAll(string(decoded), "\x00", " "))
+	}
+	return string(decoded)
+}
+
+func auditEncodedField(recordType, name string) bool {
+	switch name {
+	case "proctitle", "key", "name", "exe", "comm", "cwd", "acct", "cmd", "data":
+		return true
+	}
+	// EXECVE arguments a0, a1, ... and the chunks a1[0], a1[1], ... of long
+	// ones; in SYSCALL records a0-a3 are registers.
+	if recordType != "EXECVE" || len(name) < 2 || name[0] != 'a' {
+		return false
+	}
+	index, chunk, chunked := strings.Cut(name[1:], "[")
+	if _, err := strconv.Atoi(index); err != nil {
+		return false
+	}
+	if !chunked {
+		return true
+	}
+	_, err := strconv.Atoi(strings.TrimSuffix(chunk, "]"))
+	return err == nil && strings.HasSuffix(chunk, "]")
+}
+
+// auditAssembler groups records into events. The kernel closes multi-record
+// events with an EOE record; user space events are a single record.
+type auditAssembler struct {
+	pending map[uint64]*auditEvent
+	order   []uint64
+	limit   int
+}
+
+func newAuditAssembler(limit int) *auditAssembler {
+	return &auditAssembler{pending: map[uint64]*auditEvent{}, limit: limit}
+}
+
+// add returns the events completed by record.
+func (a *auditAssembler) add(record auditRecord) []auditEvent {
+	done := []auditEvent{}
+	if record.Type == "EOE" {
+		if event, ok := a.pending[record.Serial]; ok {
+			done = append(done, *event)
+			a.remove(record.Serial)
+		}
+		return done
+	}
+	if auditUserRecord(record.Type) {
+		return append(done, auditEvent{Time: record.Time, Serial: record.Serial, Records: []auditRecord{record}})
+	}
+	event, ok := a.pending[record.Serial]
+	if !ok {
+		event = &auditEvent{Time: record.Time, Serial: record.Serial}
+		a.pending[record.Serial] = event
+		a.order = append(a.order, record.Serial)
+	}
+	event.Records = append(event.Records, record)
+	// Records of one event are written together, so anything this far
+	// behind lost its EOE.
+	for len(a.order) > a.limit {
+		oldest := a.order[0]
+		done = append(done, *a.pending[oldest])
+		a.remove(oldest)
+	}
+	return done
+}
+
+// flush returns the events still open, oldest first.
+func (a *auditAssembler) flush() []auditEvent {
+	done := make([]auditEvent, 0, len(a.order))
+	for _, serial := range a.order {
+		done = append(done, *a.pending[serial])
+	}
+	a.pending, a.order = map[uint64]*auditEvent{}, nil
+	return done
+}
+
+func (a *auditAssembler) remove(serial uint64) {
+	delete(a.pending, serial)
+	for i, candidate := range a.order {
+		if candidate == serial {
+			a.order = append(a.order[:i], a.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// auditUserRecord reports whether the type comes from user space (PAM,
+// shadow-utils, systemd, ...), which never spans several records.
+func auditUserRecord(recordType string) bool {
+	return strings.HasPrefix(recordType, "USER_") || strings.HasPrefix(recordType, "CRED_") ||
+		strings.HasPrefix(recordType, "SERVICE_") || strings.HasPrefix(recordType, "ADD_") ||
+		strings.HasPrefix(recordType, "DEL_") || strings.HasPrefix(recordType, "GRP_") ||
+		strings.HasPrefix(recordType, "ACCT_") || recordType == "LOGIN" || recordType == "DAEMON_START"
+}
+
+func (e auditEvent) record(recordType string) (auditRecord, bool) {
+	for _, record := range e.Records {
+		if record.Type == recordType {
+			return record, true
+		}
+	}
+	return auditRecord{}, false
+}
+
+func (e auditEvent) Types() []string {
+	types := []string{}
+	for _, record := range e.Records {
+		types = appendUnique(types, record.Type)
+	}
+	return types
+}
+
+// Keys returns the audit rule keys that matched, from the SYSCALL (or the
+// first record carrying one).
+func (e auditEvent) Keys() []string {
+	for _, record := range e.Records {
+		if key := record.Fields["key"]; key != "" {
+			return strings.Split(key, auditKeySeparator)
+		}
+	}
+	return nil
+}
+
+// Argv rebuilds the command line from EXECVE, falling back to PROCTITLE,
+// which the kernel truncates to 128 bytes. Long command lines span several
+// EXECVE records, and long arguments are logged as aN_len followed by the
+// chunks aN[0], aN[1], ...
+func (e auditEvent) Argv() string {
+	fields := map[string]string{}
+	for _, record := range e.Records {
+		if record.Type != "EXECVE" {
+			continue
+		}
+		for name, value := range record.Fields {
+			fields[name] = value
+		}
+	}
+	argc, _ := strconv.Atoi(fields["argc"])
+	args := make([]string, 0, argc)
+	for i := 0; i < argc; i++ {
+		arg, ok := execveArg(fields, i)
+		if !ok {
+			break
+		}
+		args = append(args, arg)
+	}
+	if len(args) > 0 {
+		return strings.Join(args, " ")
+	}
+	if record, ok := e.record("PROCTITLE"); ok {
+		return record.Fields["proctitle"]
+	}
+	return ""
+}
+
+// execveArg returns argument i, reassembling it from its chunks when the
+// kernel split it.
+func execveArg(fields map[string]string, i int) (string, bool) {
+	name := "a" + strconv.Itoa(i)
+	if arg, ok := fields[name]; ok {
+		return arg, true
+	}
+	if _, ok := fields[name+"_len"]; !ok {
+		return "", false
+	}
+	var arg strings.Builder
+	for chunk := 0; ; chunk++ {
+		part, ok := fields[name+"["+strconv.Itoa(chunk)+"]"]
+		if !ok {
+			break
+		}
+		arg.WriteString(part)
+	}
+	return arg.String(), true
+}
+
+// Paths lists the PATH record names, skipping the parent directory items.
+func (e auditEvent) Paths() []string {
+	paths := []string{}
+	for _, record := range e.Records {
+		if record.Type != "PATH" || record.Fields["nametype"] == "PARENT" {
+			continue
+		}
+		if name := record.Fields["name"]; name != "" {
+			paths = appendUnique(paths, name)
+		}
+	}
+	return paths
+}
+
+// evidence flattens the interesting fields of the event for a finding.
+func (e auditEvent) evidence() map[string]interface{} {
+	evidence := map[string]interface{}{
+		"serial": e.Serial,
+		"time":   e.Time.Format(time.RFC3339),
+		"types":  e.Types(),
+	}
+	if keys := e.Keys(); len(keys) > 0 {
+		evidence["keys"] = keys
+	}
+	fields := map[string]string{}
+	for _, recordType := range []string{"SYSCALL", "USER_AUTH", "USER_CMD", "USER_LOGIN", "USER_ACCT"} {
+		if record, ok := e.record(recordType); ok {
+			fields = record.Fields
+			break
+		}
+	}
+	if len(fields) == 0 && len(e.Records) > 0 {
+		fields = e.Records[0].Fields
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch name {
+		case "syscall", "success", "exit", "pid", "ppid", "uid", "auid", "euid", "ses", "tty",
+			"comm", "exe", "acct", "addr", "hostname", "terminal", "res", "op", "cmd":
+			if fields[name] != "" {
+				evidence[name] = fields[name]
+			}
+		}
+	}
+	if record, ok := e.record("CWD"); ok && record.Fields["cwd"] != "" {
+		evidence["cwd"] = record.Fields["cwd"]
+	}
+	if argv := e.Argv(); argv != "" {
+		evidence["command"] = argv
+	}
+	if paths := e.Paths(); len(paths) > 0 {
+		evidence["paths"] = paths
+	}
+	return evidence
+}
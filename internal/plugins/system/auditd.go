@@ -0,0 +1,278 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+// Auditd tails the Linux audit log, reassembles events by serial number and
+// reports events carrying the keys of the host's audit rules.
+type Auditd struct {
+	path         string
+	maxLines     int
+	types        map[string]bool
+	keyRules     []auditKeyRule
+	authFailures bool
+	maxFindings  int
+	pendingLimit int
+	store        storage.Store
+}
+
+// auditKeyRule maps audit keys (globs) to the severity of their findings.
+type auditKeyRule struct {
+	Keys        globSet
+	Severity    scanner.Severity
+	Description string
+}
+
+var defaultAuditKeyRules = []interface{}{
+	map[string]interface{}{"keys": []interface{}{"*"}, "severity": "medium"},
+}
+
+func (a *Auditd) Name() string { return "system.auditd" }
+
+func (a *Auditd) WithStore(store storage.Store) {
+	a.store = store
+}
+
+func (a *Auditd) Init(config map[string]interface{}) error {
+	a.path = "/var/log/audit/audit.log"
+	if v, ok := config["path"].(string); ok && v != "" {
+		a.path = v
+	}
+	a.maxLines = 2000
+	if v, ok := config["max_lines"].(float64); ok && v > 0 {
+		a.maxLines = int(v)
+	}
+	types := []string{"EXECVE", "USER_AUTH", "SYSCALL", "PATH"}
+	if v, ok := configStrings(config, "types"); ok {
+		types = v
+	}
+	a.types = map[string]bool{}
+	for _, recordType := range types {
+		a.types[strings.ToUpper(recordType)] = true
+	}
+	rawRules := defaultAuditKeyRules
+	if v, ok := config["key_rules"].([]interface{}); ok {
+		rawRules = v
+	}
+	a.keyRules = make([]auditKeyRule, 0, len(rawRules))
+	for i, raw := range rawRules {
+		rule, err := parseAuditKeyRule(raw)
+		if err != nil {
+			return fmt.Errorf("key_rules[%d]: %w", i, err)
+		}
+		a.keyRules = append(a.keyRules, rule)
+	}
+	a.authFailures = true
+	if v, ok := config["auth_failures"].(bool); ok {
+		a.authFailures = v
+	}
+	a.maxFindings = 50
+	if v, ok := config["max_findings_per_key"].(float64); ok && v > 0 {
+		a.maxFindings = int(v)
+	}
+	a.pendingLimit = 256
+	return nil
+}
+
+func parseAuditKeyRule(raw interface{}) (auditKeyRule, error) {
+	rule := auditKeyRule{Severity: scanner.SeverityMedium}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return rule, fmt.Errorf("must be an object")
+	}
+	keys, _ := configStrings(fields, "keys")
+	if v, ok := fields["key"].(string); ok && v != "" {
+		keys = append(keys, v)
+	}
+	if len(keys) == 0 {
+		return rule, fmt.Errorf("key or keys is required")
+	}
+	globs, err := compileGlobs(keys)
+	if err != nil {
+		return rule, err
+	}
+	rule.Keys = globs
+	if v, ok := fields["severity"].(string); ok && v != "" {
+		switch scanner.Severity(v) {
+		case scanner.SeverityInfo, scanner.SeverityLow, scanner.SeverityMedium, scanner.SeverityHigh, scanner.SeverityCritical:
+			rule.Severity = scanner.Severity(v)
+		default:
+			return rule, fmt.Errorf("unknown severity %q", v)
+		}
+	}
+	rule.Description, _ = fields["description"].(string)
+	return rule, nil
+}
+
+// Run reads the records appended since the previous run. Events still open
+// when the read ends are evaluated as they are, so an event being written
+// at that moment may be reported without its trailing records.
+func (a *Auditd) Run(_ context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: a.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"path":      a.path,
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	records, events := 0, 0
+	byType, byKey, reported := map[string]int{}, map[string]int{}, map[string]int{}
+	suppressed := 0
+	handle := func(event auditEvent) {
+		if !a.exposed(event) {
+			return
+		}
+		events++
+		for _, recordType := range event.Types() {
+			byType[recordType]++
+		}
+		for _, finding := range a.eventFindings(event, byKey) {
+			if reported[finding.ID] >= a.maxFindings {
+				suppressed++
+				continue
+			}
+			reported[finding.ID]++
+			result.Findings = append(result.Findings, finding)
+		}
+	}
+
+	assembler := newAuditAssembler(a.pendingLimit)
+	stats, err := tailLog(a.store, a.path, a.maxLines, func(line string) {
+		record, ok := parseAuditRecord(line)
+		if !ok {
+			return
+		}
+		records++
+		for _, event := range assembler.add(record) {
+			handle(event)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	for _, event := range assembler.flush() {
+		handle(event)
+	}
+
+	result.Metadata["lines_scanned"] = stats.Lines
+	result.Metadata["records"] = records
+	result.Metadata["events"] = events
+	result.Metadata["events_by_type"] = byType
+	result.Metadata["events_by_key"] = byKey
+	// Flat per-key counts for detection rules, e.g. "metric": "key.identity".
+	for key, count := range byKey {
+		result.Metadata["key."+key] = count
+	}
+	if suppressed > 0 {
+		result.Metadata["findings_suppressed"] = suppressed
+	}
+	if stats.Rotated {
+		result.Metadata["rotated"] = true
+	}
+	if stats.Truncated {
+		result.Metadata["truncated"] = true
+	}
+	return result, nil
+}
+
+func (a *Auditd) Halt(_ context.Context) error { return nil }
+
+func (a *Auditd) exposed(event auditEvent) bool {
+	for _, recordType := range event.Types() {
+		if a.types[recordType] {
+			return true
+		}
+	}
+	return false
+}
+
+// eventFindings reports each key of the event that a key rule covers, and
+// failed USER_AUTH attempts. byKey counts every key seen.
+func (a *Auditd) eventFindings(event auditEvent, byKey map[string]int) []scanner.Finding {
+	findings := []scanner.Finding{}
+	for _, key := range event.Keys() {
+		byKey[key]++
+		rule, ok := a.keyRule(key)
+		if !ok {
+			continue
+		}
+		evidence := event.evidence()
+		evidence["key"] = key
+		description := rule.Description
+		if description == "" {
+			description = fmt.Sprintf("Audit rule %s matched %s", key, auditSummary(event))
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "audit_" + auditKeyID(key),
+			Severity:    rule.Severity,
+			Category:    "audit",
+			Description: description,
+			Evidence:    evidence,
+			Remediation: "Review the audited activity with ausearch -k and confirm it was expected.",
+		})
+	}
+	if record, ok := event.record("USER_AUTH"); ok && a.authFailures && record.Fields["res"] == "failed" {
+		findings = append(findings, scanner.Finding{
+			ID:          "audit_auth_failed",
+			Severity:    scanner.SeverityMedium,
+			Category:    "auth",
+			Description: fmt.Sprintf("Authentication failed for %s via %s", auditField(record, "acct"), auditField(record, "exe")),
+			Evidence:    event.evidence(),
+			Remediation: "Review the source of repeated failures and block it if unexpected.",
+		})
+	}
+	return findings
+}
+
+func (a *Auditd) keyRule(key string) (auditKeyRule, bool) {
+	for _, rule := range a.keyRules {
+		if rule.Keys.Match(key) {
+			return rule, true
+		}
+	}
+	return auditKeyRule{}, false
+}
+
+// auditSummary names what the event did: the command, the paths touched or
+// the record type.
+func auditSummary(event auditEvent) string {
+	if argv := event.Argv(); argv != "" {
+		return fmt.Sprintf("command %q", argv)
+	}
+	if paths := event.Paths(); len(paths) > 0 {
+		return strings.Join(limitStrings(paths, 3), ", ")
+	}
+	if record, ok := event.record("SYSCALL"); ok {
+		return fmt.Sprintf("syscall %s by %s", auditField(record, "syscall"), auditField(record, "exe"))
+	}
+	return strings.Join(event.Types(), ",")
+}
+
+func auditField(record auditRecord, name string) string {
+	if value := record.Fields[name]; value != "" {
+		return value
+	}
+	return "?"
+}
+
+// auditKeyID turns an audit key into a finding ID suffix.
+func auditKeyID(key string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
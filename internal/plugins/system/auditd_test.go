@@ -0,0 +1,119 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestParseAuditRecord(t *testing.T) {
+	record, ok := parseAuditRecord(`type=PROCTITLE msg=audit(1700000000.123:42): proctitle=62617368002D63006964`)
+	if !ok || record.Type != "PROCTITLE" || record.Serial != 42 || record.Fields["proctitle"] != "bash -c id" {
+		t.Fatalf("unexpected record: %+v", record)
+	}
+	record, ok = parseAuditRecord("type=USER_AUTH msg=audit(1700000001.000:43): pid=10 uid=0 auid=4294967295 ses=4294967295 msg='op=PAM:authentication grantors=? acct=\"alice\" exe=\"/usr/sbin/sshd\" hostname=10.0.0.5 addr=10.0.0.5 terminal=ssh res=failed'\x1dUID=\"root\"")
+	if !ok || record.Fields["acct"] != "alice" || record.Fields["res"] != "failed" || record.Fields["addr"] != "10.0.0.5" {
+		t.Fatalf("unexpected user record: %+v", record)
+	}
+	if _, ok := record.Fields["UID"]; ok {
+		t.Fatalf("enriched fields should be dropped")
+	}
+	// Multiple keys are hex encoded and separated by 0x01.
+	record, _ = parseAuditRecord(`type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59 a0=55d0 key=6578656301726F6F74`)
+	if record.Fields["a0"] != "55d0" || record.Fields["key"] != "exec\x01root" {
+		t.Fatalf("unexpected syscall fields: %+v", record.Fields)
+	}
+}
+
+func TestAuditEventChunkedArgv(t *testing.T) {
+	// A long argument is split into chunks, hex encoded or quoted, and the
+	// arguments after it follow in another EXECVE record.
+	event := auditEvent{}
+	for _, line := range []string{
+		`type=EXECVE msg=audit(1700000000.123:42): argc=4 a0="perl" a1_len=8 a1[0]=2D65207072 a1[1]="int"`,
+		`type=EXECVE msg=audit(1700000000.123:42): a2=2F746D702F78 a3="y"`,
+		`type=PROCTITLE msg=audit(1700000000.123:42): proctitle=7065726C002D65`,
+	} {
+		record, ok := parseAuditRecord(line)
+		if !ok {
+			t.Fatalf("parse %q", line)
+		}
+		event.Records = append(event.Records, record)
+	}
+	if got := event.Argv(); got != "perl -e print /tmp/x y" {
+		t.Fatalf("argv: %q", got)
+	}
+}
+
+func TestAuditdEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := []string{
+		`type=SYSCALL msg=audit(1700000000.123:42): arch=c000003e syscall=59 success=yes exit=0 a0=55d0 ppid=1 pid=2 auid=1000 uid=0 comm="bash" exe="/usr/bin/bash" key="exec"`,
+		`type=EXECVE msg=audit(1700000000.123:42): argc=3 a0="bash" a1="-c" a2=6964`,
+		`type=CWD msg=audit(1700000000.123:42): cwd="/root"`,
+		`type=PATH msg=audit(1700000000.123:42): item=0 name="/usr/bin/bash" nametype=NORMAL`,
+		`type=PROCTITLE msg=audit(1700000000.123:42): proctitle=62617368002D63006964`,
+		`type=EOE msg=audit(1700000000.123:42): `,
+		`type=USER_AUTH msg=audit(1700000001.000:43): pid=10 uid=0 msg='op=PAM:authentication acct="alice" exe="/usr/sbin/sshd" addr=10.0.0.5 res=failed'`,
+		`type=SYSCALL msg=audit(1700000002.000:44): arch=c000003e syscall=257 success=yes exe="/usr/bin/vi" key="identity"`,
+		`type=PATH msg=audit(1700000002.000:44): item=0 name="/etc/" nametype=PARENT`,
+		`type=PATH msg=audit(1700000002.000:44): item=1 name="/etc/shadow" nametype=NORMAL`,
+		`type=SERVICE_START msg=audit(1700000003.000:45): pid=1 uid=0 msg='unit=cron res=success'`,
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	plugin := &Auditd{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{
+		"path": path,
+		"key_rules": []interface{}{
+			map[string]interface{}{"key": "identity", "severity": "high"},
+			map[string]interface{}{"keys": []interface{}{"exec*"}, "severity": "low"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	byID := map[string]map[string]interface{}{}
+	for _, finding := range result.Findings {
+		byID[finding.ID] = finding.Evidence
+	}
+	if len(result.Findings) != 3 {
+		t.Fatalf("expected 3 findings, got %+v", result.Findings)
+	}
+	if exec := byID["audit_exec"]; exec == nil || exec["command"] != "bash -c id" || exec["cwd"] != "/root" {
+		t.Fatalf("unexpected exec evidence: %+v", exec)
+	}
+	if identity := byID["audit_identity"]; identity == nil || identity["paths"].([]string)[0] != "/etc/shadow" {
+		t.Fatalf("unexpected identity evidence: %+v", identity)
+	}
+	if auth := byID["audit_auth_failed"]; auth == nil || auth["acct"] != "alice" {
+		t.Fatalf("unexpected auth evidence: %+v", auth)
+	}
+	// SERVICE_START is not an exposed type.
+	if result.Metadata["events"] != 3 || result.Metadata["key.identity"] != 1 {
+		t.Fatalf("unexpected metadata: %+v", result.Metadata)
+	}
+
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Findings) != 0 {
+		t.Fatalf("expected cursor to skip read records, got %+v", result.Findings)
+	}
+}
@@ -0,0 +1,235 @@
+package system
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	authLoginFailed   = "login_failed"
+	authPAMFailure    = "pam_failure"
+	authLoginSuccess  = "login_success"
+	authInvalidUser   = "invalid_user"
+	authSudo          = "sudo"
+	authSudoFailed    = "sudo_failed"
+	authSudoDenied    = "sudo_denied"
+	authSuSuccess     = "su_success"
+	authSuFailed      = "su_failed"
+	authUserAdded     = "user_added"
+	authUserDeleted   = "user_deleted"
+	authGroupMember   = "group_member_added"
+	authPasswdChanged = "password_changed"
+)
+
+// authEvent is a normalized sshd, sudo, su, PAM or shadow-utils log entry.
+type authEvent struct {
+	Time       time.Time
+	Host       string
+	Program    string
+	PID        int
+	Kind       string
+	User       string
+	TargetUser string
+	SourceIP   string
+	SourcePort int
+	Method     string
+	Command    string
+	Group      string
+	UID        int
+	Count      int
+	Line       string
+	Journal    journalEntry
+}
+
+var (
+	sshFailedRe     = regexp.MustCompile(`^Failed (\S+) for (invalid user )?(.*?) from (\S+) port (\d+)`)
+	sshAcceptedRe   = regexp.MustCompile(`^Accepted (\S+) for (.*?) from (\S+) port \d+`)
+	sshInvalidRe    = regexp.MustCompile(`^Invalid user (.*?) from (\S+)(?: port (\d+))?`)
+	repeatedRe      = regexp.MustCompile(`^message repeated (\d+) times: \[ ?(.*?) ?\]$`)
+	pamAuthFailRe   = regexp.MustCompile(`^pam_unix\(([^:]+):auth\): authentication failure;(.*)$`)
+	pamPasswdRe     = regexp.MustCompile(`^pam_unix\([^:]+:chauthtok\): password changed for (\S+)`)
+	suRe            = regexp.MustCompile(`^(FAILED SU )?\(to (\S+)\) (\S+) on (\S+)`)
+	suShadowRe      = regexp.MustCompile(`^(Successful|FAILED) su for (\S+) by (\S+)`)
+	useraddRe       = regexp.MustCompile(`^new user: name=([^,]+), UID=(\d+)`)
+	userdelRe       = regexp.MustCompile(`^delete user '([^']+)'`)
+	groupMemberRe   = regexp.MustCompile(`^add '([^']+)' to (?:shadow )?group '([^']+)'`)
+	syslogProgramRe = regexp.MustCompile(`^([^\s\[:]+)(?:\[(\d+)\])?:\s?(.*)$`)
+)
+
+// parseSyslogLine splits a traditional ("Oct  6 10:00:00 host prog[1]: msg")
+// or RFC3339-stamped syslog line. Traditional stamps carry no year, so the
+// year that puts the stamp closest to, but not after, now is used.
+func parseSyslogLine(line string, now time.Time) (ts time.Time, host, program string, pid int, message string, ok bool) {
+	rest := ""
+	if len(line) > 16 && line[3] == ' ' {
+		parsed, err := time.ParseInLocation("Jan _2 15:04:05", line[:15], now.Location())
+		if err != nil {
+			return ts, "", "", 0, "", false
+		}
+		ts = parsed.AddDate(now.Year(), 0, 0)
+		if ts.After(now.Add(24 * time.Hour)) {
+			ts = ts.AddDate(-1, 0, 0)
+		}
+		rest = line[16:]
+	} else {
+		stamp, after, found := strings.Cut(line, " ")
+		if !found {
+			return ts, "", "", 0, "", false
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, stamp)
+		if err != nil {
+			return ts, "", "", 0, "", false
+		}
+		ts, rest = parsed, after
+	}
+	host, rest, found := strings.Cut(rest, " ")
+	if !found {
+		return ts, "", "", 0, "", false
+	}
+	match := syslogProgramRe.FindStringSubmatch(rest)
+	if match == nil {
+		return ts, "", "", 0, "", false
+	}
+	pid, _ = strconv.Atoi(match[2])
+	return ts, host, match[1], pid, match[3], true
+}
+
+func parseAuthLine(line string, now time.Time) (authEvent, bool) {
+	ts, host, program, pid, message, ok := parseSyslogLine(line, now)
+	if !ok {
+		return authEvent{}, false
+	}
+	event, ok := parseAuthMessage(program, message)
+	if !ok {
+		return authEvent{}, false
+	}
+	event.Time, event.Host, event.PID, event.Line = ts, host, pid, line
+	return event, true
+}
+
+func parseAuthEntry(entry journalEntry) (authEvent, bool) {
+	event, ok := parseAuthMessage(entry.Identifier(), entry["MESSAGE"])
+	if !ok {
+		return authEvent{}, false
+	}
+	event.Time, event.Host, event.PID = entry.Time(), entry["_HOSTNAME"], entry.PID()
+	event.Line, event.Journal = entry.Line(), entry
+	return event, true
+}
+
+// parseAuthMessage recognises the message part of an auth log entry for the
+// given syslog identifier.
+func parseAuthMessage(program, message string) (authEvent, bool) {
+	event := authEvent{Program: program, Count: 1, UID: -1}
+	if match := repeatedRe.FindStringSubmatch(message); match != nil {
+		event.Count, _ = strconv.Atoi(match[1])
+		message = match[2]
+	}
+
+	if match := pamAuthFailRe.FindStringSubmatch(message); match != nil {
+		fields := pamFields(match[2])
+		event.Kind, event.Method = authLoginFailed, "pam:"+match[1]
+		// sshd logs its own "Failed ..." line for the same attempt.
+		if match[1] == "sshd" {
+			event.Kind = authPAMFailure
+		}
+		event.User, event.SourceIP = fields["user"], fields["rhost"]
+		if event.User == "" {
+			event.User = fields["ruser"]
+		}
+		return event, true
+	}
+	if match := pamPasswdRe.FindStringSubmatch(message); match != nil {
+		event.Kind, event.User = authPasswdChanged, match[1]
+		return event, true
+	}
+
+	switch program {
+	case "sshd":
+		if match := sshFailedRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.Method, event.User, event.SourceIP = authLoginFailed, match[1], match[3], match[4]
+			event.SourcePort, _ = strconv.Atoi(match[5])
+			return event, true
+		}
+		if match := sshAcceptedRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.Method, event.User, event.SourceIP = authLoginSuccess, match[1], match[2], match[3]
+			return event, true
+		}
+		if match := sshInvalidRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.User, event.SourceIP = authInvalidUser, match[1], match[2]
+			event.SourcePort, _ = strconv.Atoi(match[3])
+			return event, true
+		}
+	case "sudo":
+		user, rest, found := strings.Cut(message, " : ")
+		if !found {
+			return authEvent{}, false
+		}
+		event.User, event.Method = strings.TrimSpace(user), "sudo"
+		fields := map[string]string{}
+		parts := strings.Split(rest, " ; ")
+		for _, part := range parts {
+			if key, value, ok := strings.Cut(part, "="); ok {
+				fields[key] = value
+			}
+		}
+		event.TargetUser, event.Command = fields["USER"], fields["COMMAND"]
+		switch {
+		case strings.Contains(parts[0], "incorrect password attempt"):
+			event.Kind = authSudoFailed
+		case strings.Contains(parts[0], "NOT in sudoers"), strings.Contains(parts[0], "command not allowed"):
+			event.Kind = authSudoDenied
+		case event.Command != "":
+			event.Kind = authSudo
+		default:
+			return authEvent{}, false
+		}
+		return event, true
+	case "su":
+		if match := suRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.TargetUser, event.User, event.Method = authSuSuccess, match[2], match[3], "su"
+			if match[1] != "" {
+				event.Kind = authSuFailed
+			}
+			return event, true
+		}
+		if match := suShadowRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.TargetUser, event.User, event.Method = authSuSuccess, match[2], match[3], "su"
+			if match[1] == "FAILED" {
+				event.Kind = authSuFailed
+			}
+			return event, true
+		}
+	case "useradd":
+		if match := useraddRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.User = authUserAdded, match[1]
+			event.UID, _ = strconv.Atoi(match[2])
+			return event, true
+		}
+	case "userdel":
+		if match := userdelRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.User = authUserDeleted, match[1]
+			return event, true
+		}
+	case "usermod", "gpasswd":
+		if match := groupMemberRe.FindStringSubmatch(message); match != nil {
+			event.Kind, event.User, event.Group = authGroupMember, match[1], match[2]
+			return event, true
+		}
+	}
+	return authEvent{}, false
+}
+
+// pamFields parses the "key=value key=value" tail of pam_unix messages, where
+// values may be empty.
+func pamFields(raw string) map[string]string {
+	fields := map[string]string{}
+	for _, token := range strings.Fields(raw) {
+		if key, value, ok := strings.Cut(token, "="); ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
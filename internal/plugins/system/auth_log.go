@@ -1,24 +1,45 @@
 package system
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	authStateBucket  = "auth_log_state"
+	maxAuthFailures  = 10000
+	maxEvidenceUsers = 20
 )
 
 type AuthLogMonitor struct {
-	path           string
-	failedPatterns []string
-	maxLines       int
+	path                 string
+	source               string
+	journal              journalSource
+	failedPatterns       []string
+	maxLines             int
+	window               time.Duration
+	bruteForceThreshold  int
+	sprayThreshold       int
+	userFailureThreshold int
+	successAfterFailures int
+	privilegedGroups     map[string]bool
+	store                storage.Store
+	state                *authWindow
 }
 
 func (a *AuthLogMonitor) Name() string { return "system.auth_log" }
 
+func (a *AuthLogMonitor) WithStore(store storage.Store) {
+	a.store = store
+}
+
 func (a *AuthLogMonitor) Init(config map[string]interface{}) error {
 	a.path = "/var/log/auth.log"
 	a.failedPatterns = []string{
@@ -27,10 +48,25 @@ func (a *AuthLogMonitor) Init(config map[string]interface{}) error {
 		"Invalid user",
 	}
 	a.maxLines = 500
+	a.window = 10 * time.Minute
+	a.bruteForceThreshold = 10
+	a.sprayThreshold = 5
+	a.userFailureThreshold = 20
+	a.successAfterFailures = 5
+	a.state = nil
 
 	if v, ok := config["path"].(string); ok && v != "" {
 		a.path = v
 	}
+	a.source = "auto"
+	if v, ok := config["source"].(string); ok && v != "" {
+		a.source = v
+	}
+	if a.source != "auto" && a.source != "file" && a.source != "journal" {
+		return fmt.Errorf("source must be auto, file, or journal")
+	}
+	// sshd, sudo and su log to the auth and authpriv facilities.
+	a.journal = parseJournalSource(config, []string{"SYSLOG_FACILITY=4", "SYSLOG_FACILITY=10"})
 	if v, ok := config["failed_patterns"].([]interface{}); ok && len(v) > 0 {
 		a.failedPatterns = []string{}
 		for _, raw := range v {
@@ -42,37 +78,81 @@ func (a *AuthLogMonitor) Init(config map[string]interface{}) error {
 	if v, ok := config["max_lines"].(float64); ok && v > 0 {
 		a.maxLines = int(v)
 	}
+	if v, ok := config["window"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("window: invalid duration %q", v)
+		}
+		a.window = d
+	}
+	if v, ok := config["brute_force_threshold"].(float64); ok && v > 0 {
+		a.bruteForceThreshold = int(v)
+	}
+	if v, ok := config["spray_threshold"].(float64); ok && v > 0 {
+		a.sprayThreshold = int(v)
+	}
+	if v, ok := config["user_failure_threshold"].(float64); ok && v > 0 {
+		a.userFailureThreshold = int(v)
+	}
+	if v, ok := config["success_after_failures"].(float64); ok && v > 0 {
+		a.successAfterFailures = int(v)
+	}
+	groups := defaultPrivilegedGroups
+	if v, ok := configStrings(config, "privileged_groups"); ok {
+		groups = v
+	}
+	a.privilegedGroups = map[string]bool{}
+	for _, group := range groups {
+		a.privilegedGroups[group] = true
+	}
 	return nil
 }
 
-func (a *AuthLogMonitor) Run(_ context.Context) (*scanner.Result, error) {
-	file, err := os.Open(a.path)
-	if err != nil {
-		return nil, fmt.Errorf("open auth log: %w", err)
-	}
-	defer file.Close()
-
+// Run evaluates the lines appended since the previous run. max_lines only
+// bounds the backfill the first time a log is seen. Lines that are not
+// recognised sshd, sudo, su, PAM or shadow-utils entries fall back to the
+// failed_patterns substring match. With source "auto" the journal is read
+// when the log file does not exist.
+func (a *AuthLogMonitor) Run(ctx context.Context) (*scanner.Result, error) {
+	now := time.Now()
 	result := &scanner.Result{
 		ScannerName: a.Name(),
 		Status:      scanner.StatusSuccess,
 		Metadata: map[string]interface{}{
 			"path":      a.path,
-			"timestamp": time.Now().Format(time.RFC3339),
+			"timestamp": now.Format(time.RFC3339),
 		},
 	}
 
-	lines := readLastLines(file, a.maxLines)
-	for _, line := range lines {
+	if a.state == nil {
+		state, err := loadAuthWindow(a.store, a.path)
+		if err != nil {
+			return nil, err
+		}
+		a.state = state
+	}
+
+	parsed := 0
+	handle := func(event authEvent, ok bool, line string, extra map[string]interface{}) {
+		if ok {
+			parsed++
+			result.Findings = append(result.Findings, a.observe(event)...)
+			return
+		}
 		for _, pattern := range a.failedPatterns {
 			if strings.Contains(line, pattern) {
+				evidence := map[string]interface{}{
+					"line": line,
+				}
+				for key, value := range extra {
+					evidence[key] = value
+				}
 				result.Findings = append(result.Findings, scanner.Finding{
 					ID:          "auth_failed",
 					Severity:    scanner.SeverityMedium,
 					Category:    "auth",
 					Description: "Authentication failure detected",
-					Evidence: map[string]interface{}{
-						"line": line,
-					},
+					Evidence:    evidence,
 					Remediation: "Review auth logs and block suspicious sources.",
 				})
 				break
@@ -80,20 +160,399 @@ func (a *AuthLogMonitor) Run(_ context.Context) (*scanner.Result, error) {
 		}
 	}
 
-	result.Metadata["lines_scanned"] = len(lines)
+	if a.useJournal() {
+		result.Metadata["source"] = "journal"
+		entries, err := tailJournal(ctx, a.store, a.journal, a.maxLines, func(entry journalEntry) {
+			event, ok := parseAuthEntry(entry)
+			handle(event, ok, entry.Line(), entry.evidence())
+		})
+		if err != nil && entries == 0 {
+			return nil, fmt.Errorf("read journal: %w", err)
+		}
+		if err != nil {
+			// The cursor covers what was read, so keep those findings.
+			result.Status = scanner.StatusPartial
+			result.Metadata["error"] = fmt.Sprintf("read journal: %v", err)
+		}
+		result.Metadata["entries_scanned"] = entries
+	} else {
+		result.Metadata["source"] = "file"
+		stats, err := tailLog(a.store, a.path, a.maxLines, func(line string) {
+			event, ok := parseAuthLine(line, now)
+			handle(event, ok, line, nil)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read auth log: %w", err)
+		}
+		result.Metadata["lines_scanned"] = stats.Lines
+		if stats.Rotated {
+			result.Metadata["rotated"] = true
+		}
+		if stats.Truncated {
+			result.Metadata["truncated"] = true
+		}
+	}
+	if err := a.state.save(a.store, a.path, a.window); err != nil {
+		return nil, err
+	}
+	result.Metadata["events_parsed"] = parsed
 	return result, nil
 }
 
+func (a *AuthLogMonitor) useJournal() bool {
+	switch a.source {
+	case "journal":
+		return true
+	case "file":
+		return false
+	}
+	_, err := os.Stat(a.path)
+	return os.IsNotExist(err)
+}
+
 func (a *AuthLogMonitor) Halt(_ context.Context) error { return nil }
 
-func readLastLines(file *os.File, maxLines int) []string {
-	scanner := bufio.NewScanner(file)
-	lines := []string{}
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-		if len(lines) > maxLines {
-			lines = lines[1:]
+// observe feeds one event into the failure windows and returns the findings
+// it triggers.
+func (a *AuthLogMonitor) observe(event authEvent) []scanner.Finding {
+	findings := []scanner.Finding{}
+	window := a.window.String()
+	switch event.Kind {
+	case authLoginFailed, authInvalidUser:
+		// Key-only hosts never log "Failed password" for unknown users, so
+		// the "Invalid user" line is the only trace of the attempt. Where
+		// passwords are allowed, the first "Failed" line from the same sshd
+		// connection is that attempt again and is not counted twice.
+		count := event.Count
+		if event.Kind == authLoginFailed && a.state.claimInvalidUser(event) {
+			count--
+		}
+		for i := 0; i < count; i++ {
+			a.state.add(authFailure{
+				Time:     event.Time,
+				SourceIP: event.SourceIP,
+				User:     event.User,
+				PID:      event.PID,
+				Port:     event.SourcePort,
+				Pending:  event.Kind == authInvalidUser && event.PID > 0,
+			})
+		}
+		if event.SourceIP != "" {
+			failures := a.state.recent(a.state.byIP, event.SourceIP, event.Time, a.window)
+			users := failureUsers(failures)
+			if len(failures) >= a.bruteForceThreshold && a.state.alert("brute_force:"+event.SourceIP, event.Time, a.window) {
+				evidence := authEvidence(event)
+				evidence["failures"] = len(failures)
+				evidence["users"] = limitStrings(users, maxEvidenceUsers)
+				evidence["window"] = window
+				findings = append(findings, scanner.Finding{
+					ID:          "auth_brute_force",
+					Severity:    scanner.SeverityHigh,
+					Category:    "auth",
+					Description: fmt.Sprintf("%d failed logins from %s within %s", len(failures), event.SourceIP, window),
+					Evidence:    evidence,
+					Remediation: "Block the source address and confirm no login from it succeeded.",
+				})
+			}
+			if len(users) >= a.sprayThreshold && a.state.alert("spray:"+event.SourceIP, event.Time, a.window) {
+				evidence := authEvidence(event)
+				evidence["failures"] = len(failures)
+				evidence["users"] = limitStrings(users, maxEvidenceUsers)
+				evidence["window"] = window
+				findings = append(findings, scanner.Finding{
+					ID:          "auth_password_spray",
+					Severity:    scanner.SeverityHigh,
+					Category:    "auth",
+					Description: fmt.Sprintf("%s tried %d distinct users within %s", event.SourceIP, len(users), window),
+					Evidence:    evidence,
+					Remediation: "Block the source address and review password policy for the targeted accounts.",
+				})
+			}
+		}
+		if event.User != "" {
+			failures := a.state.recent(a.state.byUser, event.User, event.Time, a.window)
+			sources := failureSources(failures)
+			if len(failures) >= a.userFailureThreshold && len(sources) > 1 && a.state.alert("user:"+event.User, event.Time, a.window) {
+				evidence := authEvidence(event)
+				evidence["failures"] = len(failures)
+				evidence["source_ips"] = limitStrings(sources, maxEvidenceUsers)
+				evidence["window"] = window
+				findings = append(findings, scanner.Finding{
+					ID:          "auth_distributed_brute_force",
+					Severity:    scanner.SeverityHigh,
+					Category:    "auth",
+					Description: fmt.Sprintf("%d failed logins for %s from %d sources within %s", len(failures), event.User, len(sources), window),
+					Evidence:    evidence,
+					Remediation: "Lock or rotate credentials for the account and restrict where it may log in from.",
+				})
+			}
+		}
+	case authLoginSuccess:
+		if event.SourceIP == "" {
+			break
+		}
+		failures := a.state.recent(a.state.byIP, event.SourceIP, event.Time, a.window)
+		if len(failures) >= a.successAfterFailures {
+			evidence := authEvidence(event)
+			evidence["failures"] = len(failures)
+			evidence["users"] = limitStrings(failureUsers(failures), maxEvidenceUsers)
+			evidence["window"] = window
+			findings = append(findings, scanner.Finding{
+				ID:          "auth_success_after_failures",
+				Severity:    scanner.SeverityCritical,
+				Category:    "auth",
+				Description: fmt.Sprintf("%s logged in from %s after %d failures", event.User, event.SourceIP, len(failures)),
+				Evidence:    evidence,
+				Remediation: "Treat the account as compromised: terminate its sessions and rotate credentials.",
+			})
+		}
+	case authSudoDenied:
+		findings = append(findings, scanner.Finding{
+			ID:          "auth_sudo_denied",
+			Severity:    scanner.SeverityMedium,
+			Category:    "auth",
+			Description: fmt.Sprintf("sudo refused for %s", event.User),
+			Evidence:    authEvidence(event),
+			Remediation: "Confirm the user was expected to attempt privileged commands.",
+		})
+	case authSudoFailed:
+		findings = append(findings, scanner.Finding{
+			ID:          "auth_sudo_failed",
+			Severity:    scanner.SeverityMedium,
+			Category:    "auth",
+			Description: fmt.Sprintf("sudo password attempt failed for %s", event.User),
+			Evidence:    authEvidence(event),
+			Remediation: "Confirm the user mistyped their password and the account is not being guessed.",
+		})
+	case authSuFailed:
+		findings = append(findings, scanner.Finding{
+			ID:          "auth_su_failed",
+			Severity:    scanner.SeverityMedium,
+			Category:    "auth",
+			Description: fmt.Sprintf("su to %s failed for %s", event.TargetUser, event.User),
+			Evidence:    authEvidence(event),
+			Remediation: "Confirm the user was expected to switch accounts and the target password is not being guessed.",
+		})
+	case authUserAdded:
+		severity := scanner.SeverityMedium
+		if event.UID == 0 {
+			severity = scanner.SeverityCritical
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "auth_user_added",
+			Severity:    severity,
+			Category:    "auth",
+			Description: fmt.Sprintf("User %s created with UID %d", event.User, event.UID),
+			Evidence:    authEvidence(event),
+			Remediation: "Confirm the account was created through change management.",
+		})
+	case authGroupMember:
+		if a.privilegedGroups[event.Group] {
+			findings = append(findings, scanner.Finding{
+				ID:          "auth_privileged_group_added",
+				Severity:    scanner.SeverityHigh,
+				Category:    "auth",
+				Description: fmt.Sprintf("%s added to privileged group %s", event.User, event.Group),
+				Evidence:    authEvidence(event),
+				Remediation: "Remove the membership unless it was approved.",
+			})
+		}
+	}
+	return findings
+}
+
+func authEvidence(event authEvent) map[string]interface{} {
+	evidence := map[string]interface{}{
+		"program": event.Program,
+		"kind":    event.Kind,
+		"time":    event.Time.UTC().Format(time.RFC3339),
+		"line":    event.Line,
+	}
+	for key, value := range map[string]string{
+		"user":        event.User,
+		"target_user": event.TargetUser,
+		"source_ip":   event.SourceIP,
+		"method":      event.Method,
+		"command":     event.Command,
+		"group":       event.Group,
+	} {
+		if value != "" {
+			evidence[key] = value
+		}
+	}
+	if event.PID > 0 {
+		evidence["pid"] = event.PID
+	}
+	if event.UID >= 0 {
+		evidence["uid"] = event.UID
+	}
+	for key, value := range event.Journal.evidence() {
+		evidence[key] = value
+	}
+	return evidence
+}
+
+// authFailure is one failed attempt. PID and Port identify the sshd
+// connection; Pending marks an "Invalid user" attempt whose "Failed" line
+// has not been seen yet.
+type authFailure struct {
+	Time     time.Time `json:"time"`
+	SourceIP string    `json:"source_ip,omitempty"`
+	User     string    `json:"user,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	Port     int       `json:"port,omitempty"`
+	Pending  bool      `json:"pending,omitempty"`
+}
+
+type authWindowState struct {
+	Failures []authFailure        `json:"failures"`
+	Alerted  map[string]time.Time `json:"alerted"`
+}
+
+// authWindow holds recent login failures indexed by source and user. It is
+// persisted between runs so a window can span several log reads.
+type authWindow struct {
+	byIP    map[string][]authFailure
+	byUser  map[string][]authFailure
+	alerted map[string]time.Time
+	latest  time.Time
+}
+
+func loadAuthWindow(store storage.Store, path string) (*authWindow, error) {
+	window := &authWindow{byIP: map[string][]authFailure{}, byUser: map[string][]authFailure{}, alerted: map[string]time.Time{}}
+	var state authWindowState
+	if _, err := loadJSON(store, authStateBucket, path, &state); err != nil {
+		return nil, err
+	}
+	for _, failure := range state.Failures {
+		window.add(failure)
+	}
+	for key, at := range state.Alerted {
+		window.alerted[key] = at
+	}
+	return window, nil
+}
+
+func (w *authWindow) add(failure authFailure) {
+	if failure.SourceIP != "" {
+		w.byIP[failure.SourceIP] = append(w.byIP[failure.SourceIP], failure)
+	}
+	if failure.User != "" {
+		w.byUser[failure.User] = append(w.byUser[failure.User], failure)
+	}
+	if failure.Time.After(w.latest) {
+		w.latest = failure.Time
+	}
+}
+
+// claimInvalidUser matches a "Failed" event to a pending "Invalid user"
+// failure from the same sshd process and source port (older sshd omits the
+// port on the "Invalid user" line), reporting whether it was already counted.
+func (w *authWindow) claimInvalidUser(event authEvent) bool {
+	if event.PID <= 0 || event.SourceIP == "" {
+		return false
+	}
+	failures := w.byIP[event.SourceIP]
+	for i := range failures {
+		f := &failures[i]
+		if f.Pending && f.PID == event.PID && (f.Port == 0 || f.Port == event.SourcePort) {
+			f.Pending = false
+			return true
+		}
+	}
+	return false
+}
+
+// recent drops failures for key that fell out of the window ending at now and
+// returns the rest.
+func (w *authWindow) recent(index map[string][]authFailure, key string, now time.Time, window time.Duration) []authFailure {
+	cutoff := now.Add(-window)
+	kept := index[key][:0]
+	for _, failure := range index[key] {
+		if !failure.Time.Before(cutoff) && !failure.Time.After(now) {
+			kept = append(kept, failure)
+		}
+	}
+	if len(kept) == 0 {
+		delete(index, key)
+		return nil
+	}
+	index[key] = kept
+	return kept
+}
+
+// alert reports whether key has not alerted within the window, and marks it.
+func (w *authWindow) alert(key string, now time.Time, window time.Duration) bool {
+	if last, ok := w.alerted[key]; ok && now.Sub(last) < window {
+		return false
+	}
+	w.alerted[key] = now
+	return true
+}
+
+// save persists failures and alerts still inside the window ending at the
+// latest event. Each failure is stored once even though it is indexed twice.
+func (w *authWindow) save(store storage.Store, path string, window time.Duration) error {
+	if store == nil {
+		return nil
+	}
+	horizon := w.latest.Add(-window)
+	state := authWindowState{Failures: []authFailure{}, Alerted: map[string]time.Time{}}
+	for _, failures := range w.byIP {
+		for _, failure := range failures {
+			if failure.Time.After(horizon) {
+				state.Failures = append(state.Failures, failure)
+			}
+		}
+	}
+	for _, failures := range w.byUser {
+		for _, failure := range failures {
+			if failure.SourceIP == "" && failure.Time.After(horizon) {
+				state.Failures = append(state.Failures, failure)
+			}
+		}
+	}
+	sort.Slice(state.Failures, func(i, j int) bool { return state.Failures[i].Time.Before(state.Failures[j].Time) })
+	if len(state.Failures) > maxAuthFailures {
+		state.Failures = state.Failures[len(state.Failures)-maxAuthFailures:]
+	}
+	for key, at := range w.alerted {
+		if at.After(horizon) {
+			state.Alerted[key] = at
 		}
 	}
-	return lines
+	if err := saveJSON(store, authStateBucket, path, state); err != nil {
+		return fmt.Errorf("save auth state: %w", err)
+	}
+	return nil
+}
+
+// failureUsers returns the distinct users in failures, sorted.
+func failureUsers(failures []authFailure) []string {
+	return distinctFailures(failures, func(f authFailure) string { return f.User })
+}
+
+func failureSources(failures []authFailure) []string {
+	return distinctFailures(failures, func(f authFailure) string { return f.SourceIP })
+}
+
+func distinctFailures(failures []authFailure, field func(authFailure) string) []string {
+	seen := map[string]bool{}
+	values := []string{}
+	for _, failure := range failures {
+		value := field(failure)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+func limitStrings(values []string, limit int) []string {
+	if len(values) > limit {
+		return values[:limit]
+	}
+	return values
 }
@@ -1,10 +1,21 @@
 package system
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
 func TestAuthLogMonitor(t *testing.T) {
@@ -26,3 +37,374 @@ func TestAuthLogMonitor(t *testing.T) {
 		t.Fatalf("expected findings")
 	}
 }
+
+func TestAuthLogMonitorCursorAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.log")
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	appendLine := func(name, line string) {
+		file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(line + "\n"); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	mon := &AuthLogMonitor{}
+	mon.WithStore(store)
+	if err := mon.Init(map[string]interface{}{"path": path}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	expect := func(step string, findings int) {
+		t.Helper()
+		result, err := mon.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		if len(result.Findings) != findings {
+			t.Fatalf("%s: expected %d findings, got %d", step, findings, len(result.Findings))
+		}
+	}
+
+	appendLine(path, "Failed password for root from 10.0.0.1")
+	expect("backfill", 1)
+	expect("no new lines", 0)
+
+	appendLine(path, "Failed password for root from 10.0.0.2")
+	expect("appended", 1)
+
+	// Rename rotation with an unread line left in the old file.
+	appendLine(path, "Failed password for root from 10.0.0.3")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	appendLine(path, "Failed password for admin from 10.0.0.4")
+	expect("renamed", 2)
+
+	// Compressed rotation: the previous file only survives as .1.gz.
+	appendLine(path, "Failed password for admin from 10.0.0.5")
+	old, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(old)
+	_ = gz.Close()
+	if err := os.WriteFile(path+".1.gz", buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("write gz: %v", err)
+	}
+	if err := os.Remove(path + ".1"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	appendLine(path, "Invalid user guest from 10.0.0.6")
+	expect("compressed", 2)
+
+	// copytruncate leaves the same inode with a smaller size.
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	appendLine(path, "Failed password for x")
+	expect("truncated", 1)
+}
+
+func TestParseAuthLine(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		line                         string
+		kind, user, sourceIP, method string
+	}{
+		{"Jan  2 11:00:00 host sshd[10]: Failed password for invalid user admin from 203.0.113.5 port 4242 ssh2", authLoginFailed, "admin", "203.0.113.5", "password"},
+		{"Jan  2 11:00:01 host sshd[10]: Accepted publickey for deploy from 2001:db8::1 port 22 ssh2: ED25519 SHA256:abc", authLoginSuccess, "deploy", "2001:db8::1", "publickey"},
+		{"Jan  2 11:00:02 host sshd[10]: Invalid user oracle from 203.0.113.5 port 4243", authInvalidUser, "oracle", "203.0.113.5", ""},
+		{"2026-01-02T11:00:03.123456+00:00 host sudo:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/usr/bin/id", authSudo, "alice", "", "sudo"},
+		{"Jan  2 11:00:04 host sudo:      bob : user NOT in sudoers ; TTY=pts/1 ; PWD=/ ; USER=root ; COMMAND=/bin/sh", authSudoDenied, "bob", "", "sudo"},
+		{"Jan  2 11:00:05 host su[11]: FAILED SU (to root) alice on pts/0", authSuFailed, "alice", "", "su"},
+		{"Jan  2 11:00:06 host login[12]: pam_unix(login:auth): authentication failure; logname=LOGIN uid=0 euid=0 tty=tty1 ruser= rhost=  user=carol", authLoginFailed, "carol", "", "pam:login"},
+		{"Jan  2 11:00:07 host useradd[13]: new user: name=backup, UID=0, GID=0, home=/root, shell=/bin/bash, from=/dev/pts/0", authUserAdded, "backup", "", ""},
+		{"Dec 31 23:59:59 host usermod[14]: add 'mallory' to group 'sudo'", authGroupMember, "mallory", "", ""},
+	}
+	for _, tc := range cases {
+		event, ok := parseAuthLine(tc.line, now)
+		if !ok {
+			t.Fatalf("not parsed: %s", tc.line)
+		}
+		if event.Kind != tc.kind || event.User != tc.user || event.SourceIP != tc.sourceIP || event.Method != tc.method {
+			t.Fatalf("%s: got %+v", tc.line, event)
+		}
+	}
+
+	event, _ := parseAuthLine("Dec 31 23:59:59 host usermod[14]: add 'mallory' to group 'sudo'", now)
+	if event.Time.Year() != 2025 || event.PID != 14 || event.Group != "sudo" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	event, _ = parseAuthLine("Jan  2 11:00:08 host sshd[10]: message repeated 3 times: [ Failed password for root from 203.0.113.5 port 1 ssh2]", now)
+	if event.Count != 3 || event.User != "root" {
+		t.Fatalf("unexpected repeated event %+v", event)
+	}
+}
+
+func TestAuthLogMonitorAggregation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.log")
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	stamp := time.Now().Add(-time.Minute).Format("Jan _2 15:04:05")
+	lines := []string{}
+	for _, user := range []string{"root", "admin", "oracle", "test", "ubuntu", "root"} {
+		lines = append(lines, stamp+" host sshd[1]: Failed password for "+user+" from 198.51.100.7 port 1 ssh2")
+	}
+	lines = append(lines, stamp+" host sshd[1]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=198.51.100.7  user=root")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	config := map[string]interface{}{"path": path, "brute_force_threshold": float64(8)}
+	mon := &AuthLogMonitor{}
+	mon.WithStore(store)
+	if err := mon.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	ids := func(step string) []string {
+		t.Helper()
+		result, err := mon.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		out := []string{}
+		for _, finding := range result.Findings {
+			out = append(out, finding.ID)
+		}
+		return out
+	}
+	if got := ids("spray"); strings.Join(got, ",") != "auth_password_spray" {
+		t.Fatalf("expected a single spray finding, got %v", got)
+	}
+
+	// A fresh monitor must pick the window up from the store.
+	mon = &AuthLogMonitor{}
+	mon.WithStore(store)
+	if err := mon.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, _ = file.WriteString(stamp + " host sshd[1]: message repeated 2 times: [ Failed password for root from 198.51.100.7 port 1 ssh2]\n")
+	_, _ = file.WriteString(stamp + " host sshd[1]: Accepted password for root from 198.51.100.7 port 1 ssh2\n")
+	file.Close()
+	if got := ids("success"); strings.Join(got, ",") != "auth_brute_force,auth_success_after_failures" {
+		t.Fatalf("unexpected findings %v", got)
+	}
+}
+
+func TestAuthLogMonitorInvalidUserAndFailedSwitch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	stamp := time.Now().Add(-time.Minute).Format("Jan _2 15:04:05")
+	lines := []string{}
+	for _, user := range []string{"admin", "oracle", "test"} {
+		lines = append(lines, stamp+" host sshd[1]: Invalid user "+user+" from 203.0.113.9 port 4711")
+	}
+	lines = append(lines,
+		stamp+" host sudo[2]:    alice : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash",
+		stamp+" host su[3]: FAILED SU (to root) bob on pts/1",
+	)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mon := &AuthLogMonitor{}
+	if err := mon.Init(map[string]interface{}{"path": path, "brute_force_threshold": float64(3), "spray_threshold": float64(10)}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := mon.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	ids := []string{}
+	for _, finding := range result.Findings {
+		ids = append(ids, finding.ID)
+	}
+	if strings.Join(ids, ",") != "auth_brute_force,auth_sudo_failed,auth_su_failed" {
+		t.Fatalf("unexpected findings %v", ids)
+	}
+}
+
+func TestAuthLogMonitorInvalidUserCountedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	stamp := time.Now().Add(-time.Minute).Format("Jan _2 15:04:05")
+	attempt := func(pid int, user string, port int) []string {
+		prefix := fmt.Sprintf("%s host sshd[%d]: ", stamp, pid)
+		return []string{
+			fmt.Sprintf("%sInvalid user %s from 203.0.113.9 port %d", prefix, user, port),
+			fmt.Sprintf("%sFailed password for invalid user %s from 203.0.113.9 port %d ssh2", prefix, user, port),
+		}
+	}
+	lines := append(attempt(101, "admin", 40001), attempt(102, "oracle", 40002)...)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mon := &AuthLogMonitor{}
+	if err := mon.Init(map[string]interface{}{"path": path, "brute_force_threshold": float64(3), "spray_threshold": float64(10)}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func() []scanner.Finding {
+		t.Helper()
+		result, err := mon.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		return result.Findings
+	}
+	if findings := run(); len(findings) != 0 {
+		t.Fatalf("two attempts logged twice each should not reach the threshold: %+v", findings)
+	}
+
+	// A second password on the same connection is a new attempt.
+	lines = append(lines, fmt.Sprintf("%s host sshd[102]: Failed password for invalid user oracle from 203.0.113.9 port 40002 ssh2", stamp))
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	findings := run()
+	if len(findings) != 1 || findings[0].ID != "auth_brute_force" || findings[0].Evidence["failures"] != 3 {
+		t.Fatalf("expected brute force after the third attempt, got %+v", findings)
+	}
+}
+
+func TestAuthLogMonitorJournal(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	usec := time.Now().Add(-time.Minute).UnixMicro()
+	exportEntry := func(cursor string, offset int64, message string) []byte {
+		var buf bytes.Buffer
+		buf.WriteString("__CURSOR=" + cursor + "\n")
+		buf.WriteString("__REALTIME_TIMESTAMP=" + strconv.FormatInt(usec+offset, 10) + "\n")
+		buf.WriteString("SYSLOG_FACILITY=4\nSYSLOG_IDENTIFIER=sshd\n_PID=812\n_SYSTEMD_UNIT=ssh.service\n")
+		// MESSAGE in the binary encoding used for non-printable data.
+		buf.WriteString("MESSAGE\n")
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(message)))
+		buf.WriteString(message + "\n\n")
+		return buf.Bytes()
+	}
+	path := filepath.Join(dir, "auth.export")
+	data := exportEntry("c1", 0, "Failed password for root from 192.0.2.9 port 1 ssh2")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	mon := &AuthLogMonitor{}
+	mon.WithStore(store)
+	config := map[string]interface{}{
+		"path":                   filepath.Join(dir, "missing.log"),
+		"journal_path":           path,
+		"success_after_failures": float64(2),
+	}
+	if err := mon.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) map[string]interface{} {
+		t.Helper()
+		result, err := mon.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		if result.Metadata["source"] != "journal" {
+			t.Fatalf("%s: expected journal source, got %v", step, result.Metadata["source"])
+		}
+		if len(result.Findings) == 0 {
+			return nil
+		}
+		return result.Findings[0].Evidence
+	}
+	run("backfill")
+
+	data = append(data, exportEntry("c2", 1, "Failed password for root from 192.0.2.9 port 1 ssh2")...)
+	data = append(data, exportEntry("c3", 2, "Accepted password for root from 192.0.2.9 port 1 ssh2")...)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	evidence := run("appended")
+	if evidence == nil || evidence["systemd_unit"] != "ssh.service" || evidence["syslog_identifier"] != "sshd" || evidence["pid"] != 812 || evidence["journal_cursor"] != "c3" {
+		t.Fatalf("unexpected evidence %v", evidence)
+	}
+	if evidence := run("no new entries"); evidence != nil {
+		t.Fatalf("expected no findings, got %v", evidence)
+	}
+
+	// JSON output, read through journalctl resuming from the stored cursor.
+	script := filepath.Join(dir, "journalctl")
+	argsFile := filepath.Join(dir, "args")
+	body := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" +
+		`echo '{"__CURSOR":"j1","__REALTIME_TIMESTAMP":"1","SYSLOG_IDENTIFIER":"useradd","_PID":"99","MESSAGE":[110,101,119,32,117,115,101,114,58,32,110,97,109,101,61,120,44,32,85,73,68,61,48]}'` + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o700); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	config = map[string]interface{}{"source": "journal", "journalctl": script, "journal_matches": []interface{}{"_COMM=useradd"}}
+	mon = &AuthLogMonitor{}
+	mon.WithStore(store)
+	if err := mon.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if evidence := run("journalctl"); evidence == nil || evidence["user"] != "x" || evidence["uid"] != 0 {
+		t.Fatalf("unexpected evidence %v", evidence)
+	}
+	run("journalctl resume")
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "--after-cursor j1") || !strings.Contains(string(args), "_COMM=useradd") {
+		t.Fatalf("unexpected journalctl args %q", args)
+	}
+
+	// journalctl failing after output keeps the findings and the cursor.
+	body = "#!/bin/sh\necho \"$@\" > " + argsFile + "\n" +
+		`echo '{"__CURSOR":"j2","__REALTIME_TIMESTAMP":"2","SYSLOG_IDENTIFIER":"useradd","_PID":"99","MESSAGE":"new user: name=y, UID=0"}'` + "\n" +
+		"exit 1\n"
+	if err := os.WriteFile(script, []byte(body), 0o700); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+	result, err := mon.Run(context.Background())
+	if err != nil {
+		t.Fatalf("partial run: %v", err)
+	}
+	if result.Status != scanner.StatusPartial || len(result.Findings) != 1 || result.Findings[0].Evidence["user"] != "y" {
+		t.Fatalf("expected a partial result with the finding, got %+v", result)
+	}
+	run("journalctl after failure")
+	args, _ = os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "--after-cursor j2") {
+		t.Fatalf("expected the cursor to be saved, got args %q", args)
+	}
+}
+
+func TestReadJournalExportOversizedField(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("__CURSOR=s=1\nMESSAGE\n")
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(1<<64-1))
+	buf.WriteString("x\n\n")
+	err := readJournalExport(bufio.NewReader(&buf), func(journalEntry) {
+		t.Fatalf("no entry expected from a corrupt field")
+	})
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("expected a size error, got %v", err)
+	}
+}
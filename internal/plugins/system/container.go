@@ -0,0 +1,214 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+// Container runtimes recognised from cgroup paths. runtimeUnknown marks a
+// container ID or pod whose cgroup layout does not name the runtime.
+const (
+	runtimeDocker     = "docker"
+	runtimeContainerd = "containerd"
+	runtimeCRIO       = "cri-o"
+	runtimePodman     = "podman"
+	runtimeUnknown    = "unknown"
+)
+
+// containerInfo attributes a process or file to a container. The zero value
+// means the host.
+type containerInfo struct {
+	ID      string `json:"id,omitempty"`
+	Runtime string `json:"runtime,omitempty"`
+	PodUID  string `json:"pod_uid,omitempty"`
+}
+
+func (c containerInfo) InContainer() bool {
+	return c.Runtime != ""
+}
+
+// ShortID is the 12-character form docker and podman print.
+func (c containerInfo) ShortID() string {
+	if len(c.ID) > 12 {
+		return c.ID[:12]
+	}
+	return c.ID
+}
+
+// addEvidence records the container context on a finding; host processes
+// and files get nothing.
+func (c containerInfo) addEvidence(evidence map[string]interface{}) {
+	if !c.InContainer() {
+		return
+	}
+	evidence["container_runtime"] = c.Runtime
+	if c.ID != "" {
+		evidence["container_id"] = c.ID
+	}
+	if c.PodUID != "" {
+		evidence["pod_uid"] = c.PodUID
+	}
+}
+
+var (
+	containerIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	// Scope units as created by the systemd cgroup driver, e.g.
+	// docker-<id>.scope or cri-containerd-<id>.scope, and podman's cgroupfs
+	// libpod-<id>.
+	containerScopePattern = regexp.MustCompile(`^(docker|cri-containerd|crio|libpod)-([0-9a-f]{64})(\.scope)?$`)
+	podPattern            = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})(\.slice)?$`)
+)
+
+var scopeRuntimes = map[string]string{
+	"docker":         runtimeDocker,
+	"cri-containerd": runtimeContainerd,
+	"crio":           runtimeCRIO,
+	"libpod":         runtimePodman,
+}
+
+// parseCgroupContainer resolves /proc/<pid>/cgroup content, v1 or v2, to a
+// container. It understands the cgroupfs and systemd layouts of docker,
+// containerd, cri-o and podman, with or without Kubernetes pods. conmon
+// monitor scopes are host processes and are left unattributed.
+func parseCgroupContainer(raw string) containerInfo {
+	for _, line := range strings.Split(raw, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if info := containerFromCgroupPath(fields[2]); info.InContainer() {
+			return info
+		}
+	}
+	return containerInfo{}
+}
+
+func containerFromCgroupPath(path string) containerInfo {
+	info := containerInfo{}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if m := podPattern.FindStringSubmatch(part); m != nil {
+			info.PodUID = strings.ReplaceAll(m[1], "_", "-")
+			continue
+		}
+		if m := containerScopePattern.FindStringSubmatch(part); m != nil {
+			info.ID, info.Runtime = m[2], scopeRuntimes[m[1]]
+			continue
+		}
+		if !containerIDPattern.MatchString(part) || i == 0 {
+			continue
+		}
+		// cgroupfs layouts: /docker/<id>, /kubepods/<qos>/pod<uid>/<id>.
+		info.ID = part
+		switch parent := parts[i-1]; {
+		case parent == "docker":
+			info.Runtime = runtimeDocker
+		case strings.HasPrefix(parent, "pod"):
+			// The cgroupfs driver does not name the CRI runtime.
+			info.Runtime = runtimeUnknown
+		}
+	}
+	if info.ID == "" {
+		return containerInfo{}
+	}
+	if info.Runtime == "" {
+		info.Runtime = runtimeUnknown
+	}
+	return info
+}
+
+// containerResolver attributes processes and paths, caching what it reads.
+type containerResolver struct {
+	procRoot   string
+	dockerRoot string
+	byPID      map[int]containerInfo
+	mounts     map[string]string
+}
+
+func newContainerResolver(procRoot string) *containerResolver {
+	return &containerResolver{
+		procRoot:   procRoot,
+		dockerRoot: "/var/lib/docker",
+		byPID:      map[int]containerInfo{},
+	}
+}
+
+// pid attributes a process by reading its cgroup file.
+func (r *containerResolver) pid(pid int) containerInfo {
+	if info, ok := r.byPID[pid]; ok {
+		return info
+	}
+	raw, _ := os.ReadFile(filepath.Join(r.procRoot, strconv.Itoa(pid), "cgroup"))
+	info := parseCgroupContainer(string(raw))
+	r.byPID[pid] = info
+	return info
+}
+
+// path attributes a host path inside container storage: docker container
+// directories and overlay2 layers, containerd task rootfs mounts and
+// kubelet pod volumes.
+func (r *containerResolver) path(path string) containerInfo {
+	if rest, ok := strings.CutPrefix(path, r.dockerRoot+"/containers/"); ok {
+		if id, _, _ := strings.Cut(rest, "/"); containerIDPattern.MatchString(id) {
+			return containerInfo{ID: id, Runtime: runtimeDocker}
+		}
+	}
+	if rest, ok := strings.CutPrefix(path, r.dockerRoot+"/overlay2/"); ok {
+		layer, _, _ := strings.Cut(rest, "/")
+		layer = strings.TrimSuffix(layer, "-init")
+		if id, ok := r.dockerMounts()[layer]; ok {
+			return containerInfo{ID: id, Runtime: runtimeDocker}
+		}
+	}
+	// /run/containerd/io.containerd.runtime.v2.task/<namespace>/<id>/rootfs/...
+	if rest, ok := strings.CutPrefix(path, "/run/containerd/io.containerd.runtime.v2.task/"); ok {
+		parts := strings.SplitN(rest, "/", 3)
+		if len(parts) >= 2 && parts[1] != "" {
+			return containerInfo{ID: parts[1], Runtime: runtimeContainerd}
+		}
+	}
+	if rest, ok := strings.CutPrefix(path, "/var/lib/kubelet/pods/"); ok {
+		uid, _, _ := strings.Cut(rest, "/")
+		if podPattern.MatchString("pod" + uid) {
+			return containerInfo{PodUID: uid, Runtime: runtimeUnknown}
+		}
+	}
+	return containerInfo{}
+}
+
+// dockerMounts maps overlay2 layer directories to container IDs from
+// docker's layerdb, read once per resolver.
+func (r *containerResolver) dockerMounts() map[string]string {
+	if r.mounts != nil {
+		return r.mounts
+	}
+	r.mounts = map[string]string{}
+	dir := filepath.Join(r.dockerRoot, "image/overlay2/layerdb/mounts")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return r.mounts
+	}
+	for _, entry := range entries {
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name(), "mount-id"))
+		if err == nil {
+			r.mounts[strings.TrimSpace(string(raw))] = entry.Name()
+		}
+	}
+	return r.mounts
+}
+
+// attributeFileFindings adds container context to findings whose "path"
+// evidence lies in container storage.
+func attributeFileFindings(findings []scanner.Finding) {
+	resolver := &containerResolver{dockerRoot: "/var/lib/docker"}
+	for _, finding := range findings {
+		if path, ok := finding.Evidence["path"].(string); ok {
+			resolver.path(path).addEvidence(finding.Evidence)
+		}
+	}
+}
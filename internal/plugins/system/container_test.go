@@ -0,0 +1,113 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+const testContainerID = "3f4a1b2c3d4e5f60718293a4b5c6d7e8f90123456789abcdef0123456789abcd"
+
+func TestParseCgroupContainer(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want containerInfo
+	}{
+		{"0::/system.slice/sshd.service\n", containerInfo{}},
+		{"12:pids:/docker/" + testContainerID + "\n4:cpu,cpuacct:/docker/" + testContainerID + "\n", containerInfo{ID: testContainerID, Runtime: runtimeDocker}},
+		{"0::/system.slice/docker-" + testContainerID + ".scope\n", containerInfo{ID: testContainerID, Runtime: runtimeDocker}},
+		{"0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod0f1e2d3c_4b5a_6978_8695_a4b3c2d1e0f9.slice/cri-containerd-" + testContainerID + ".scope\n",
+			containerInfo{ID: testContainerID, Runtime: runtimeContainerd, PodUID: "0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9"}},
+		{"0::/kubepods/besteffort/pod0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9/" + testContainerID + "\n",
+			containerInfo{ID: testContainerID, Runtime: runtimeUnknown, PodUID: "0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9"}},
+		{"0::/machine.slice/crio-" + testContainerID + ".scope\n", containerInfo{ID: testContainerID, Runtime: runtimeCRIO}},
+		{"0::/user.slice/user-1000.slice/user@1000.service/user.slice/libpod-" + testContainerID + ".scope/container\n", containerInfo{ID: testContainerID, Runtime: runtimePodman}},
+		{"0::/machine.slice/libpod-conmon-" + testContainerID + ".scope\n", containerInfo{}},
+	}
+	for _, tc := range cases {
+		if got := parseCgroupContainer(tc.raw); got != tc.want {
+			t.Fatalf("parseCgroupContainer(%q) = %+v, want %+v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestContainerAttribution(t *testing.T) {
+	proc := t.TempDir()
+	writeFakeProcess(t, proc, 1, 0, "systemd", "/usr/lib/systemd/systemd")
+	writeFakeProcess(t, proc, 100, 1, "containerd-shim", "/usr/bin/containerd-shim-runc-v2")
+	writeFakeProcess(t, proc, 200, 100, "nginx", "/usr/sbin/nginx")
+	writeFakeProcess(t, proc, 201, 200, "sh", "/bin/sh", "sh", "-c", "id")
+	writeFakeProcess(t, proc, 300, 1, "nginx", "/usr/sbin/nginx")
+	writeFakeProcess(t, proc, 301, 300, "sh", "/bin/sh", "sh", "-c", "id")
+	writeFakeProcess(t, proc, 400, 1, "bwrap", "/usr/bin/bwrap")
+	for _, pid := range []int{200, 201} {
+		os.WriteFile(filepath.Join(proc, strconv.Itoa(pid), "cgroup"), []byte("0::/system.slice/docker-"+testContainerID+".scope\n"), 0o644)
+	}
+	// A sandbox's own pid namespace does not make it a container.
+	for pid, ns := range map[int]string{1: "4026531836", 300: "4026531836", 301: "4026531836", 400: "4026532999"} {
+		dir := filepath.Join(proc, strconv.Itoa(pid), "ns")
+		os.MkdirAll(dir, 0o755)
+		os.Symlink("pid:["+ns+"]", filepath.Join(dir, "pid"))
+	}
+
+	table, err := listProcesses(proc)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if got := table[201].Container; got.ID != testContainerID || got.Runtime != runtimeDocker {
+		t.Fatalf("container process: %+v", got)
+	}
+	if table[301].Container.InContainer() || table[400].Container.InContainer() {
+		t.Fatalf("host processes attributed: nginx %+v, bwrap %+v", table[301].Container, table[400].Container)
+	}
+	if evidence := table[400].evidence(); evidence["pid_ns"] != uint64(4026532999) || evidence["container_runtime"] != nil {
+		t.Fatalf("sandbox evidence: %v", evidence)
+	}
+	if evidence := table[201].evidence(); evidence["container_id"] != testContainerID || evidence["container_runtime"] != runtimeDocker {
+		t.Fatalf("evidence: %v", evidence)
+	}
+
+	pm := &ProcessMonitor{}
+	rules := []interface{}{
+		map[string]interface{}{"id": "container_shell", "process": []interface{}{"sh"}, "parent": []interface{}{"nginx"}, "containers": []interface{}{testContainerID[:12]}},
+		map[string]interface{}{"id": "host_shell", "process": []interface{}{"sh"}, "parent": []interface{}{"nginx"}, "scope": "host"},
+		map[string]interface{}{"id": "podman_shell", "process": []interface{}{"sh"}, "parent": []interface{}{"nginx"}, "runtimes": []interface{}{"podman"}},
+	}
+	if err := pm.Init(map[string]interface{}{"proc_root": proc, "lineage_rules": rules}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pm.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := map[string]interface{}{}
+	for _, finding := range result.Findings {
+		got[finding.ID] = finding.Evidence["pid"]
+	}
+	if len(got) != 2 || got["container_shell"] != 201 || got["host_shell"] != 301 || result.Metadata["containers"] != 1 {
+		t.Fatalf("scoped findings %v, metadata %v", got, result.Metadata)
+	}
+	if err := pm.Init(map[string]interface{}{"lineage_rules": []interface{}{map[string]interface{}{"id": "x", "process": []interface{}{"sh"}, "parent": []interface{}{"nginx"}, "scope": "pods"}}}); err == nil {
+		t.Fatalf("expected invalid scope to fail")
+	}
+
+	docker := t.TempDir()
+	mounts := filepath.Join(docker, "image/overlay2/layerdb/mounts", testContainerID)
+	os.MkdirAll(mounts, 0o755)
+	os.WriteFile(filepath.Join(mounts, "mount-id"), []byte("a1b2c3\n"), 0o644)
+	resolver := &containerResolver{dockerRoot: docker}
+	for path, want := range map[string]containerInfo{
+		docker + "/overlay2/a1b2c3/merged/var/www/shell.php":                       {ID: testContainerID, Runtime: runtimeDocker},
+		docker + "/containers/" + testContainerID + "/config":                      {ID: testContainerID, Runtime: runtimeDocker},
+		"/run/containerd/io.containerd.runtime.v2.task/k8s.io/abc123/rootfs/tmp/x": {ID: "abc123", Runtime: runtimeContainerd},
+		"/var/lib/kubelet/pods/0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9/volumes/x":     {PodUID: "0f1e2d3c-4b5a-6978-8695-a4b3c2d1e0f9", Runtime: runtimeUnknown},
+		docker + "/overlay2/ffffff/merged/x":                                       {},
+		"/etc/passwd":                                                              {},
+	} {
+		if got := resolver.path(path); got != want {
+			t.Fatalf("path(%s) = %+v, want %+v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package system
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/signatures"
+)
+
+// defaultContentRules catch common web shells without any rule pack
+// installed.
+var defaultContentRules = []signatures.ContentRule{
+	{
+		ID:          "php_webshell_eval_input",
+		Description: "PHP code evaluating request input",
+		Severity:    "high",
+		Tags:        []string{"webshell", "php"},
+		Paths:       []string{"*.php", "*.phtml", "*.php[0-9]", "*.inc"},
+		Strings: []signatures.ContentString{
+			{ID: "$eval", Regex: `(?i)\b(eval|assert|system|passthru|shell_exec|exec|popen|proc_open)\s*\(\s*(base64_decode\s*\(\s*)?\$_(GET|POST|REQUEST|COOKIE|SERVER)\b`},
+			{ID: "$create", Regex: `(?i)create_function\s*\([^)]*\$_(GET|POST|REQUEST|COOKIE)`},
+		},
+	},
+	{
+		ID:          "php_obfuscated_eval",
+		Description: "PHP code evaluating decoded or decompressed data",
+		Severity:    "medium",
+		Tags:        []string{"webshell", "php", "obfuscation"},
+		Paths:       []string{"*.php", "*.phtml", "*.php[0-9]", "*.inc"},
+		Strings: []signatures.ContentString{
+			{ID: "$eval", Regex: `(?i)\beval\s*\(\s*(gzinflate|gzuncompress|str_rot13|base64_decode)\s*\(`},
+		},
+	},
+	{
+		ID:          "jsp_webshell_exec",
+		Description: "JSP/ASPX code running commands from request parameters",
+		Severity:    "high",
+		Tags:        []string{"webshell"},
+		Paths:       []string{"*.jsp", "*.jspx", "*.aspx", "*.ashx"},
+		Strings: []signatures.ContentString{
+			{ID: "$jsp_exec", Text: "Runtime.getRuntime().exec("},
+			{ID: "$jsp_param", Text: "request.getParameter("},
+			{ID: "$asp_exec", Text: "Process.Start(", NoCase: true},
+			{ID: "$asp_param", Text: "Request.", NoCase: true},
+		},
+		Condition: "($jsp_exec and $jsp_param) or ($asp_exec and $asp_param)",
+	},
+}
+
+// ContentScan matches file contents against YARA-like rules: the built-in
+// web shell rules, rules given inline, and rule packs delivered through the
+// signatures updater (the content_rules source) or an airgap import.
+type ContentScan struct {
+	paths       globSet
+	roots       []string
+	exclude     globSet
+	builtin     bool
+	inline      []signatures.ContentRule
+	ruleDirs    []string
+	maxFileSize int64
+}
+
+type contentRule struct {
+	*signatures.CompiledRule
+	paths globSet
+}
+
+func (c *ContentScan) Name() string { return "system.content_scan" }
+
+func (c *ContentScan) Init(config map[string]interface{}) error {
+	patterns := []string{"/var/www/**", "/srv/www/**", "/tmp/**", "/var/tmp/**", "/dev/shm/**"}
+	if v, ok := configStrings(config, "paths"); ok && len(v) > 0 {
+		patterns = v
+	}
+	c.roots = nil
+	expanded := []string{}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			return fmt.Errorf("paths: %q must be absolute", pattern)
+		}
+		expanded = append(expanded, pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			// A plain directory means everything below it.
+			expanded = append(expanded, strings.TrimSuffix(pattern, "/")+"/**")
+		}
+		c.roots = append(c.roots, globRoot(pattern))
+	}
+	globs, err := compileGlobs(expanded)
+	if err != nil {
+		return fmt.Errorf("paths: %w", err)
+	}
+	c.paths = globs
+	exclude, _ := configStrings(config, "exclude")
+	if c.exclude, err = compileGlobs(exclude); err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	c.builtin = true
+	if v, ok := config["builtin_rules"].(bool); ok {
+		c.builtin = v
+	}
+	c.inline = nil
+	if raw, ok := config["rules"]; ok {
+		encoded, err := json.Marshal(raw)
+		if err != nil {
+			return fmt.Errorf("rules: %w", err)
+		}
+		if err := json.Unmarshal(encoded, &c.inline); err != nil {
+			return fmt.Errorf("rules: %w", err)
+		}
+		for _, rule := range c.inline {
+			if _, err := signatures.CompileContentRule(rule); err != nil {
+				return fmt.Errorf("rules: %w", err)
+			}
+		}
+	}
+	cacheDir := "/var/lib/arcsent/signatures"
+	if v, ok := config["cache_dir"].(string); ok && v != "" {
+		cacheDir = v
+	}
+	c.ruleDirs = []string{filepath.Join(cacheDir, signatures.SourceContentRules)}
+	if v, ok := configStrings(config, "rule_dirs"); ok {
+		c.ruleDirs = append(c.ruleDirs, v...)
+	}
+	c.maxFileSize = 10 << 20
+	if v, ok := config["max_file_size"].(float64); ok && v > 0 {
+		c.maxFileSize = int64(v)
+	}
+	return nil
+}
+
+func (c *ContentScan) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: c.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+	rules, problems := c.loadRules()
+	result.Metadata["rules"] = len(rules)
+	if len(problems) > 0 {
+		result.Metadata["rule_errors"] = limitStrings(problems, 20)
+	}
+	if len(rules) == 0 {
+		return result, nil
+	}
+
+	scanned, skipped := 0, 0
+	var scannedBytes int64
+	visited := map[string]bool{}
+	for _, root := range c.roots {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if c.exclude.Match(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || visited[path] || !c.paths.Match(path) {
+				return nil
+			}
+			visited[path] = true
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if info.Size() > c.maxFileSize {
+				skipped++
+				return nil
+			}
+			applicable := rulesForFile(rules, path, info.Size())
+			if len(applicable) == 0 {
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil
+			}
+			scanned++
+			scannedBytes += int64(len(data))
+			result.Findings = append(result.Findings, matchContent(path, info, data, applicable)...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	attributeFileFindings(result.Findings)
+	result.Metadata["files_scanned"] = scanned
+	result.Metadata["bytes_scanned"] = scannedBytes
+	result.Metadata["skipped_large"] = skipped
+	return result, nil
+}
+
+func (c *ContentScan) Halt(_ context.Context) error { return nil }
+
+// loadRules compiles inline rules, rule pack directories and the built-in
+// rules, in that order; a later rule with an ID already seen is dropped, so
+// inline and pack rules can replace built-in ones.
+func (c *ContentScan) loadRules() ([]contentRule, []string) {
+	var problems []string
+	var compiled []*signatures.CompiledRule
+	for _, rule := range c.inline {
+		if r, err := signatures.CompileContentRule(rule); err == nil {
+			compiled = append(compiled, r)
+		}
+	}
+	for _, dir := range c.ruleDirs {
+		rules, errs, err := signatures.LoadContentRules(dir)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		compiled = append(compiled, rules...)
+		problems = append(problems, errs...)
+	}
+	if c.builtin {
+		for _, rule := range defaultContentRules {
+			r, err := signatures.CompileContentRule(rule)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			compiled = append(compiled, r)
+		}
+	}
+
+	seen := map[string]bool{}
+	rules := []contentRule{}
+	for _, r := range compiled {
+		if seen[r.ID] {
+			continue
+		}
+		seen[r.ID] = true
+		paths, err := compileGlobs(r.Paths)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("rule %s: %v", r.ID, err))
+			continue
+		}
+		rules = append(rules, contentRule{CompiledRule: r, paths: paths})
+	}
+	return rules, problems
+}
+
+// rulesForFile narrows the rule set by path and size before the file is read.
+func rulesForFile(rules []contentRule, path string, size int64) []contentRule {
+	var out []contentRule
+	for _, rule := range rules {
+		if len(rule.paths) > 0 && !rule.paths.Match(path) {
+			continue
+		}
+		if size < rule.MinSize || (rule.MaxSize > 0 && size > rule.MaxSize) {
+			continue
+		}
+		out = append(out, rule)
+	}
+	return out
+}
+
+func matchContent(path string, info os.FileInfo, data []byte, rules []contentRule) []scanner.Finding {
+	var lower []byte
+	var digest string
+	findings := []scanner.Finding{}
+	for _, rule := range rules {
+		if lower == nil && rule.UsesNoCase() {
+			lower = signatures.LowerASCII(data)
+		}
+		match, ok := rule.Match(data, lower)
+		if !ok {
+			continue
+		}
+		if digest == "" {
+			sum := sha256.Sum256(data)
+			digest = hex.EncodeToString(sum[:])
+		}
+		severity := scanner.Severity(rule.Severity)
+		if severity == "" {
+			severity = scanner.SeverityHigh
+		}
+		evidence := map[string]interface{}{
+			"path":     path,
+			"rule":     rule.ID,
+			"strings":  match.Strings,
+			"offsets":  match.Offsets,
+			"size":     info.Size(),
+			"sha256":   digest,
+			"modified": info.ModTime().UTC().Format(time.RFC3339),
+		}
+		if rule.Description != "" {
+			evidence["rule_description"] = rule.Description
+		}
+		if len(rule.Tags) > 0 {
+			evidence["tags"] = rule.Tags
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			evidence["uid"] = st.Uid
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "content_match",
+			Severity:    severity,
+			Category:    "malware",
+			Description: fmt.Sprintf("File %s matches content rule %s", path, rule.ID),
+			Evidence:    evidence,
+			Remediation: "Quarantine the file, confirm whether it is malicious and find out how it was written.",
+		})
+	}
+	return findings
+}
+
+// globRoot returns the directory a glob can match under: its leading
+// components up to the first one containing a wildcard.
+func globRoot(pattern string) string {
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			root := strings.Join(parts[:i], "/")
+			if root == "" {
+				return "/"
+			}
+			return root
+		}
+	}
+	return pattern
+}
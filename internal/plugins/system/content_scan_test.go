@@ -0,0 +1,82 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestContentScan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("www/upload/shell.php", `<?php @eval($_POST["cmd"]); ?>`)
+	write("www/index.php", `<?php echo "hello"; ?>`)
+	write("www/cache/shell.php", `<?php system($_GET["c"]); ?>`)
+	write("www/notes.txt", `eval($_POST["cmd"])`)
+	write("tmp/.x/kworker", "\x7fELF\x02\x01\x01 stratum+tcp://pool.example:3333")
+	write("tmp/big.bin", "\x7fELF"+strings.Repeat("A", 200))
+	write("cache/content_rules/miners.json", `[{"id":"elf_miner","severity":"critical","magic":["7f454c46"],
+		"strings":[{"id":"$pool","text":"STRATUM+TCP://","nocase":true}]},{"id":"broken","strings":[]}]`)
+
+	plugin := &ContentScan{}
+	config := map[string]interface{}{
+		"paths":         []interface{}{filepath.Join(dir, "www/**"), filepath.Join(dir, "tmp")},
+		"exclude":       []interface{}{filepath.Join(dir, "www/cache")},
+		"cache_dir":     filepath.Join(dir, "cache"),
+		"max_file_size": float64(100),
+	}
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := []string{}
+	for _, finding := range result.Findings {
+		rel, _ := filepath.Rel(dir, finding.Evidence["path"].(string))
+		got = append(got, string(finding.Severity)+":"+finding.Evidence["rule"].(string)+":"+rel)
+	}
+	sort.Strings(got)
+	want := "critical:elf_miner:tmp/.x/kworker,high:php_webshell_eval_input:www/upload/shell.php"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("findings %v, want %s", got, want)
+	}
+	if result.Metadata["skipped_large"] != 1 || len(result.Metadata["rule_errors"].([]string)) != 1 {
+		t.Fatalf("metadata: %v", result.Metadata)
+	}
+
+	// Inline rules replace built-in rules with the same ID.
+	config["rules"] = []interface{}{map[string]interface{}{
+		"id": "php_webshell_eval_input", "severity": "low", "paths": []interface{}{"*.txt"},
+		"strings": []interface{}{map[string]interface{}{"id": "$a", "text": "$_POST"}},
+	}}
+	config["builtin_rules"] = false
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 2 || result.Metadata["rules"] != 2 {
+		t.Fatalf("inline rules: %d findings, metadata %v", len(result.Findings), result.Metadata)
+	}
+
+	config["rules"] = []interface{}{map[string]interface{}{"id": "bad", "condition": "$a"}}
+	if err := plugin.Init(config); err == nil {
+		t.Fatalf("expected invalid inline rule to fail Init")
+	}
+}
@@ -3,24 +3,99 @@ package system
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
+const diskHistoryBucket = "disk_usage_history"
+
+// pseudoFilesystems never hold user data or are always full (squashfs).
+var pseudoFilesystems = map[string]bool{
+	"proc": true, "sysfs": true, "devtmpfs": true, "devpts": true, "cgroup": true, "cgroup2": true,
+	"securityfs": true, "debugfs": true, "tracefs": true, "pstore": true, "bpf": true, "configfs": true,
+	"fusectl": true, "mqueue": true, "hugetlbfs": true, "autofs": true, "binfmt_misc": true, "nsfs": true,
+	"efivarfs": true, "rpc_pipefs": true, "squashfs": true, "overlay": true, "ramfs": true, "iso9660": true,
+}
+
+var defaultMountOptions = map[string][]string{
+	"/tmp":     {"nodev", "nosuid", "noexec"},
+	"/var/tmp": {"nodev", "nosuid", "noexec"},
+	"/dev/shm": {"nodev", "nosuid", "noexec"},
+}
+
+// DiskUsage checks space and inode usage per mount, audits mount options and
+// forecasts when a filesystem will fill up from stored samples.
 type DiskUsage struct {
-	path        string
-	warnPercent float64
-	critPercent float64
+	path              string
+	warnPercent       float64
+	critPercent       float64
+	inodeWarnPercent  float64
+	inodeCritPercent  float64
+	mounts            []string
+	exclude           globSet
+	mountInfoPath     string
+	mountOptions      map[string][]string
+	forecastWindow    time.Duration
+	forecastHorizon   time.Duration
+	forecastMinSample int
+	store             storage.Store
+}
+
+type mountEntry struct {
+	Device     string
+	MountPoint string
+	FSType     string
+	Options    []string
+}
+
+type mountUsage struct {
+	Mount          string  `json:"mount"`
+	FSType         string  `json:"fstype,omitempty"`
+	Total          float64 `json:"total"`
+	Used           float64 `json:"used"`
+	UsedPct        float64 `json:"used_pct"`
+	InodesTotal    float64 `json:"inodes_total"`
+	InodesUsed     float64 `json:"inodes_used"`
+	InodesUsedPct  float64 `json:"inodes_used_pct"`
+	HoursToFull    float64 `json:"hours_to_full,omitempty"`
+	InodeHoursFull float64 `json:"inode_hours_to_full,omitempty"`
+}
+
+// diskResizeTolerance is the relative change in filesystem size treated as a
+// resize rather than metadata accounting noise.
+const diskResizeTolerance = 0.02
+
+type diskSample struct {
+	Time       time.Time `json:"t"`
+	Used       float64   `json:"used"`
+	Total      float64   `json:"total"`
+	InodesUsed float64   `json:"inodes_used"`
+	Inodes     float64   `json:"inodes"`
 }
 
 func (d *DiskUsage) Name() string { return "system.disk_usage" }
 
+func (d *DiskUsage) WithStore(store storage.Store) {
+	d.store = store
+}
+
 func (d *DiskUsage) Init(config map[string]interface{}) error {
 	d.path = "/"
 	d.warnPercent = 85
 	d.critPercent = 95
+	d.inodeWarnPercent = 85
+	d.inodeCritPercent = 95
+	d.mountInfoPath = "/proc/self/mountinfo"
+	d.forecastWindow = 24 * time.Hour
+	d.forecastHorizon = 24 * time.Hour
+	d.forecastMinSample = 6
 
 	if v, ok := config["path"].(string); ok && v != "" {
 		d.path = v
@@ -34,64 +109,409 @@ func (d *DiskUsage) Init(config map[string]interface{}) error {
 	if d.warnPercent >= d.critPercent {
 		return fmt.Errorf("warn_percent must be less than crit_percent")
 	}
+	if v, ok := config["inode_warn_percent"].(float64); ok && v > 0 {
+		d.inodeWarnPercent = v
+	}
+	if v, ok := config["inode_crit_percent"].(float64); ok && v > 0 {
+		d.inodeCritPercent = v
+	}
+	if d.inodeWarnPercent >= d.inodeCritPercent {
+		return fmt.Errorf("inode_warn_percent must be less than inode_crit_percent")
+	}
+	d.mounts, _ = configStrings(config, "mounts")
+	exclude := []string{"/proc/**", "/sys/**", "/run/user/**", "/run/containerd/**", "/run/netns/**", "/var/lib/docker/**", "/var/lib/containers/**", "/var/lib/kubelet/pods/**", "/snap/**"}
+	if v, ok := configStrings(config, "exclude"); ok {
+		exclude = v
+	}
+	globs, err := compileGlobs(exclude)
+	if err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	d.exclude = globs
+	if v, ok := config["mountinfo_path"].(string); ok && v != "" {
+		d.mountInfoPath = v
+	}
+	d.mountOptions = map[string][]string{}
+	for mount, options := range defaultMountOptions {
+		d.mountOptions[mount] = options
+	}
+	if v, ok := config["mount_options"].(map[string]interface{}); ok {
+		for mount, raw := range v {
+			options, _ := configStrings(map[string]interface{}{"options": raw}, "options")
+			if len(options) == 0 {
+				delete(d.mountOptions, mount)
+				continue
+			}
+			d.mountOptions[mount] = options
+		}
+	}
+	for key, target := range map[string]*time.Duration{"forecast_window": &d.forecastWindow, "forecast_horizon": &d.forecastHorizon} {
+		if v, ok := config[key].(string); ok && v != "" {
+			duration, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*target = duration
+		}
+	}
+	if v, ok := config["forecast_min_samples"].(float64); ok && v >= 2 {
+		d.forecastMinSample = int(v)
+	}
 	return nil
 }
 
 func (d *DiskUsage) Run(_ context.Context) (*scanner.Result, error) {
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(d.path, &stat); err != nil {
-		return nil, fmt.Errorf("statfs %s: %w", d.path, err)
+	now := time.Now()
+	mounts, err := readMountInfo(d.mountInfoPath)
+	if err != nil {
+		// Without mountinfo (non-Linux) only the configured path is checked.
+		mounts = nil
 	}
-
-	total := float64(stat.Blocks) * float64(stat.Bsize)
-	free := float64(stat.Bavail) * float64(stat.Bsize)
-	used := total - free
-	usedPct := 0.0
-	if total > 0 {
-		usedPct = (used / total) * 100
+	primary, err := statMount(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("statfs %s: %w", d.path, err)
 	}
+	primary.Mount = d.path
 
 	result := &scanner.Result{
 		ScannerName: d.Name(),
 		Status:      scanner.StatusSuccess,
 		Metadata: map[string]interface{}{
-			"path":      d.path,
-			"total":     total,
-			"used":      used,
-			"used_pct":  usedPct,
-			"warn_pct":  d.warnPercent,
-			"crit_pct":  d.critPercent,
-			"timestamp": time.Now().Format(time.RFC3339),
+			"path":            d.path,
+			"total":           primary.Total,
+			"used":            primary.Used,
+			"used_pct":        primary.UsedPct,
+			"inodes_used_pct": primary.InodesUsedPct,
+			"warn_pct":        d.warnPercent,
+			"crit_pct":        d.critPercent,
+			"timestamp":       now.Format(time.RFC3339),
 		},
 	}
 
-	if usedPct >= d.critPercent {
-		result.Findings = append(result.Findings, scanner.Finding{
+	usages := []mountUsage{primary}
+	for _, mount := range d.selectMounts(mounts) {
+		if mount.MountPoint == d.path {
+			usages[0].FSType = mount.FSType
+			continue
+		}
+		usage, err := statMount(mount.MountPoint)
+		if err != nil || usage.Total == 0 {
+			continue
+		}
+		usage.Mount, usage.FSType = mount.MountPoint, mount.FSType
+		usages = append(usages, usage)
+	}
+
+	maxUsed, maxInodes := 0.0, 0.0
+	for i := range usages {
+		usage := &usages[i]
+		result.Findings = append(result.Findings, d.thresholdFindings(*usage)...)
+		if d.store != nil {
+			findings, err := d.forecast(usage, now)
+			if err != nil {
+				return nil, err
+			}
+			result.Findings = append(result.Findings, findings...)
+		}
+		if usage.UsedPct > maxUsed {
+			maxUsed = usage.UsedPct
+		}
+		if usage.InodesUsedPct > maxInodes {
+			maxInodes = usage.InodesUsedPct
+		}
+	}
+	if usages[0].HoursToFull > 0 {
+		result.Metadata["hours_to_full"] = usages[0].HoursToFull
+	}
+	result.Metadata["mounts"] = usages
+	result.Metadata["max_used_pct"] = maxUsed
+	result.Metadata["max_inodes_used_pct"] = maxInodes
+
+	if mounts != nil {
+		result.Findings = append(result.Findings, d.mountOptionFindings(mounts)...)
+	}
+	return result, nil
+}
+
+func (d *DiskUsage) Halt(_ context.Context) error { return nil }
+
+// selectMounts returns the configured mounts, or every real filesystem when
+// none are configured. Bind mounts and stacked mounts keep the first entry.
+func (d *DiskUsage) selectMounts(mounts []mountEntry) []mountEntry {
+	selected := []mountEntry{}
+	if len(d.mounts) > 0 {
+		wanted := map[string]bool{}
+		for _, mount := range d.mounts {
+			wanted[mount] = true
+		}
+		for _, mount := range mounts {
+			if wanted[mount.MountPoint] {
+				selected = append(selected, mount)
+				delete(wanted, mount.MountPoint)
+			}
+		}
+		for _, mount := range sortedKeys(wanted) {
+			// Not a mount point (or no mountinfo): statfs still answers for
+			// the filesystem holding it.
+			selected = append(selected, mountEntry{MountPoint: mount})
+		}
+		return selected
+	}
+	devices, points := map[string]bool{}, map[string]bool{}
+	for _, mount := range mounts {
+		if pseudoFilesystems[mount.FSType] || d.exclude.Match(mount.MountPoint) || devices[mount.Device] || points[mount.MountPoint] {
+			continue
+		}
+		devices[mount.Device], points[mount.MountPoint] = true, true
+		selected = append(selected, mount)
+	}
+	return selected
+}
+
+func (d *DiskUsage) thresholdFindings(usage mountUsage) []scanner.Finding {
+	findings := []scanner.Finding{}
+	evidence := map[string]interface{}{
+		"path":     usage.Mount,
+		"used_pct": usage.UsedPct,
+	}
+	if usage.UsedPct >= d.critPercent {
+		findings = append(findings, scanner.Finding{
 			ID:          "disk_usage_critical",
 			Severity:    scanner.SeverityCritical,
 			Category:    "resource",
-			Description: fmt.Sprintf("Disk usage %.2f%% exceeds critical threshold", usedPct),
-			Evidence: map[string]interface{}{
-				"path":     d.path,
-				"used_pct": usedPct,
-			},
+			Description: fmt.Sprintf("Disk usage %.2f%% on %s exceeds critical threshold", usage.UsedPct, usage.Mount),
+			Evidence:    evidence,
 			Remediation: "Free disk space or expand storage.",
 		})
-	} else if usedPct >= d.warnPercent {
-		result.Findings = append(result.Findings, scanner.Finding{
+	} else if usage.UsedPct >= d.warnPercent {
+		findings = append(findings, scanner.Finding{
 			ID:          "disk_usage_warning",
 			Severity:    scanner.SeverityMedium,
 			Category:    "resource",
-			Description: fmt.Sprintf("Disk usage %.2f%% exceeds warning threshold", usedPct),
+			Description: fmt.Sprintf("Disk usage %.2f%% on %s exceeds warning threshold", usage.UsedPct, usage.Mount),
+			Evidence:    evidence,
+			Remediation: "Investigate disk usage growth.",
+		})
+	}
+	if usage.InodesTotal == 0 {
+		return findings
+	}
+	inodeEvidence := map[string]interface{}{
+		"path":            usage.Mount,
+		"inodes_used":     usage.InodesUsed,
+		"inodes_total":    usage.InodesTotal,
+		"inodes_used_pct": usage.InodesUsedPct,
+	}
+	if usage.InodesUsedPct >= d.inodeCritPercent {
+		findings = append(findings, scanner.Finding{
+			ID:          "inode_usage_critical",
+			Severity:    scanner.SeverityCritical,
+			Category:    "resource",
+			Description: fmt.Sprintf("Inode usage %.2f%% on %s exceeds critical threshold", usage.InodesUsedPct, usage.Mount),
+			Evidence:    inodeEvidence,
+			Remediation: "Find directories with many small files (mail queues, caches, session stores) and clean them up.",
+		})
+	} else if usage.InodesUsedPct >= d.inodeWarnPercent {
+		findings = append(findings, scanner.Finding{
+			ID:          "inode_usage_warning",
+			Severity:    scanner.SeverityMedium,
+			Category:    "resource",
+			Description: fmt.Sprintf("Inode usage %.2f%% on %s exceeds warning threshold", usage.InodesUsedPct, usage.Mount),
+			Evidence:    inodeEvidence,
+			Remediation: "Investigate which directories are accumulating files.",
+		})
+	}
+	return findings
+}
+
+// forecast stores a sample for the mount and fits a least-squares line over
+// the samples in forecastWindow. A filesystem projected to fill within
+// forecastHorizon is reported; within a quarter of it the finding is high.
+func (d *DiskUsage) forecast(usage *mountUsage, now time.Time) ([]scanner.Finding, error) {
+	var history []diskSample
+	if _, err := loadJSON(d.store, diskHistoryBucket, usage.Mount, &history); err != nil {
+		return nil, err
+	}
+	kept := history[:0]
+	for _, sample := range history {
+		// A resized filesystem invalidates earlier samples; the small drift
+		// in reported size on btrfs, XFS or ZFS does not.
+		if now.Sub(sample.Time) <= d.forecastWindow && math.Abs(sample.Total-usage.Total) <= usage.Total*diskResizeTolerance {
+			kept = append(kept, sample)
+		}
+	}
+	history = append(kept, diskSample{Time: now, Used: usage.Used, Total: usage.Total, InodesUsed: usage.InodesUsed, Inodes: usage.InodesTotal})
+	if err := saveJSON(d.store, diskHistoryBucket, usage.Mount, history); err != nil {
+		return nil, fmt.Errorf("save disk history: %w", err)
+	}
+	if len(history) < d.forecastMinSample {
+		return nil, nil
+	}
+
+	findings := []scanner.Finding{}
+	check := func(id, what string, used func(diskSample) float64, capacity float64) float64 {
+		slope := growthPerHour(history, used)
+		if slope <= 0 {
+			return 0
+		}
+		hours := (capacity - used(history[len(history)-1])) / slope
+		if hours < 0 || hours > d.forecastHorizon.Hours() {
+			return hours
+		}
+		severity := scanner.SeverityMedium
+		if hours <= d.forecastHorizon.Hours()/4 {
+			severity = scanner.SeverityHigh
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          id,
+			Severity:    severity,
+			Category:    "resource",
+			Description: fmt.Sprintf("Filesystem %s will run out of %s in %.1f hours", usage.Mount, what, hours),
 			Evidence: map[string]interface{}{
-				"path":     d.path,
-				"used_pct": usedPct,
+				"path":            usage.Mount,
+				"hours_to_full":   hours,
+				"growth_per_hour": slope,
+				"samples":         len(history),
+				"window":          d.forecastWindow.String(),
 			},
-			Remediation: "Investigate disk usage growth.",
+			Remediation: "Find what is growing (logs, caches, dumps) and free space or expand the filesystem before it fills.",
 		})
+		return hours
 	}
+	if hours := check("disk_full_forecast", "space", func(s diskSample) float64 { return s.Used }, usage.Total); hours > 0 {
+		usage.HoursToFull = hours
+	}
+	if usage.InodesTotal > 0 {
+		if hours := check("inode_full_forecast", "inodes", func(s diskSample) float64 { return s.InodesUsed }, usage.InodesTotal); hours > 0 {
+			usage.InodeHoursFull = hours
+		}
+	}
+	return findings, nil
+}
 
-	return result, nil
+// growthPerHour is the least-squares slope of value over time.
+func growthPerHour(samples []diskSample, value func(diskSample) float64) float64 {
+	origin := samples[0].Time
+	n := float64(len(samples))
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range samples {
+		x := sample.Time.Sub(origin).Hours()
+		y := value(sample)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
 }
 
-func (d *DiskUsage) Halt(_ context.Context) error { return nil }
+func (d *DiskUsage) mountOptionFindings(mounts []mountEntry) []scanner.Finding {
+	byPoint := map[string]mountEntry{}
+	for _, mount := range mounts {
+		// Later entries shadow earlier ones mounted on the same point.
+		byPoint[mount.MountPoint] = mount
+	}
+	findings := []scanner.Finding{}
+	for _, point := range sortedKeys(d.mountOptions) {
+		mount, ok := byPoint[point]
+		if !ok {
+			continue
+		}
+		missing := []string{}
+		for _, option := range d.mountOptions[point] {
+			if !containsOption(mount.Options, option) {
+				missing = append(missing, option)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "mount_options_missing",
+			Severity:    scanner.SeverityMedium,
+			Category:    "configuration",
+			Description: fmt.Sprintf("%s is mounted without %s", point, strings.Join(missing, ",")),
+			Evidence: map[string]interface{}{
+				"path":     point,
+				"fstype":   mount.FSType,
+				"options":  mount.Options,
+				"missing":  missing,
+				"expected": d.mountOptions[point],
+			},
+			Remediation: fmt.Sprintf("Add %s to the %s entry in /etc/fstab (or its systemd mount unit) and remount.", strings.Join(missing, ","), point),
+		})
+	}
+	return findings
+}
+
+func containsOption(options []string, option string) bool {
+	for _, candidate := range options {
+		if candidate == option {
+			return true
+		}
+	}
+	return false
+}
+
+func statMount(path string) (mountUsage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return mountUsage{}, err
+	}
+	usage := mountUsage{Mount: path}
+	usage.Total = float64(stat.Blocks) * float64(stat.Bsize)
+	usage.Used = usage.Total - float64(stat.Bavail)*float64(stat.Bsize)
+	if usage.Total > 0 {
+		usage.UsedPct = (usage.Used / usage.Total) * 100
+	}
+	usage.InodesTotal = float64(stat.Files)
+	usage.InodesUsed = float64(stat.Files) - float64(stat.Ffree)
+	if usage.InodesTotal > 0 {
+		usage.InodesUsedPct = (usage.InodesUsed / usage.InodesTotal) * 100
+	}
+	return usage, nil
+}
+
+// readMountInfo parses /proc/self/mountinfo. Per-mount options (field 6)
+// carry nodev, nosuid and noexec; mount points escape spaces as \040.
+func readMountInfo(path string) ([]mountEntry, error) {
+	mounts := []mountEntry{}
+	err := readLines(path, func(line string) {
+		before, after, ok := strings.Cut(line, " - ")
+		fields := strings.Fields(before)
+		tail := strings.Fields(after)
+		if !ok || len(fields) < 6 || len(tail) < 1 {
+			return
+		}
+		mounts = append(mounts, mountEntry{
+			Device:     fields[2],
+			MountPoint: unescapeMount(fields[4]),
+			FSType:     tail[0],
+			Options:    strings.Split(fields[5], ","),
+		})
+	})
+	sort.SliceStable(mounts, func(i, j int) bool { return len(mounts[i].MountPoint) < len(mounts[j].MountPoint) })
+	return mounts, err
+}
+
+func unescapeMount(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) {
+			if code, err := strconv.ParseUint(value[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -1,6 +1,15 @@
 package system
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
 
 func TestDiskUsageInit(t *testing.T) {
 	du := &DiskUsage{}
@@ -19,3 +28,94 @@ func TestDiskUsageInitRejectsBadThresholds(t *testing.T) {
 		t.Fatalf("expected error for invalid thresholds")
 	}
 }
+
+func TestReadMountInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	data := "22 1 259:2 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p2 rw\n" +
+		"30 22 0:27 / /tmp rw,nosuid,nodev shared:5 - tmpfs tmpfs rw\n" +
+		"31 22 0:5 / /dev/shm rw,nosuid,nodev,noexec - tmpfs tmpfs rw\n" +
+		"40 22 259:2 /srv /mnt/my\\040data rw - ext4 /dev/nvme0n1p2 rw\n" +
+		"41 22 0:22 / /proc rw - proc proc rw\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mounts, err := readMountInfo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 5 || mounts[0].MountPoint != "/" || mounts[len(mounts)-1].MountPoint != "/mnt/my data" {
+		t.Fatalf("unexpected mounts: %+v", mounts)
+	}
+
+	du := &DiskUsage{}
+	if err := du.Init(map[string]interface{}{}); err != nil {
+		t.Fatal(err)
+	}
+	selected := du.selectMounts(mounts)
+	points := []string{}
+	for _, mount := range selected {
+		points = append(points, mount.MountPoint)
+	}
+	// /proc is pseudo and /mnt/my data is a bind of the root device.
+	if strings.Join(points, ",") != "/,/tmp,/dev/shm" {
+		t.Fatalf("unexpected selection: %v", points)
+	}
+
+	findings := du.mountOptionFindings(mounts)
+	if len(findings) != 1 || findings[0].Evidence["path"] != "/tmp" {
+		t.Fatalf("expected /tmp finding, got %+v", findings)
+	}
+	if missing := findings[0].Evidence["missing"].([]string); len(missing) != 1 || missing[0] != "noexec" {
+		t.Fatalf("unexpected missing options: %v", missing)
+	}
+}
+
+func TestDiskUsageForecast(t *testing.T) {
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	du := &DiskUsage{}
+	if err := du.Init(map[string]interface{}{"forecast_min_samples": float64(3)}); err != nil {
+		t.Fatal(err)
+	}
+	du.WithStore(store)
+
+	now := time.Now()
+	gib := float64(1 << 30)
+	history := []diskSample{}
+	for i := 4; i > 0; i-- {
+		// 1 GiB per hour growth, ending at 90 GiB used of 100; the reported
+		// size drifts slightly as filesystem metadata grows.
+		history = append(history, diskSample{Time: now.Add(-time.Duration(i) * time.Hour), Used: (90 - float64(i)) * gib, Total: (100 + 0.1*float64(i)) * gib})
+	}
+	if err := saveJSON(store, diskHistoryBucket, "/data", history); err != nil {
+		t.Fatal(err)
+	}
+	usage := mountUsage{Mount: "/data", Used: 90 * gib, Total: 100 * gib}
+	findings, err := du.forecast(&usage, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].ID != "disk_full_forecast" {
+		t.Fatalf("expected forecast finding, got %+v", findings)
+	}
+	if usage.HoursToFull < 9.9 || usage.HoursToFull > 10.1 {
+		t.Fatalf("expected ~10 hours to full, got %v", usage.HoursToFull)
+	}
+	if findings[0].Severity != scanner.SeverityMedium {
+		t.Fatalf("expected medium severity, got %v", findings[0].Severity)
+	}
+
+	// Growing the filesystem starts the history over.
+	usage = mountUsage{Mount: "/data", Used: 91 * gib, Total: 200 * gib}
+	findings, err = du.forecast(&usage, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 0 || usage.HoursToFull != 0 {
+		t.Fatalf("expected no forecast after resize, got %+v", findings)
+	}
+}
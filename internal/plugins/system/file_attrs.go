@@ -0,0 +1,67 @@
+package system
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const capabilityXattr = "security.capability"
+
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner",
+	"cap_fsetid", "cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap",
+	"cap_linux_immutable", "cap_net_bind_service", "cap_net_broadcast",
+	"cap_net_admin", "cap_net_raw", "cap_ipc_lock", "cap_ipc_owner",
+	"cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice",
+	"cap_sys_resource", "cap_sys_time", "cap_sys_tty_config", "cap_mknod",
+	"cap_lease", "cap_audit_write", "cap_audit_control", "cap_setfcap",
+	"cap_mac_override", "cap_mac_admin", "cap_syslog", "cap_wake_alarm",
+	"cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// describeCapabilities renders a hex-encoded vfs_cap_data value in the same
+// style as getcap, e.g. "cap_net_raw,cap_setuid=ep".
+func describeCapabilities(rawHex string) string {
+	raw, err := hex.DecodeString(rawHex)
+	if err != nil || len(raw) < 12 {
+		return rawHex
+	}
+	magic := binary.LittleEndian.Uint32(raw[0:4])
+	words := 1
+	if magic&0xFF000000 >= 0x02000000 && len(raw) >= 20 {
+		words = 2
+	}
+	var permitted, inheritable uint64
+	for i := 0; i < words; i++ {
+		base := 4 + i*8
+		permitted |= uint64(binary.LittleEndian.Uint32(raw[base:base+4])) << (32 * i)
+		inheritable |= uint64(binary.LittleEndian.Uint32(raw[base+4:base+8])) << (32 * i)
+	}
+
+	names := []string{}
+	for bit := 0; bit < 64; bit++ {
+		if (permitted|inheritable)&(1<<bit) == 0 {
+			continue
+		}
+		if bit < len(capabilityNames) {
+			names = append(names, capabilityNames[bit])
+		} else {
+			names = append(names, fmt.Sprintf("cap_%d", bit))
+		}
+	}
+	flags := ""
+	if magic&0x1 != 0 {
+		flags += "e"
+	}
+	if inheritable != 0 {
+		flags += "i"
+	}
+	if permitted != 0 {
+		flags += "p"
+	}
+	return strings.Join(names, ",") + "=" + flags
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package system
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readSecurityXattrs returns the security.* extended attributes of path
+// (without following symlinks) as hex-encoded values.
+func readSecurityXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil {
+		if err == unix.ENOTSUP || err == unix.ENODATA {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	out := map[string]string{}
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		attr := string(name)
+		if !strings.HasPrefix(attr, "security.") {
+			continue
+		}
+		valueSize, err := unix.Lgetxattr(path, attr, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(path, attr, value)
+		if err != nil {
+			continue
+		}
+		out[attr] = hex.EncodeToString(value[:valueSize])
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+func fileIdentity(info os.FileInfo) (inode, device uint64, ctimeNs int64, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return st.Ino, uint64(st.Dev), st.Ctim.Nano(), true
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package system
+
+import "os"
+
+func readSecurityXattrs(_ string) (map[string]string, error) {
+	return nil, nil
+}
+
+func fileIdentity(_ os.FileInfo) (uint64, uint64, int64, bool) {
+	return 0, 0, 0, false
+}
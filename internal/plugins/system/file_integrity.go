@@ -2,43 +2,156 @@ package system
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	fileBaselineBucket  = "fim_baseline"
+	fileHashCacheBucket = "fim_hash_cache"
 )
 
 type FileIntegrity struct {
-	paths []string
+	paths           []string
+	realtime        bool
+	realtimeBackend string
+	debounce        time.Duration
+	trackXattrs     bool
+	include         globSet
+	exclude         globSet
+	maxFileSize     int64
+	hashAlgorithm   string
+	hashCache       bool
+	store           storage.Store
+	mu              sync.Mutex
+}
+
+type fileRecord struct {
+	Path          string            `json:"path"`
+	Hash          string            `json:"hash"`
+	HashAlgorithm string            `json:"hash_algorithm,omitempty"`
+	HashSkipped   bool              `json:"hash_skipped,omitempty"`
+	Size          int64             `json:"size"`
+	Mode          os.FileMode       `json:"mode"`
+	UID           uint32            `json:"uid"`
+	GID           uint32            `json:"gid"`
+	ModTime       time.Time         `json:"mod_time"`
+	LinkTarget    string            `json:"link_target,omitempty"`
+	Xattrs        map[string]string `json:"xattrs,omitempty"`
+}
+
+type hashCacheEntry struct {
+	Inode      uint64 `json:"inode"`
+	Device     uint64 `json:"device"`
+	Size       int64  `json:"size"`
+	ModTimeNs  int64  `json:"mod_time_ns"`
+	ChangeTime int64  `json:"change_time_ns"`
+	Algorithm  string `json:"algorithm"`
+	Hash       string `json:"hash"`
+}
+
+type scanStats struct {
+	hashed       int
+	cacheHits    int
+	skippedLarge int
+	excluded     int
 }
 
 func (f *FileIntegrity) Name() string { return "system.file_integrity" }
 
+func (f *FileIntegrity) WithStore(store storage.Store) {
+	f.store = store
+}
+
 func (f *FileIntegrity) Init(config map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	f.paths = []string{"/etc", "/bin"}
-	if v, ok := config["paths"].([]interface{}); ok {
-		f.paths = make([]string, 0, len(v))
-		for _, raw := range v {
-			if path, ok := raw.(string); ok && path != "" {
-				f.paths = append(f.paths, path)
-			}
-		}
-	}
-	if v, ok := config["paths"].([]string); ok && len(v) > 0 {
-		f.paths = append([]string{}, v...)
+	f.realtime = false
+	f.realtimeBackend = "auto"
+	f.debounce = 2 * time.Second
+	f.trackXattrs = true
+	f.include = nil
+	f.exclude = nil
+	f.maxFileSize = 0
+	f.hashAlgorithm = "sha256"
+	f.hashCache = true
+	if v, ok := configStrings(config, "paths"); ok {
+		f.paths = v
 	}
 	if len(f.paths) == 0 {
 		return fmt.Errorf("paths must not be empty")
 	}
+	if v, ok := config["realtime"].(bool); ok {
+		f.realtime = v
+	}
+	if v, ok := config["realtime_backend"].(string); ok && v != "" {
+		f.realtimeBackend = strings.ToLower(v)
+	}
+	switch f.realtimeBackend {
+	case "auto", "inotify", "fanotify":
+	default:
+		return fmt.Errorf("realtime_backend must be one of: auto, inotify, fanotify")
+	}
+	if v, ok := config["debounce_ms"].(float64); ok && v > 0 {
+		f.debounce = time.Duration(v) * time.Millisecond
+	}
+	if v, ok := config["track_xattrs"].(bool); ok {
+		f.trackXattrs = v
+	}
+	if v, ok := configStrings(config, "include"); ok {
+		globs, err := compileGlobs(v)
+		if err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+		f.include = globs
+	}
+	if v, ok := configStrings(config, "exclude"); ok {
+		globs, err := compileGlobs(v)
+		if err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+		f.exclude = globs
+	}
+	if v, ok := config["max_file_size"].(float64); ok && v > 0 {
+		f.maxFileSize = int64(v)
+	}
+	if v, ok := config["hash_algorithm"].(string); ok && v != "" {
+		f.hashAlgorithm = strings.ToLower(v)
+	}
+	switch f.hashAlgorithm {
+	case "sha256", "sha512", "sha1":
+	default:
+		return fmt.Errorf("hash_algorithm must be one of: sha256, sha512, sha1")
+	}
+	if v, ok := config["hash_cache"].(bool); ok {
+		f.hashCache = v
+	}
 	return nil
 }
 
-func (f *FileIntegrity) Run(_ context.Context) (*scanner.Result, error) {
+func (f *FileIntegrity) Run(ctx context.Context) (*scanner.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	result := &scanner.Result{
 		ScannerName: f.Name(),
 		Status:      scanner.StatusSuccess,
@@ -47,10 +160,85 @@ func (f *FileIntegrity) Run(_ context.Context) (*scanner.Result, error) {
 		},
 	}
 
-	hashes := make(map[string]string)
+	current, failed, stats, err := f.snapshot(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string, len(current))
+	for path, rec := range current {
+		hashes[path] = rec.Hash
+	}
+	result.Metadata["hashes"] = hashes
+	result.Metadata["files"] = len(current)
+	result.Metadata["hashed_files"] = stats.hashed
+	result.Metadata["hash_cache_hits"] = stats.cacheHits
+	result.Metadata["skipped_large_files"] = stats.skippedLarge
+	result.Metadata["excluded_files"] = stats.excluded
+
+	if f.store == nil {
+		return result, nil
+	}
+
+	baseline, err := f.loadBaseline()
+	if err != nil {
+		return nil, err
+	}
+	if len(baseline) == 0 {
+		if err := f.saveBaseline(current); err != nil {
+			return nil, err
+		}
+		result.Metadata["baseline_created"] = true
+		return result, nil
+	}
+
+	result.Metadata["baseline_files"] = len(baseline)
+	for path := range baseline {
+		if !underAny(path, f.paths) || !f.selected(path) {
+			delete(baseline, path)
+		}
+	}
+	result.Findings = append(result.Findings, diffFileRecords(baseline, current, failed)...)
+	return result, nil
+}
+
+func (f *FileIntegrity) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline replaces the stored baseline with the current state of the
+// configured paths, e.g. after a planned package upgrade.
+func (f *FileIntegrity) AcceptBaseline(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.store == nil {
+		return fmt.Errorf("file integrity baseline requires storage")
+	}
+	current, _, _, err := f.snapshot(ctx, &scanner.Result{})
+	if err != nil {
+		return err
+	}
+	if err := clearBucket(f.store, fileBaselineBucket); err != nil {
+		return fmt.Errorf("clear baseline: %w", err)
+	}
+	return f.saveBaseline(current)
+}
+
+func (f *FileIntegrity) snapshot(ctx context.Context, result *scanner.Result) (map[string]fileRecord, []string, scanStats, error) {
+	records := make(map[string]fileRecord)
+	failed := []string{}
+	stats := scanStats{}
+	cache, err := f.loadHashCache()
+	if err != nil {
+		return nil, nil, stats, err
+	}
+	seen := make(map[string]hashCacheEntry)
 	for _, root := range f.paths {
 		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
 			if err != nil {
+				failed = append(failed, path)
 				result.Findings = append(result.Findings, scanner.Finding{
 					ID:          "file_access_error",
 					Severity:    scanner.SeverityLow,
@@ -65,10 +253,22 @@ func (f *FileIntegrity) Run(_ context.Context) (*scanner.Result, error) {
 				return nil
 			}
 			if d.IsDir() {
+				if path != root && f.exclude.Match(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !f.selected(path) {
+				stats.excluded++
 				return nil
 			}
-			hash, err := hashFile(path)
+			var rec fileRecord
+			info, err := d.Info()
+			if err == nil {
+				rec, err = f.buildRecord(path, info, cache, seen, &stats)
+			}
 			if err != nil {
+				failed = append(failed, path)
 				result.Findings = append(result.Findings, scanner.Finding{
 					ID:          "file_hash_error",
 					Severity:    scanner.SeverityLow,
@@ -82,28 +282,406 @@ func (f *FileIntegrity) Run(_ context.Context) (*scanner.Result, error) {
 				})
 				return nil
 			}
-			hashes[path] = hash
+			records[path] = rec
 			return nil
 		})
 		if err != nil {
-			return nil, fmt.Errorf("walk %s: %w", root, err)
+			return nil, nil, stats, fmt.Errorf("walk %s: %w", root, err)
 		}
 	}
+	if err := f.saveHashCache(cache, seen); err != nil {
+		return nil, nil, stats, err
+	}
+	return records, failed, stats, nil
+}
 
-	result.Metadata["hashes"] = hashes
-	return result, nil
+func (f *FileIntegrity) selected(path string) bool {
+	if f.exclude.Match(path) {
+		return false
+	}
+	return len(f.include) == 0 || f.include.Match(path)
 }
 
-func (f *FileIntegrity) Halt(_ context.Context) error { return nil }
+func (f *FileIntegrity) loadHashCache() (map[string]hashCacheEntry, error) {
+	cache := make(map[string]hashCacheEntry)
+	if f.store == nil || !f.hashCache {
+		return cache, nil
+	}
+	err := f.store.ForEach(fileHashCacheBucket, func(key, value []byte) error {
+		var entry hashCacheEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return nil
+		}
+		cache[string(key)] = entry
+		return nil
+	})
+	if err != nil && err != storage.ErrNotFound {
+		return nil, fmt.Errorf("load hash cache: %w", err)
+	}
+	return cache, nil
+}
+
+// saveHashCache writes entries that changed during this walk and drops
+// entries for files that were not seen under the configured paths.
+func (f *FileIntegrity) saveHashCache(previous, seen map[string]hashCacheEntry) error {
+	if f.store == nil || !f.hashCache {
+		return nil
+	}
+	for path, entry := range seen {
+		if old, ok := previous[path]; ok && old == entry {
+			continue
+		}
+		if err := saveJSON(f.store, fileHashCacheBucket, path, entry); err != nil {
+			return fmt.Errorf("save hash cache: %w", err)
+		}
+	}
+	for path := range previous {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		if err := f.store.Delete(fileHashCacheBucket, path); err != nil {
+			return fmt.Errorf("prune hash cache: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *FileIntegrity) loadBaseline() (map[string]fileRecord, error) {
+	baseline := make(map[string]fileRecord)
+	err := f.store.ForEach(fileBaselineBucket, func(key, value []byte) error {
+		var rec fileRecord
+		if err := json.Unmarshal(value, &rec); err != nil {
+			return fmt.Errorf("decode baseline %s: %w", string(key), err)
+		}
+		baseline[string(key)] = rec
+		return nil
+	})
+	if err != nil && err != storage.ErrNotFound {
+		return nil, fmt.Errorf("load baseline: %w", err)
+	}
+	return baseline, nil
+}
+
+func (f *FileIntegrity) saveBaseline(records map[string]fileRecord) error {
+	for path, rec := range records {
+		if err := saveJSON(f.store, fileBaselineBucket, path, rec); err != nil {
+			return fmt.Errorf("save baseline: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *FileIntegrity) buildRecord(path string, info os.FileInfo, cache, seen map[string]hashCacheEntry, stats *scanStats) (fileRecord, error) {
+	rec := fileRecord{
+		Path:    path,
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime().UTC(),
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		rec.UID = st.Uid
+		rec.GID = st.Gid
+	}
+	if f.trackXattrs {
+		xattrs, err := readSecurityXattrs(path)
+		if err != nil {
+			return fileRecord{}, fmt.Errorf("read xattrs: %w", err)
+		}
+		rec.Xattrs = xattrs
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fileRecord{}, err
+		}
+		rec.LinkTarget = target
+		return rec, nil
+	}
+	if !info.Mode().IsRegular() {
+		return rec, nil
+	}
+	if f.maxFileSize > 0 && info.Size() > f.maxFileSize {
+		rec.HashSkipped = true
+		if stats != nil {
+			stats.skippedLarge++
+		}
+		return rec, nil
+	}
+
+	rec.HashAlgorithm = f.hashAlgorithm
+	key, cacheable := hashCacheKey(info, f.hashAlgorithm)
+	if cached, ok := cache[path]; ok && cacheable && cached.matches(key) {
+		rec.Hash = cached.Hash
+		if stats != nil {
+			stats.cacheHits++
+		}
+	} else {
+		sum, err := hashFileWith(path, f.hashAlgorithm)
+		if err != nil {
+			return fileRecord{}, err
+		}
+		rec.Hash = sum
+		if stats != nil {
+			stats.hashed++
+		}
+	}
+	if cacheable && seen != nil {
+		key.Hash = rec.Hash
+		seen[path] = key
+	}
+	return rec, nil
+}
+
+func hashCacheKey(info os.FileInfo, algorithm string) (hashCacheEntry, bool) {
+	inode, device, ctime, ok := fileIdentity(info)
+	if !ok {
+		return hashCacheEntry{}, false
+	}
+	return hashCacheEntry{
+		Inode:      inode,
+		Device:     device,
+		Size:       info.Size(),
+		ModTimeNs:  info.ModTime().UnixNano(),
+		ChangeTime: ctime,
+		Algorithm:  algorithm,
+	}, true
+}
+
+func (e hashCacheEntry) matches(key hashCacheEntry) bool {
+	return e.Inode == key.Inode && e.Device == key.Device && e.Size == key.Size &&
+		e.ModTimeNs == key.ModTimeNs && e.ChangeTime == key.ChangeTime &&
+		e.Algorithm == key.Algorithm && e.Hash != ""
+}
+
+func diffFileRecords(baseline, current map[string]fileRecord, failed []string) []scanner.Finding {
+	findings := []scanner.Finding{}
+
+	paths := make([]string, 0, len(current))
+	for path := range current {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		after := current[path]
+		before, ok := baseline[path]
+		if !ok {
+			severity := scanner.SeverityMedium
+			if after.privileged() {
+				severity = scanner.SeverityHigh
+			}
+			findings = append(findings, scanner.Finding{
+				ID:          "file_added",
+				Severity:    severity,
+				Category:    "file_integrity",
+				Description: fmt.Sprintf("File added: %s", path),
+				Evidence: map[string]interface{}{
+					"path":  path,
+					"after": after.evidence(),
+				},
+				Remediation: "Verify the new file is expected or accept the current baseline.",
+			})
+			continue
+		}
+		content, privilege, metadata := before.changes(after)
+		if len(content) > 0 {
+			findings = append(findings, changeFinding("file_modified", scanner.SeverityHigh, "File modified", path, content, before, after,
+				"Verify the change was authorized or accept the current baseline."))
+		}
+		if len(privilege) > 0 {
+			findings = append(findings, changeFinding("file_privilege_changed", scanner.SeverityCritical, "Privilege-relevant attributes changed", path, privilege, before, after,
+				"Confirm the setuid/setgid bits, capabilities, or permissions were changed intentionally; revert them otherwise."))
+		}
+		if len(metadata) > 0 {
+			findings = append(findings, changeFinding("file_metadata_changed", scanner.SeverityMedium, "File metadata changed", path, metadata, before, after,
+				"Verify the permission or ownership change was authorized or accept the current baseline."))
+		}
+	}
+
+	paths = paths[:0]
+	for path := range baseline {
+		if _, ok := current[path]; ok || underAny(path, failed) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		findings = append(findings, scanner.Finding{
+			ID:          "file_deleted",
+			Severity:    scanner.SeverityHigh,
+			Category:    "file_integrity",
+			Description: fmt.Sprintf("File deleted: %s", path),
+			Evidence: map[string]interface{}{
+				"path":   path,
+				"before": baseline[path].evidence(),
+			},
+			Remediation: "Verify the removal was authorized or accept the current baseline.",
+		})
+	}
+	attributeFileFindings(findings)
+	return findings
+}
+
+func changeFinding(id string, severity scanner.Severity, summary, path string, changes []string, before, after fileRecord, remediation string) scanner.Finding {
+	return scanner.Finding{
+		ID:          id,
+		Severity:    severity,
+		Category:    "file_integrity",
+		Description: fmt.Sprintf("%s: %s (%s)", summary, path, strings.Join(changes, ", ")),
+		Evidence: map[string]interface{}{
+			"path":    path,
+			"changes": changes,
+			"before":  before.evidence(),
+			"after":   after.evidence(),
+		},
+		Remediation: remediation,
+	}
+}
+
+// changes classifies differences into content changes, privilege-relevant
+// metadata changes (new setuid/setgid bits, capabilities, world-writable
+// permissions, ownership of setuid files) and other metadata changes.
+func (r fileRecord) changes(other fileRecord) (content, privilege, metadata []string) {
+	if r.HashSkipped || other.HashSkipped || r.algorithm() != other.algorithm() {
+		if r.Size != other.Size || !r.ModTime.Equal(other.ModTime) {
+			content = append(content, "size_mtime")
+		}
+	} else if r.Hash != other.Hash {
+		content = append(content, "content")
+	}
+	if r.LinkTarget != other.LinkTarget {
+		content = append(content, "link_target")
+	}
+
+	gained := other.Mode &^ r.Mode
+	if gained&os.ModeSetuid != 0 {
+		privilege = append(privilege, "setuid")
+	}
+	if gained&os.ModeSetgid != 0 {
+		privilege = append(privilege, "setgid")
+	}
+	if gained&0o002 != 0 && other.Mode&os.ModeSymlink == 0 {
+		privilege = append(privilege, "world_writable")
+	}
+	if r.Xattrs[capabilityXattr] != other.Xattrs[capabilityXattr] {
+		privilege = append(privilege, "capabilities")
+	}
+	if r.UID != other.UID || r.GID != other.GID {
+		if r.privileged() || other.privileged() {
+			privilege = append(privilege, "owner")
+		} else {
+			metadata = append(metadata, "owner")
+		}
+	}
+
+	lost := r.Mode &^ other.Mode
+	if lost&(os.ModeSetuid|os.ModeSetgid) != 0 {
+		metadata = append(metadata, "setuid_setgid_removed")
+	}
+	if (r.Mode^other.Mode)&os.ModeSticky != 0 {
+		metadata = append(metadata, "sticky")
+	}
+	if (r.Mode^other.Mode)&(os.ModePerm&^0o002) != 0 || lost&0o002 != 0 {
+		metadata = append(metadata, "mode")
+	}
+	if (r.Mode^other.Mode)&os.ModeType != 0 {
+		content = append(content, "type")
+	}
+	for name := range mergeKeys(r.Xattrs, other.Xattrs) {
+		if name != capabilityXattr && r.Xattrs[name] != other.Xattrs[name] {
+			metadata = append(metadata, "xattr:"+name)
+		}
+	}
+	sort.Strings(metadata)
+	return content, privilege, metadata
+}
+
+func (r fileRecord) algorithm() string {
+	if r.HashAlgorithm == "" && r.Hash != "" {
+		return "sha256"
+	}
+	return r.HashAlgorithm
+}
+
+func (r fileRecord) privileged() bool {
+	return r.Mode&(os.ModeSetuid|os.ModeSetgid) != 0 || r.Xattrs[capabilityXattr] != ""
+}
+
+func (r fileRecord) evidence() map[string]interface{} {
+	evidence := map[string]interface{}{
+		"hash":           r.Hash,
+		"hash_algorithm": r.algorithm(),
+		"size":           r.Size,
+		"mode":           r.Mode.String(),
+		"uid":            r.UID,
+		"gid":            r.GID,
+		"mod_time":       r.ModTime.Format(time.RFC3339),
+		"setuid":         r.Mode&os.ModeSetuid != 0,
+		"setgid":         r.Mode&os.ModeSetgid != 0,
+		"sticky":         r.Mode&os.ModeSticky != 0,
+	}
+	if r.LinkTarget != "" {
+		evidence["link_target"] = r.LinkTarget
+	}
+	if len(r.Xattrs) > 0 {
+		evidence["xattrs"] = r.Xattrs
+	}
+	if raw := r.Xattrs[capabilityXattr]; raw != "" {
+		evidence["capabilities"] = describeCapabilities(raw)
+	}
+	return evidence
+}
+
+func mergeKeys(a, b map[string]string) map[string]struct{} {
+	out := make(map[string]struct{}, len(a)+len(b))
+	for k := range a {
+		out[k] = struct{}{}
+	}
+	for k := range b {
+		out[k] = struct{}{}
+	}
+	return out
+}
+
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", algorithm)
+	}
+}
 
 func hashFile(path string) (string, error) {
+	return hashFileWith(path, "sha256")
+}
+
+func hashFileWith(path, algorithm string) (string, error) {
+	hasher, err := newHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hasher := sha256.New()
 	if _, err := io.Copy(hasher, file); err != nil {
 		return "", err
 	}
@@ -5,6 +5,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
 func TestFileIntegrityInitRequiresPaths(t *testing.T) {
@@ -38,3 +41,185 @@ func TestFileIntegrityRun(t *testing.T) {
 		t.Fatalf("expected hash entry for %s", path)
 	}
 }
+
+func TestFileIntegrityDetectsChanges(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	modified := filepath.Join(dir, "modified.txt")
+	deleted := filepath.Join(dir, "deleted.txt")
+	added := filepath.Join(dir, "added.txt")
+	for _, path := range []string{modified, deleted} {
+		if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+			t.Fatalf("write file: %v", err)
+		}
+	}
+
+	fi := &FileIntegrity{}
+	fi.WithStore(store)
+	if err := fi.Init(map[string]interface{}{"paths": []interface{}{dir}}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Metadata["baseline_created"] != true || len(result.Findings) != 0 {
+		t.Fatalf("expected first run to create baseline without findings")
+	}
+
+	if err := os.WriteFile(modified, []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("modify: %v", err)
+	}
+	if err := os.Remove(deleted); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := os.WriteFile(added, []byte("new"), 0o600); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	result, err = fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := map[string]string{}
+	for _, finding := range result.Findings {
+		got[finding.ID] = finding.Evidence["path"].(string)
+	}
+	if got["file_added"] != added || got["file_modified"] != modified || got["file_deleted"] != deleted {
+		t.Fatalf("unexpected findings: %v", got)
+	}
+
+	if err := fi.AcceptBaseline(context.Background()); err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	result, err = fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 0 {
+		t.Fatalf("expected no findings after accept, got %d", len(result.Findings))
+	}
+}
+
+func TestFileIntegrityPrivilegeChange(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	path := filepath.Join(dir, "tool")
+	if err := os.WriteFile(path, []byte("binary"), 0o755); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	fi := &FileIntegrity{}
+	fi.WithStore(store)
+	if err := fi.Init(map[string]interface{}{"paths": []interface{}{dir}}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if _, err := fi.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := os.Chmod(path, 0o755|os.ModeSetuid); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	result, err := fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 1 {
+		t.Fatalf("expected 1 finding, got %+v", result.Findings)
+	}
+	finding := result.Findings[0]
+	if finding.ID != "file_privilege_changed" || finding.Severity != scanner.SeverityCritical {
+		t.Fatalf("expected critical file_privilege_changed, got %s/%s", finding.ID, finding.Severity)
+	}
+}
+
+func TestFileIntegrityExcludeAndHashCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	kept := filepath.Join(dir, "kept.conf")
+	if err := os.WriteFile(kept, []byte("keep"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "noise.log"), []byte("skip"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "cache"), 0o700); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cache", "blob"), []byte("skip"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	fi := &FileIntegrity{}
+	fi.WithStore(store)
+	if err := fi.Init(map[string]interface{}{
+		"paths":          []interface{}{dir},
+		"exclude":        []interface{}{"*.log", "**/cache"},
+		"hash_algorithm": "sha512",
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	hashes := result.Metadata["hashes"].(map[string]string)
+	if len(hashes) != 1 || len(hashes[kept]) != 128 {
+		t.Fatalf("expected only %s hashed with sha512, got %v", kept, hashes)
+	}
+
+	result, err = fi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Metadata["hash_cache_hits"] != 1 || result.Metadata["hashed_files"] != 0 {
+		t.Fatalf("expected second run to reuse cached hash, got %v", result.Metadata)
+	}
+	if len(result.Findings) != 0 {
+		t.Fatalf("expected no findings, got %d", len(result.Findings))
+	}
+}
+
+func TestGlobSetMatch(t *testing.T) {
+	globs, err := compileGlobs([]string{"*.swp", "/var/lib/**/tmp", "/etc/[!a]*.d"})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	cases := map[string]bool{
+		"/etc/.passwd.swp":        true,
+		"/var/lib/app/x/tmp":      true,
+		"/var/lib/tmp":            true,
+		"/etc/cron.d":             true,
+		"/etc/apt.d":              false,
+		"/etc/passwd":             false,
+		"/var/lib/app/tmp/nested": false,
+	}
+	for path, want := range cases {
+		if got := globs.Match(path); got != want {
+			t.Fatalf("Match(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestDescribeCapabilities(t *testing.T) {
+	got := describeCapabilities("0100000200200000000000000000000000000000")
+	if got != "cap_net_raw=ep" {
+		t.Fatalf("expected cap_net_raw=ep, got %s", got)
+	}
+}
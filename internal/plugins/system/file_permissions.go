@@ -0,0 +1,299 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const filePermissionsBucket = "file_permissions"
+
+const (
+	permSetuid        = "setuid"
+	permSetgid        = "setgid"
+	permWorldWritable = "world_writable"
+	permWritableDir   = "world_writable_dir"
+	permUnowned       = "unowned"
+)
+
+// FilePermissions inventories setuid/setgid files, world-writable files and
+// directories without the sticky bit, and files whose owner or group does not
+// exist, and reports entries not seen on the previous run.
+type FilePermissions struct {
+	paths      []string
+	exclude    globSet
+	oneDevice  bool
+	passwdPath string
+	groupPath  string
+	store      storage.Store
+}
+
+type permissionEntry struct {
+	Flags  []string `json:"flags"`
+	Mode   string   `json:"mode"`
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256,omitempty"`
+}
+
+func (f *FilePermissions) Name() string { return "system.file_permissions" }
+
+func (f *FilePermissions) WithStore(store storage.Store) {
+	f.store = store
+}
+
+func (f *FilePermissions) Init(config map[string]interface{}) error {
+	f.paths = []string{"/"}
+	f.oneDevice = true
+	f.passwdPath = "/etc/passwd"
+	f.groupPath = "/etc/group"
+	// /tmp and /var/tmp are walked: SUID binaries and world-writable
+	// scripts dropped there are exactly what this scanner looks for.
+	exclude := []string{"/proc", "/sys", "/dev", "/run", "/var/lib/docker", "/var/lib/containerd", "/snap"}
+	if v, ok := configStrings(config, "paths"); ok && len(v) > 0 {
+		f.paths = v
+	}
+	if v, ok := configStrings(config, "exclude"); ok {
+		exclude = v
+	}
+	globs, err := compileGlobs(exclude)
+	if err != nil {
+		return fmt.Errorf("exclude: %w", err)
+	}
+	f.exclude = globs
+	if v, ok := config["one_filesystem"].(bool); ok {
+		f.oneDevice = v
+	}
+	if v, ok := config["passwd_path"].(string); ok && v != "" {
+		f.passwdPath = v
+	}
+	if v, ok := config["group_path"].(string); ok && v != "" {
+		f.groupPath = v
+	}
+	return nil
+}
+
+func (f *FilePermissions) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: f.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	inventory, err := f.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, entry := range inventory {
+		for _, flag := range entry.Flags {
+			counts[flag]++
+		}
+	}
+	result.Metadata["setuid_count"] = counts[permSetuid]
+	result.Metadata["setgid_count"] = counts[permSetgid]
+	result.Metadata["world_writable_count"] = counts[permWorldWritable] + counts[permWritableDir]
+	result.Metadata["unowned_count"] = counts[permUnowned]
+
+	if f.store == nil {
+		return result, nil
+	}
+	previous := map[string]permissionEntry{}
+	ok, err := loadJSON(f.store, filePermissionsBucket, "inventory", &previous)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		result.Findings = append(result.Findings, permissionDiff(previous, inventory)...)
+	} else {
+		result.Metadata["baseline_created"] = true
+	}
+	if err := saveJSON(f.store, filePermissionsBucket, "inventory", inventory); err != nil {
+		return nil, fmt.Errorf("save permission inventory: %w", err)
+	}
+	return result, nil
+}
+
+func (f *FilePermissions) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline records the current inventory so only later additions are
+// reported.
+func (f *FilePermissions) AcceptBaseline(ctx context.Context) error {
+	if f.store == nil {
+		return fmt.Errorf("file permission baseline requires storage")
+	}
+	inventory, err := f.collect(ctx)
+	if err != nil {
+		return err
+	}
+	return saveJSON(f.store, filePermissionsBucket, "inventory", inventory)
+}
+
+// collect walks every configured path. With one_filesystem set, directories
+// on a different device than the path they were reached from are skipped,
+// like find -xdev.
+func (f *FilePermissions) collect(ctx context.Context) (map[string]permissionEntry, error) {
+	users, groups := map[uint32]bool{}, map[uint32]bool{}
+	passwd, err := readPasswd(f.passwdPath)
+	if err != nil {
+		return nil, fmt.Errorf("read passwd: %w", err)
+	}
+	for _, user := range passwd {
+		users[uint32(user.UID)] = true
+	}
+	groupList, err := readGroups(f.groupPath)
+	if err != nil {
+		return nil, fmt.Errorf("read group: %w", err)
+	}
+	for _, group := range groupList {
+		groups[uint32(group.GID)] = true
+	}
+
+	inventory := map[string]permissionEntry{}
+	for _, root := range f.paths {
+		rootInfo, err := os.Stat(root)
+		if err != nil {
+			continue
+		}
+		_, rootDevice, _, hasDevice := fileIdentity(rootInfo)
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if f.exclude.Match(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && f.oneDevice && hasDevice && path != root {
+				if _, device, _, ok := fileIdentity(info); ok && device != rootDevice {
+					return filepath.SkipDir
+				}
+			}
+			if entry, ok := permissionRecord(path, info, users, groups); ok {
+				inventory[path] = entry
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inventory, nil
+}
+
+// permissionRecord classifies one file; ok is false when nothing about it is
+// worth tracking. Setuid and setgid files are hashed so replacement is seen.
+func permissionRecord(path string, info os.FileInfo, users, groups map[uint32]bool) (permissionEntry, bool) {
+	mode := info.Mode()
+	if mode&os.ModeSymlink != 0 {
+		return permissionEntry{}, false
+	}
+	entry := permissionEntry{Flags: []string{}, Mode: mode.String(), Size: info.Size()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.UID, entry.GID = st.Uid, st.Gid
+		if !users[st.Uid] || !groups[st.Gid] {
+			entry.Flags = append(entry.Flags, permUnowned)
+		}
+	}
+	switch {
+	case mode.IsRegular():
+		if mode&os.ModeSetuid != 0 {
+			entry.Flags = append(entry.Flags, permSetuid)
+		}
+		if mode&os.ModeSetgid != 0 {
+			entry.Flags = append(entry.Flags, permSetgid)
+		}
+		if mode.Perm()&0o002 != 0 {
+			entry.Flags = append(entry.Flags, permWorldWritable)
+		}
+		if mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			entry.SHA256, _ = hashFile(path)
+		}
+	case mode.IsDir():
+		if mode.Perm()&0o002 != 0 && mode&os.ModeSticky == 0 {
+			entry.Flags = append(entry.Flags, permWritableDir)
+		}
+	}
+	return entry, len(entry.Flags) > 0
+}
+
+var permissionFindings = map[string]struct {
+	ID          string
+	Severity    scanner.Severity
+	Description string
+	Remediation string
+}{
+	permSetuid:        {"setuid_file_new", scanner.SeverityHigh, "New setuid file %s", "Confirm the binary belongs to an installed package; remove the setuid bit with chmod u-s if not."},
+	permSetgid:        {"setgid_file_new", scanner.SeverityMedium, "New setgid file %s", "Confirm the binary belongs to an installed package; remove the setgid bit with chmod g-s if not."},
+	permWorldWritable: {"world_writable_file_new", scanner.SeverityMedium, "New world-writable file %s", "Remove write access for others with chmod o-w."},
+	permWritableDir:   {"world_writable_dir_new", scanner.SeverityMedium, "New world-writable directory without sticky bit %s", "Remove write access for others or set the sticky bit with chmod +t."},
+	permUnowned:       {"unowned_file_new", scanner.SeverityLow, "New file with no valid owner or group %s", "Assign the file to an existing user and group, or remove it."},
+}
+
+func permissionDiff(previous, current map[string]permissionEntry) []scanner.Finding {
+	findings := []scanner.Finding{}
+	for _, path := range sortedKeys(current) {
+		entry := current[path]
+		before, existed := previous[path]
+		had := map[string]bool{}
+		for _, flag := range before.Flags {
+			had[flag] = true
+		}
+		evidence := map[string]interface{}{
+			"path":  path,
+			"mode":  entry.Mode,
+			"uid":   entry.UID,
+			"gid":   entry.GID,
+			"flags": entry.Flags,
+		}
+		if entry.SHA256 != "" {
+			evidence["sha256"] = entry.SHA256
+		}
+		for _, flag := range entry.Flags {
+			if had[flag] {
+				continue
+			}
+			spec := permissionFindings[flag]
+			findings = append(findings, scanner.Finding{
+				ID:          spec.ID,
+				Severity:    spec.Severity,
+				Category:    "permissions",
+				Description: fmt.Sprintf(spec.Description, path),
+				Evidence:    evidence,
+				Remediation: spec.Remediation,
+			})
+		}
+		if existed && before.SHA256 != "" && entry.SHA256 != "" && before.SHA256 != entry.SHA256 {
+			evidence["previous_sha256"] = before.SHA256
+			findings = append(findings, scanner.Finding{
+				ID:          "setuid_file_modified",
+				Severity:    scanner.SeverityHigh,
+				Category:    "permissions",
+				Description: fmt.Sprintf("Setuid/setgid file %s changed content", path),
+				Evidence:    evidence,
+				Remediation: "Verify the file against its package (dpkg --verify, rpm -V) and reinstall it if it was tampered with.",
+			})
+		}
+	}
+	attributeFileFindings(findings)
+	return findings
+}
@@ -0,0 +1,133 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestFilePermissions(t *testing.T) {
+	root := t.TempDir()
+	etc := t.TempDir()
+	write := func(name, content string, mode os.FileMode) string {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("chmod: %v", err)
+		}
+		return path
+	}
+	passwd := fmt.Sprintf("root:x:0:0:root:/root:/bin/bash\nme:x:%d:%d::/home/me:/bin/sh\n", os.Getuid(), os.Getgid())
+	group := fmt.Sprintf("root:x:0:\nme:x:%d:\n", os.Getgid())
+	if err := os.WriteFile(filepath.Join(etc, "passwd"), []byte(passwd), 0o644); err != nil {
+		t.Fatalf("write passwd: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(etc, "group"), []byte(group), 0o644); err != nil {
+		t.Fatalf("write group: %v", err)
+	}
+	write("usr/bin/passwd", "v1", 0o755|os.ModeSetuid)
+	write("usr/bin/ls", "ls", 0o755)
+	write("cache/skip", "", 0o777)
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &FilePermissions{}
+	plugin.WithStore(store)
+	config := map[string]interface{}{
+		"paths":       []interface{}{root},
+		"exclude":     []interface{}{filepath.Join(root, "cache")},
+		"passwd_path": filepath.Join(etc, "passwd"),
+		"group_path":  filepath.Join(etc, "group"),
+	}
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) []string {
+		t.Helper()
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		if step == "first" && (result.Metadata["setuid_count"] != 1 || result.Metadata["baseline_created"] != true) {
+			t.Fatalf("first run metadata: %v", result.Metadata)
+		}
+		ids := []string{}
+		for _, finding := range result.Findings {
+			rel, _ := filepath.Rel(root, finding.Evidence["path"].(string))
+			ids = append(ids, finding.ID+":"+rel)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if got := run("first"); len(got) != 0 {
+		t.Fatalf("first run: unexpected findings %v", got)
+	}
+
+	write("usr/bin/passwd", "v2", 0o755|os.ModeSetuid)
+	write("usr/bin/ls", "ls", 0o755|os.ModeSetgid)
+	write("srv/upload.sh", "", 0o777)
+	if err := os.MkdirAll(filepath.Join(root, "srv/drop"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(root, "srv/drop"), 0o777); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "srv/shared"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Chmod(filepath.Join(root, "srv/shared"), 0o777|os.ModeSticky); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+	want := []string{
+		"setgid_file_new:usr/bin/ls",
+		"setuid_file_modified:usr/bin/passwd",
+		"world_writable_dir_new:srv/drop",
+		"world_writable_file_new:srv/upload.sh",
+	}
+	orphan := write("srv/orphan", "", 0o644)
+	if err := os.Chown(orphan, 54321, 54321); err == nil {
+		want = append(want, "unowned_file_new:srv/orphan")
+		sort.Strings(want)
+	}
+	got := strings.Join(run("second"), "\n")
+	if got != strings.Join(want, "\n") {
+		t.Fatalf("second run:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+	if got := run("third"); len(got) != 0 {
+		t.Fatalf("third run: unexpected findings %v", got)
+	}
+}
+
+func TestFilePermissionsDefaultExclude(t *testing.T) {
+	plugin := &FilePermissions{}
+	if err := plugin.Init(map[string]interface{}{}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	for path, want := range map[string]bool{
+		"/proc":               true,
+		"/var/lib/docker":     true,
+		"/tmp":                false,
+		"/var/tmp":            false,
+		"/usr/local/bin/tool": false,
+	} {
+		if got := plugin.exclude.Match(path); got != want {
+			t.Fatalf("exclude %s: got %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+var errStopIteration = errors.New("stop iteration")
+
+// Stream watches the configured paths and re-checks touched files against the
+// stored baseline once events settle for the debounce interval.
+func (f *FileIntegrity) Stream(ctx context.Context, emit func(scanner.Result)) error {
+	f.mu.Lock()
+	enabled := f.realtime
+	backend := f.realtimeBackend
+	debounce := f.debounce
+	roots := append([]string{}, f.paths...)
+	f.mu.Unlock()
+
+	if !enabled {
+		return nil
+	}
+
+	events := make(chan string, 1024)
+	errs := make(chan error, 2)
+	closers, err := startPathWatchers(backend, roots, events, errs)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}()
+
+	pending := map[string]struct{}{}
+	maxWait := 10 * debounce
+	var (
+		firstEvent time.Time
+		timer      *time.Timer
+		timerC     <-chan time.Time
+	)
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer, timerC = nil, nil
+		paths := make([]string, 0, len(pending))
+		for path := range pending {
+			paths = append(paths, path)
+		}
+		pending = map[string]struct{}{}
+		if result := f.checkPaths(paths); result != nil {
+			emit(*result)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case path := <-events:
+			if !underAny(path, roots) {
+				continue
+			}
+			if len(pending) == 0 {
+				firstEvent = time.Now()
+			}
+			pending[path] = struct{}{}
+			if time.Since(firstEvent) >= maxWait {
+				flush()
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(debounce)
+				timerC = timer.C
+			} else {
+				timer.Reset(debounce)
+			}
+		case <-timerC:
+			timer, timerC = nil, nil
+			flush()
+		}
+	}
+}
+
+func (f *FileIntegrity) checkPaths(paths []string) *scanner.Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.store == nil || len(paths) == 0 || !f.hasBaseline() {
+		return nil
+	}
+	start := time.Now()
+	sort.Strings(paths)
+
+	baseline := make(map[string]fileRecord)
+	current := make(map[string]fileRecord)
+	failed := []string{}
+	for _, path := range paths {
+		if !f.selected(path) {
+			continue
+		}
+		var rec fileRecord
+		if ok, err := loadJSON(f.store, fileBaselineBucket, path, &rec); err == nil && ok {
+			baseline[path] = rec
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				failed = append(failed, path)
+			}
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+		if rec, err = f.buildRecord(path, info, nil, nil, nil); err != nil {
+			failed = append(failed, path)
+			continue
+		}
+		current[path] = rec
+	}
+
+	findings := diffFileRecords(baseline, current, failed)
+	if len(findings) == 0 {
+		return nil
+	}
+	finished := time.Now()
+	return &scanner.Result{
+		ScannerName: f.Name(),
+		Status:      scanner.StatusSuccess,
+		Findings:    findings,
+		StartedAt:   start,
+		FinishedAt:  finished,
+		Duration:    finished.Sub(start),
+		Metadata: map[string]interface{}{
+			"source":    "realtime",
+			"paths":     paths,
+			"timestamp": finished.Format(time.RFC3339),
+		},
+	}
+}
+
+func (f *FileIntegrity) hasBaseline() bool {
+	found := false
+	_ = f.store.ForEach(fileBaselineBucket, func(_, _ []byte) error {
+		found = true
+		return errStopIteration
+	})
+	return found
+}
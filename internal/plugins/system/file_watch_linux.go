@@ -0,0 +1,257 @@
+//go:build linux
+
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// startPathWatchers starts the requested backends. "auto" always runs inotify
+// and adds fanotify mount marks when the process is permitted to use them, so
+// content writes are still seen beyond the inotify watch limit.
+func startPathWatchers(backend string, roots []string, events chan<- string, errs chan<- error) ([]io.Closer, error) {
+	closers := []io.Closer{}
+	closeAll := func() {
+		for _, c := range closers {
+			_ = c.Close()
+		}
+	}
+
+	if backend == "fanotify" || backend == "auto" {
+		fw, err := newFanotifyWatcher(roots, events, errs)
+		if err != nil {
+			if backend == "fanotify" {
+				return nil, err
+			}
+		} else {
+			closers = append(closers, fw)
+		}
+	}
+	if backend == "inotify" || backend == "auto" {
+		iw, err := newInotifyWatcher(roots, events, errs)
+		if err != nil {
+			if backend == "auto" && len(closers) > 0 {
+				return closers, nil
+			}
+			closeAll()
+			return nil, err
+		}
+		closers = append(closers, iw)
+	}
+	return closers, nil
+}
+
+type inotifyWatcher struct {
+	file    *os.File
+	fd      int
+	events  chan<- string
+	done    chan struct{}
+	mu      sync.Mutex
+	watches map[int32]string
+}
+
+func newInotifyWatcher(roots []string, events chan<- string, errs chan<- error) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	w := &inotifyWatcher{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		events:  events,
+		done:    make(chan struct{}),
+		watches: map[int32]string{},
+	}
+	for _, root := range roots {
+		if err := w.addTree(root, false); err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+	}
+	go w.readLoop(errs)
+	return w, nil
+}
+
+func (w *inotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+		close(w.done)
+	}
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) addTree(root string, notify bool) error {
+	return filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if path == root {
+				return w.addWatch(path)
+			}
+			if notify {
+				w.send(path)
+			}
+			return nil
+		}
+		return w.addWatch(path)
+	})
+}
+
+func (w *inotifyWatcher) addWatch(path string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			return fmt.Errorf("inotify watch limit reached at %s", path)
+		}
+		return nil
+	}
+	w.mu.Lock()
+	w.watches[int32(wd)] = path
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *inotifyWatcher) send(path string) {
+	select {
+	case w.events <- path:
+	case <-w.done:
+	}
+}
+
+func (w *inotifyWatcher) readLoop(errs chan<- error) {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				errs <- fmt.Errorf("inotify read: %w", err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(event.Len)
+			offset = nameEnd
+			if nameEnd > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+
+			w.mu.Lock()
+			dir, ok := w.watches[event.Wd]
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.watches, event.Wd)
+			}
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			path := dir
+			if name != "" {
+				path = filepath.Join(dir, name)
+			}
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					_ = w.addTree(path, true)
+				}
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				continue
+			}
+			w.send(path)
+		}
+	}
+}
+
+type fanotifyWatcher struct {
+	file   *os.File
+	events chan<- string
+	done   chan struct{}
+}
+
+func newFanotifyWatcher(roots []string, events chan<- string, errs chan<- error) (*fanotifyWatcher, error) {
+	fd, err := unix.FanotifyInit(unix.FAN_CLASS_NOTIF|unix.FAN_CLOEXEC|unix.FAN_NONBLOCK, unix.O_RDONLY|unix.O_LARGEFILE|unix.O_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("fanotify init: %w", err)
+	}
+	for _, root := range roots {
+		if err := unix.FanotifyMark(fd, unix.FAN_MARK_ADD|unix.FAN_MARK_MOUNT, unix.FAN_CLOSE_WRITE|unix.FAN_MODIFY, unix.AT_FDCWD, root); err != nil {
+			_ = unix.Close(fd)
+			return nil, fmt.Errorf("fanotify mark %s: %w", root, err)
+		}
+	}
+	w := &fanotifyWatcher{
+		file:   os.NewFile(uintptr(fd), "fanotify"),
+		events: events,
+		done:   make(chan struct{}),
+	}
+	go w.readLoop(errs)
+	return w, nil
+}
+
+func (w *fanotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+		close(w.done)
+	}
+	return w.file.Close()
+}
+
+func (w *fanotifyWatcher) readLoop(errs chan<- error) {
+	buf := make([]byte, 4096)
+	metaSize := int(unsafe.Sizeof(unix.FanotifyEventMetadata{}))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				errs <- fmt.Errorf("fanotify read: %w", err)
+			}
+			return
+		}
+		for offset := 0; offset+metaSize <= n; {
+			meta := (*unix.FanotifyEventMetadata)(unsafe.Pointer(&buf[offset]))
+			if int(meta.Event_len) < metaSize {
+				break
+			}
+			offset += int(meta.Event_len)
+			if meta.Fd < 0 {
+				continue
+			}
+			path, err := os.Readlink(filepath.Join("/proc/self/fd", strconv.Itoa(int(meta.Fd))))
+			_ = unix.Close(int(meta.Fd))
+			if err != nil {
+				continue
+			}
+			select {
+			case w.events <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestFileIntegrityStreamReportsModification(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	path := filepath.Join(dir, "watched.txt")
+	if err := os.WriteFile(path, []byte("original"), 0o600); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	fi := &FileIntegrity{}
+	fi.WithStore(store)
+	if err := fi.Init(map[string]interface{}{
+		"paths":            []interface{}{dir},
+		"realtime":         true,
+		"realtime_backend": "inotify",
+		"debounce_ms":      float64(50),
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	if _, err := fi.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make(chan scanner.Result, 4)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fi.Stream(ctx, func(result scanner.Result) { results <- result })
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(path, []byte("tampered"), 0o600); err != nil {
+		t.Fatalf("modify: %v", err)
+	}
+
+	select {
+	case result := <-results:
+		if len(result.Findings) != 1 || result.Findings[0].ID != "file_modified" {
+			t.Fatalf("expected file_modified finding, got %+v", result.Findings)
+		}
+	case err := <-errCh:
+		t.Fatalf("stream exited: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for realtime finding")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package system
+
+import (
+	"fmt"
+	"io"
+)
+
+func startPathWatchers(_ string, _ []string, _ chan<- string, _ chan<- error) ([]io.Closer, error) {
+	return nil, fmt.Errorf("realtime file integrity requires linux")
+}
@@ -0,0 +1,81 @@
+package system
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type globPattern struct {
+	re       *regexp.Regexp
+	fullPath bool
+}
+
+type globSet []globPattern
+
+// compileGlobs compiles shell-style patterns. "*" and "?" stop at "/", "**"
+// spans directories, and patterns without a "/" match the base name only.
+func compileGlobs(patterns []string) (globSet, error) {
+	out := make(globSet, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+		out = append(out, globPattern{re: re, fullPath: strings.Contains(pattern, "/")})
+	}
+	return out, nil
+}
+
+func (g globSet) Match(path string) bool {
+	base := filepath.Base(path)
+	for _, p := range g {
+		if p.fullPath && p.re.MatchString(path) {
+			return true
+		}
+		if !p.fullPath && p.re.MatchString(base) {
+			return true
+		}
+	}
+	return false
+}
+
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+				continue
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
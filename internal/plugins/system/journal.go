@@ -0,0 +1,347 @@
+package system
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const journalCursorBucket = "journal_cursors"
+
+// maxJournalFieldSize bounds binary export fields; journald itself caps
+// entries well below this, so anything larger is a corrupt stream.
+const maxJournalFieldSize = 64 << 20
+
+// journalEntry holds the fields of one journal record as exported by
+// `journalctl -o export` or `-o json`.
+type journalEntry map[string]string
+
+func (e journalEntry) Identifier() string {
+	if v := e["SYSLOG_IDENTIFIER"]; v != "" {
+		return v
+	}
+	return e["_COMM"]
+}
+
+func (e journalEntry) PID() int {
+	raw := e["SYSLOG_PID"]
+	if raw == "" {
+		raw = e["_PID"]
+	}
+	pid, _ := strconv.Atoi(raw)
+	return pid
+}
+
+func (e journalEntry) Time() time.Time {
+	usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMicro(usec)
+}
+
+// Line renders the entry the way syslog would, without the timestamp.
+func (e journalEntry) Line() string {
+	if pid := e.PID(); pid > 0 {
+		return fmt.Sprintf("%s[%d]: %s", e.Identifier(), pid, e["MESSAGE"])
+	}
+	return fmt.Sprintf("%s: %s", e.Identifier(), e["MESSAGE"])
+}
+
+func (e journalEntry) evidence() map[string]interface{} {
+	evidence := map[string]interface{}{}
+	for field, key := range map[string]string{
+		"_SYSTEMD_UNIT":     "systemd_unit",
+		"SYSLOG_IDENTIFIER": "syslog_identifier",
+		"_HOSTNAME":         "hostname",
+		"__CURSOR":          "journal_cursor",
+	} {
+		if v := e[field]; v != "" {
+			evidence[key] = v
+		}
+	}
+	if pid, err := strconv.Atoi(e["_PID"]); err == nil {
+		evidence["pid"] = pid
+	}
+	return evidence
+}
+
+// journalSource reads either a saved export/json stream (Path) or the live
+// journal through journalctl. Matches use journalctl syntax (FIELD=value);
+// repeated fields are alternatives, different fields must all match.
+type journalSource struct {
+	Path      string
+	Directory string
+	Matches   []string
+	Command   string
+}
+
+type journalCursor struct {
+	Cursor   string `json:"cursor"`
+	Realtime string `json:"realtime"`
+}
+
+func parseJournalSource(config map[string]interface{}, defaultMatches []string) journalSource {
+	source := journalSource{Command: "journalctl", Matches: defaultMatches}
+	if v, ok := config["journal_path"].(string); ok && v != "" {
+		source.Path = v
+	}
+	if v, ok := config["journal_dir"].(string); ok && v != "" {
+		source.Directory = v
+	}
+	if v, ok := config["journalctl"].(string); ok && v != "" {
+		source.Command = v
+	}
+	if v, ok := configStrings(config, "journal_matches"); ok {
+		source.Matches = v
+	}
+	return source
+}
+
+func (s journalSource) key() string {
+	if s.Path != "" {
+		return s.Path
+	}
+	return "journalctl:" + s.Directory + ":" + strings.Join(s.Matches, ",")
+}
+
+func (s journalSource) match(entry journalEntry) bool {
+	wanted := map[string]bool{}
+	matched := map[string]bool{}
+	for _, m := range s.Matches {
+		field, value, _ := strings.Cut(m, "=")
+		wanted[field] = true
+		if entry[field] == value {
+			matched[field] = true
+		}
+	}
+	return len(wanted) == len(matched)
+}
+
+// tailJournal calls fn for each entry after the stored cursor and returns the
+// number of entries read. The first time a source is seen only the last
+// backfill entries are read. journalctl resumes with --after-cursor; a saved
+// file is re-read and entries up to the stored cursor or its timestamp are
+// skipped.
+func tailJournal(ctx context.Context, store storage.Store, source journalSource, backfill int, fn func(journalEntry)) (int, error) {
+	var cursor journalCursor
+	known, err := loadJSON(store, journalCursorBucket, source.key(), &cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	last := cursor
+	emit := func(entry journalEntry) {
+		count++
+		last = journalCursor{Cursor: entry["__CURSOR"], Realtime: entry["__REALTIME_TIMESTAMP"]}
+		fn(entry)
+	}
+
+	var readErr error
+	if source.Path != "" {
+		file, err := os.Open(source.Path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		pending := []journalEntry{}
+		passed := false
+		readErr = readJournal(file, func(entry journalEntry) {
+			if !source.match(entry) {
+				return
+			}
+			if !known {
+				pending = append(pending, entry)
+				if len(pending) > backfill {
+					pending = pending[1:]
+				}
+				return
+			}
+			if !passed {
+				if entry["__CURSOR"] == cursor.Cursor {
+					passed = true
+					return
+				}
+				if realtimeNotAfter(entry["__REALTIME_TIMESTAMP"], cursor.Realtime) {
+					return
+				}
+				passed = true
+			}
+			emit(entry)
+		})
+		if readErr == nil {
+			for _, entry := range pending {
+				emit(entry)
+			}
+		}
+	} else {
+		args := []string{"--no-pager", "-o", "export"}
+		if source.Directory != "" {
+			args = append(args, "-D", source.Directory)
+		}
+		if known && cursor.Cursor != "" {
+			args = append(args, "--after-cursor", cursor.Cursor)
+		} else {
+			args = append(args, "-n", strconv.Itoa(backfill))
+		}
+		args = append(args, source.Matches...)
+		cmd := exec.CommandContext(ctx, source.Command, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return 0, err
+		}
+		if err := cmd.Start(); err != nil {
+			return 0, err
+		}
+		readErr = readJournal(stdout, emit)
+		if err := cmd.Wait(); err != nil {
+			readErr = fmt.Errorf("%s: %w: %s", source.Command, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	// Entries already passed to fn are not read again, even when the read
+	// failed part way through.
+	if store != nil && last.Cursor != "" {
+		if err := saveJSON(store, journalCursorBucket, source.key(), last); err != nil && readErr == nil {
+			return count, fmt.Errorf("save journal cursor: %w", err)
+		}
+	}
+	return count, readErr
+}
+
+func realtimeNotAfter(a, b string) bool {
+	x, errA := strconv.ParseInt(a, 10, 64)
+	y, errB := strconv.ParseInt(b, 10, 64)
+	return errA == nil && errB == nil && x <= y
+}
+
+// readJournal decodes a journal export or JSON stream, detected from the
+// first byte.
+func readJournal(r io.Reader, fn func(journalEntry)) error {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		b, err := reader.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] == '\n' || b[0] == ' ' || b[0] == '\r' || b[0] == '\t' {
+			_, _ = reader.ReadByte()
+			continue
+		}
+		if b[0] == '{' {
+			return readJournalJSON(reader, fn)
+		}
+		return readJournalExport(reader, fn)
+	}
+}
+
+// readJournalExport parses the journal export format: KEY=value lines, or a
+// KEY line followed by a little-endian uint64 length and binary data, with
+// entries separated by an empty line.
+func readJournalExport(reader *bufio.Reader, fn func(journalEntry)) error {
+	entry := journalEntry{}
+	flush := func() {
+		if len(entry) > 0 {
+			fn(entry)
+			entry = journalEntry{}
+		}
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF && line == "" {
+			flush()
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			flush()
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			entry[key] = value
+			continue
+		}
+		var size uint64
+		if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			return fmt.Errorf("journal field %s: %w", line, err)
+		}
+		if size > maxJournalFieldSize {
+			return fmt.Errorf("journal field %s: size %d exceeds %d bytes", line, size, maxJournalFieldSize)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("journal field %s: %w", line, err)
+		}
+		// The value is followed by a newline.
+		if _, err := reader.ReadByte(); err != nil {
+			return fmt.Errorf("journal field %s: %w", line, err)
+		}
+		entry[line] = string(data)
+	}
+}
+
+// readJournalJSON parses `journalctl -o json` output. Binary values are
+// arrays of byte values; fields with several values keep the first.
+func readJournalJSON(reader *bufio.Reader, fn func(journalEntry)) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		raw := map[string]interface{}{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("decode journal json: %w", err)
+		}
+		entry := journalEntry{}
+		for key, value := range raw {
+			if s, ok := journalJSONValue(value); ok {
+				entry[key] = s
+			}
+		}
+		fn(entry)
+	}
+}
+
+func journalJSONValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case []interface{}:
+		if len(v) == 0 {
+			return "", false
+		}
+		if _, ok := v[0].(float64); ok {
+			data := make([]byte, 0, len(v))
+			for _, b := range v {
+				n, _ := b.(float64)
+				data = append(data, byte(n))
+			}
+			return string(data), true
+		}
+		return journalJSONValue(v[0])
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,420 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const kernelBucket = "kernel_state"
+
+// Kernel checks loaded modules, sysctl values and the boot command line.
+type Kernel struct {
+	procRoot    string
+	modulesRoot string
+	release     string
+	expect      map[string]string
+	track       []string
+	store       storage.Store
+}
+
+type kernelModule struct {
+	Name  string
+	Size  int64
+	Deps  []string
+	State string
+	Taint string
+}
+
+type kernelSnapshot struct {
+	Modules []string          `json:"modules"`
+	Sysctl  map[string]string `json:"sysctl"`
+	Cmdline string            `json:"cmdline"`
+}
+
+var defaultSysctlExpectations = map[string]string{
+	"kernel.kptr_restrict":                  ">=1",
+	"kernel.dmesg_restrict":                 "1",
+	"kernel.yama.ptrace_scope":              ">=1",
+	"kernel.randomize_va_space":             "2",
+	"kernel.unprivileged_bpf_disabled":      ">=1",
+	"net.ipv4.tcp_syncookies":               "1",
+	"net.ipv4.conf.all.accept_redirects":    "0",
+	"net.ipv4.conf.all.send_redirects":      "0",
+	"net.ipv4.conf.all.accept_source_route": "0",
+	"net.ipv6.conf.all.accept_redirects":    "0",
+	"fs.protected_symlinks":                 "1",
+	"fs.protected_hardlinks":                "1",
+	"fs.suid_dumpable":                      "0",
+}
+
+// knownInits are the init programs distributions boot; init= pointing at
+// one of them is not reported.
+var knownInits = map[string]bool{
+	"/sbin/init":               true,
+	"/usr/sbin/init":           true,
+	"/bin/init":                true,
+	"/usr/bin/init":            true,
+	"/lib/systemd/systemd":     true,
+	"/usr/lib/systemd/systemd": true,
+	"/bin/systemd":             true,
+	"/usr/bin/systemd":         true,
+	"/sbin/openrc-init":        true,
+	"/sbin/runit-init":         true,
+	"/bin/busybox":             true,
+}
+
+// riskyCmdlineParams are boot parameters that disable a protection or hand
+// control to an arbitrary program.
+var riskyCmdlineParams = map[string]scanner.Severity{
+	"init":                 scanner.SeverityHigh,
+	"rd.break":             scanner.SeverityHigh,
+	"selinux=0":            scanner.SeverityMedium,
+	"enforcing=0":          scanner.SeverityMedium,
+	"apparmor=0":           scanner.SeverityMedium,
+	"security=none":        scanner.SeverityMedium,
+	"module.sig_enforce=0": scanner.SeverityMedium,
+	"lockdown=none":        scanner.SeverityMedium,
+	"nokaslr":              scanner.SeverityMedium,
+	"mitigations=off":      scanner.SeverityMedium,
+}
+
+func (k *Kernel) Name() string { return "system.kernel" }
+
+func (k *Kernel) WithStore(store storage.Store) {
+	k.store = store
+}
+
+func (k *Kernel) Init(config map[string]interface{}) error {
+	k.procRoot = "/proc"
+	k.modulesRoot = "/lib/modules"
+	k.release = ""
+	if v, ok := config["proc_root"].(string); ok && v != "" {
+		k.procRoot = v
+	}
+	if v, ok := config["modules_root"].(string); ok && v != "" {
+		k.modulesRoot = v
+	}
+	if v, ok := config["release"].(string); ok && v != "" {
+		k.release = v
+	}
+	k.expect = map[string]string{}
+	for key, value := range defaultSysctlExpectations {
+		k.expect[key] = value
+	}
+	if v, ok := config["sysctl"].(map[string]interface{}); ok {
+		for key, raw := range v {
+			switch value := raw.(type) {
+			case string:
+				if value == "" {
+					delete(k.expect, key)
+				} else {
+					k.expect[key] = value
+				}
+			case float64:
+				k.expect[key] = strconv.FormatFloat(value, 'f', -1, 64)
+			case nil:
+				delete(k.expect, key)
+			default:
+				return fmt.Errorf("sysctl: invalid expectation for %s", key)
+			}
+		}
+	}
+	k.track = []string{"kernel.tainted", "kernel.modules_disabled"}
+	if v, ok := configStrings(config, "track_sysctl"); ok {
+		k.track = v
+	}
+	return nil
+}
+
+func (k *Kernel) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: k.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	snap, modules, err := k.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	release := k.kernelRelease()
+	result.Metadata["release"] = release
+	result.Metadata["modules_loaded"] = len(modules)
+
+	var available map[string]bool
+	if release != "" {
+		if available, err = moduleIndex(ctx, filepath.Join(k.modulesRoot, release)); err != nil {
+			return nil, err
+		}
+	}
+	if available == nil {
+		result.Metadata["modules_dir_missing"] = true
+	}
+	for _, mod := range modules {
+		if available != nil && !available[mod.Name] {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "kernel_module_unknown",
+				Severity:    scanner.SeverityHigh,
+				Category:    "kernel",
+				Description: fmt.Sprintf("Loaded module %s has no file under %s", mod.Name, filepath.Join(k.modulesRoot, release)),
+				Evidence:    moduleEvidence(mod),
+				Remediation: "Identify where the module was loaded from; an unlisted module can be a rootkit.",
+			})
+		}
+	}
+
+	for _, key := range sortedKeys(k.expect) {
+		value, ok := snap.Sysctl[key]
+		if !ok || sysctlMatches(value, k.expect[key]) {
+			continue
+		}
+		result.Findings = append(result.Findings, scanner.Finding{
+			ID:          "sysctl_unexpected",
+			Severity:    scanner.SeverityMedium,
+			Category:    "kernel",
+			Description: fmt.Sprintf("%s is %s, expected %s", key, value, k.expect[key]),
+			Evidence:    map[string]interface{}{"key": key, "value": value, "expected": k.expect[key]},
+			Remediation: fmt.Sprintf("Set %s in /etc/sysctl.d and apply it with sysctl --system.", key),
+		})
+	}
+
+	for _, param := range strings.Fields(snap.Cmdline) {
+		name, value, _ := strings.Cut(param, "=")
+		if name == "init" && knownInits[value] {
+			continue
+		}
+		severity, ok := riskyCmdlineParams[param]
+		if !ok {
+			severity, ok = riskyCmdlineParams[name]
+		}
+		if !ok {
+			continue
+		}
+		result.Findings = append(result.Findings, scanner.Finding{
+			ID:          "kernel_cmdline_risky",
+			Severity:    severity,
+			Category:    "kernel",
+			Description: fmt.Sprintf("Kernel booted with %s", param),
+			Evidence:    map[string]interface{}{"parameter": param, "cmdline": snap.Cmdline},
+			Remediation: "Remove the parameter from the boot loader configuration and reboot.",
+		})
+	}
+
+	if k.store != nil {
+		var previous kernelSnapshot
+		ok, err := loadJSON(k.store, kernelBucket, "snapshot", &previous)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result.Findings = append(result.Findings, kernelDiff(previous, snap, modules)...)
+		} else {
+			result.Metadata["baseline_created"] = true
+		}
+		if err := saveJSON(k.store, kernelBucket, "snapshot", snap); err != nil {
+			return nil, fmt.Errorf("save kernel snapshot: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (k *Kernel) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline records the loaded modules, tracked sysctls and command line
+// as the state later runs are compared against.
+func (k *Kernel) AcceptBaseline(_ context.Context) error {
+	if k.store == nil {
+		return fmt.Errorf("kernel baseline requires storage")
+	}
+	snap, _, err := k.snapshot()
+	if err != nil {
+		return err
+	}
+	return saveJSON(k.store, kernelBucket, "snapshot", snap)
+}
+
+func (k *Kernel) kernelRelease() string {
+	if k.release != "" {
+		return k.release
+	}
+	raw, _ := os.ReadFile(filepath.Join(k.procRoot, "sys/kernel/osrelease"))
+	return strings.TrimSpace(string(raw))
+}
+
+func (k *Kernel) snapshot() (kernelSnapshot, []kernelModule, error) {
+	snap := kernelSnapshot{Modules: []string{}, Sysctl: map[string]string{}}
+	modules, err := readModules(filepath.Join(k.procRoot, "modules"))
+	if err != nil && !os.IsNotExist(err) {
+		return snap, nil, fmt.Errorf("read modules: %w", err)
+	}
+	for _, mod := range modules {
+		snap.Modules = append(snap.Modules, mod.Name)
+	}
+	keys := append(sortedKeys(k.expect), k.track...)
+	for _, key := range keys {
+		raw, err := os.ReadFile(filepath.Join(k.procRoot, "sys", strings.ReplaceAll(key, ".", "/")))
+		if err != nil {
+			continue
+		}
+		snap.Sysctl[key] = strings.Join(strings.Fields(string(raw)), " ")
+	}
+	cmdline, err := os.ReadFile(filepath.Join(k.procRoot, "cmdline"))
+	if err != nil && !os.IsNotExist(err) {
+		return snap, nil, fmt.Errorf("read cmdline: %w", err)
+	}
+	snap.Cmdline = strings.TrimSpace(string(cmdline))
+	return snap, modules, nil
+}
+
+func kernelDiff(previous, current kernelSnapshot, modules []kernelModule) []scanner.Finding {
+	findings := []scanner.Finding{}
+	known := map[string]bool{}
+	for _, name := range previous.Modules {
+		known[name] = true
+	}
+	for _, mod := range modules {
+		if known[mod.Name] {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "kernel_module_loaded",
+			Severity:    scanner.SeverityHigh,
+			Category:    "kernel",
+			Description: fmt.Sprintf("Kernel module %s was loaded since the last run", mod.Name),
+			Evidence:    moduleEvidence(mod),
+			Remediation: "Confirm the module was loaded deliberately (modinfo, dmesg) and unload it if not.",
+		})
+	}
+	for _, key := range sortedKeys(current.Sysctl) {
+		before, ok := previous.Sysctl[key]
+		if !ok || before == current.Sysctl[key] {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "sysctl_changed",
+			Severity:    scanner.SeverityMedium,
+			Category:    "kernel",
+			Description: fmt.Sprintf("%s changed from %s to %s", key, before, current.Sysctl[key]),
+			Evidence:    map[string]interface{}{"key": key, "value": current.Sysctl[key], "previous": before},
+			Remediation: "Confirm who changed the setting; restore it with sysctl --system if it was not intended.",
+		})
+	}
+	if previous.Cmdline != "" && previous.Cmdline != current.Cmdline {
+		findings = append(findings, scanner.Finding{
+			ID:          "kernel_cmdline_changed",
+			Severity:    scanner.SeverityMedium,
+			Category:    "kernel",
+			Description: "Kernel command line changed since the last run",
+			Evidence:    map[string]interface{}{"cmdline": current.Cmdline, "previous": previous.Cmdline},
+			Remediation: "Confirm the reboot and boot loader change were intended.",
+		})
+	}
+	return findings
+}
+
+// readModules parses /proc/modules: name, size, refcount, dependencies,
+// state, address and optional taint flags in parentheses.
+func readModules(path string) ([]kernelModule, error) {
+	modules := []kernelModule{}
+	err := readLines(path, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		mod := kernelModule{Name: fields[0], Size: size, State: fields[4], Deps: []string{}}
+		for _, dep := range strings.Split(fields[3], ",") {
+			if dep != "" && dep != "-" {
+				mod.Deps = append(mod.Deps, dep)
+			}
+		}
+		if len(fields) > 6 {
+			mod.Taint = strings.Trim(fields[6], "()")
+		}
+		modules = append(modules, mod)
+	})
+	return modules, err
+}
+
+// moduleIndex lists module names available under dir, normalised the way the
+// kernel reports them ("-" becomes "_"). It returns nil when dir is missing.
+func moduleIndex(ctx context.Context, dir string) (map[string]bool, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, nil
+	}
+	index := map[string]bool{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := entry.Name()
+		for _, suffix := range []string{".ko", ".ko.xz", ".ko.zst", ".ko.gz"} {
+			if strings.HasSuffix(name, suffix) {
+				index[strings.ReplaceAll(strings.TrimSuffix(name, suffix), "-", "_")] = true
+				break
+			}
+		}
+		return nil
+	})
+	return index, err
+}
+
+// sysctlMatches compares a value against an expectation: a plain value,
+// alternatives separated by "|", or a numeric comparison (>=, <=, >, <, !=).
+func sysctlMatches(value, expect string) bool {
+	for _, op := range []string{">=", "<=", "!=", ">", "<"} {
+		if !strings.HasPrefix(expect, op) {
+			continue
+		}
+		want, errWant := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(expect, op)), 10, 64)
+		got, errGot := strconv.ParseInt(value, 10, 64)
+		if errWant != nil || errGot != nil {
+			return op == "!=" && value != strings.TrimSpace(strings.TrimPrefix(expect, op))
+		}
+		switch op {
+		case ">=":
+			return got >= want
+		case "<=":
+			return got <= want
+		case "!=":
+			return got != want
+		case ">":
+			return got > want
+		default:
+			return got < want
+		}
+	}
+	for _, alt := range strings.Split(expect, "|") {
+		if strings.Join(strings.Fields(alt), " ") == value {
+			return true
+		}
+	}
+	return false
+}
+
+func moduleEvidence(mod kernelModule) map[string]interface{} {
+	evidence := map[string]interface{}{
+		"module":  mod.Name,
+		"size":    mod.Size,
+		"state":   mod.State,
+		"used_by": mod.Deps,
+	}
+	if mod.Taint != "" {
+		evidence["taint"] = mod.Taint
+	}
+	return evidence
+}
@@ -0,0 +1,107 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestKernel(t *testing.T) {
+	dir := t.TempDir()
+	proc := filepath.Join(dir, "proc")
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("proc/sys/kernel/osrelease", "6.1.0-test\n")
+	write("proc/sys/kernel/kptr_restrict", "1\n")
+	write("proc/sys/kernel/tainted", "0\n")
+	write("proc/sys/net/ipv4/ip_forward", "1\n")
+	write("proc/cmdline", "BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro init=/lib/systemd/systemd\n")
+	write("proc/modules", "ext4 1003520 1 - Live 0x0000000000000000\nnf_tables 327680 0 - Live 0x0000000000000000\n")
+	write("modules/6.1.0-test/kernel/fs/ext4/ext4.ko.xz", "")
+	write("modules/6.1.0-test/kernel/net/netfilter/nf_tables.ko", "")
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &Kernel{}
+	plugin.WithStore(store)
+	config := map[string]interface{}{
+		"proc_root":    proc,
+		"modules_root": filepath.Join(dir, "modules"),
+		"sysctl":       map[string]interface{}{"kernel.kptr_restrict": "2|1", "net.ipv4.ip_forward": "0"},
+	}
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) []string {
+		t.Helper()
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		ids := []string{}
+		for _, finding := range result.Findings {
+			ids = append(ids, finding.ID+":"+finding.Description)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	got := strings.Join(run("first"), "\n")
+	if got != "sysctl_unexpected:net.ipv4.ip_forward is 1, expected 0" {
+		t.Fatalf("first run: %s", got)
+	}
+
+	write("proc/modules", "ext4 1003520 1 - Live 0x0000000000000000\nnf_tables 327680 0 - Live 0x0000000000000000\ndiamorphine 16384 0 - Live 0x0000000000000000 (OE)\n")
+	write("proc/sys/kernel/kptr_restrict", "0\n")
+	write("proc/sys/kernel/tainted", "12288\n")
+	write("proc/cmdline", "BOOT_IMAGE=/vmlinuz root=/dev/sda1 ro init=/bin/bash\n")
+	got = strings.Join(run("drift"), "\n")
+	want := strings.Join([]string{
+		"kernel_cmdline_changed:Kernel command line changed since the last run",
+		"kernel_cmdline_risky:Kernel booted with init=/bin/bash",
+		"kernel_module_loaded:Kernel module diamorphine was loaded since the last run",
+		"kernel_module_unknown:Loaded module diamorphine has no file under " + filepath.Join(dir, "modules", "6.1.0-test"),
+		"sysctl_changed:kernel.kptr_restrict changed from 1 to 0",
+		"sysctl_changed:kernel.tainted changed from 0 to 12288",
+		"sysctl_unexpected:kernel.kptr_restrict is 0, expected 2|1",
+		"sysctl_unexpected:net.ipv4.ip_forward is 1, expected 0",
+	}, "\n")
+	if got != want {
+		t.Fatalf("drift run:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSysctlMatches(t *testing.T) {
+	cases := []struct {
+		value, expect string
+		want          bool
+	}{
+		{"1", "1", true},
+		{"2", ">=1", true},
+		{"0", ">=1", false},
+		{"1", "0|2", false},
+		{"32768 60999", "32768 60999", true},
+		{"3", "!=0", true},
+	}
+	for _, tc := range cases {
+		if got := sysctlMatches(tc.value, tc.expect); got != tc.want {
+			t.Fatalf("sysctlMatches(%q, %q) = %v", tc.value, tc.expect, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package system
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	logCursorBucket  = "log_cursors"
+	fingerprintBytes = 512
+)
+
+// logCursor records how far a log file has been read. The fingerprint is a
+// hash of the file's first bytes, used to recognise the same file after
+// logrotate renames or compresses it.
+type logCursor struct {
+	Inode          uint64 `json:"inode"`
+	Device         uint64 `json:"device"`
+	Offset         int64  `json:"offset"`
+	Fingerprint    string `json:"fingerprint"`
+	FingerprintLen int    `json:"fingerprint_len"`
+}
+
+type tailStats struct {
+	Lines     int
+	Rotated   bool
+	Truncated bool
+	Backfill  bool
+}
+
+// tailLog calls fn for every line appended to path since the stored cursor.
+// When the file was rotated (renamed to .1 or compressed to .1.gz) or
+// truncated in place, the unread tail of the previous file is read first. On
+// first sight, or without a store, only the last backfill lines are read.
+func tailLog(store storage.Store, path string, backfill int, fn func(line string)) (tailStats, error) {
+	stats := tailStats{}
+	emit := func(line string) {
+		stats.Lines++
+		fn(line)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return stats, err
+	}
+
+	var cursor logCursor
+	known, err := loadJSON(store, logCursorBucket, path, &cursor)
+	if err != nil {
+		return stats, err
+	}
+
+	var offset int64
+	if !known {
+		stats.Backfill = true
+		lines := []string{}
+		offset, err = scanLines(file, false, func(line string) {
+			lines = append(lines, line)
+			if len(lines) > backfill {
+				lines = lines[1:]
+			}
+		})
+		if err != nil {
+			return stats, err
+		}
+		for _, line := range lines {
+			emit(line)
+		}
+	} else {
+		inode, device, _, _ := fileIdentity(info)
+		sameFile := (cursor.Inode == 0 || (cursor.Inode == inode && cursor.Device == device)) && cursor.matches(file)
+		if !sameFile || info.Size() < cursor.Offset {
+			stats.Truncated = cursor.Inode != 0 && cursor.Inode == inode && cursor.Device == device
+			stats.Rotated = !stats.Truncated
+			if err := finishRotated(path, cursor, emit); err != nil {
+				return stats, err
+			}
+			cursor.Offset = 0
+		}
+		if _, err := file.Seek(cursor.Offset, io.SeekStart); err != nil {
+			return stats, err
+		}
+		read, err := scanLines(file, false, emit)
+		if err != nil {
+			return stats, err
+		}
+		offset = cursor.Offset + read
+	}
+
+	if store == nil {
+		return stats, nil
+	}
+	next, err := newLogCursor(file, info, offset)
+	if err != nil {
+		return stats, err
+	}
+	if err := saveJSON(store, logCursorBucket, path, next); err != nil {
+		return stats, fmt.Errorf("save log cursor: %w", err)
+	}
+	return stats, nil
+}
+
+func newLogCursor(file *os.File, info os.FileInfo, offset int64) (logCursor, error) {
+	inode, device, _, _ := fileIdentity(info)
+	cursor := logCursor{Inode: inode, Device: device, Offset: offset}
+	head, err := readHead(file, fingerprintBytes)
+	if err != nil {
+		return cursor, err
+	}
+	cursor.FingerprintLen = len(head)
+	cursor.Fingerprint = fingerprint(head)
+	return cursor, nil
+}
+
+func (c logCursor) matches(r io.ReaderAt) bool {
+	head := make([]byte, c.FingerprintLen)
+	n, _ := r.ReadAt(head, 0)
+	return n == c.FingerprintLen && fingerprint(head) == c.Fingerprint
+}
+
+// finishRotated reads whatever followed the cursor in the rotated copy of the
+// previous file, if one with a matching fingerprint can be found.
+func finishRotated(path string, cursor logCursor, emit func(string)) error {
+	for _, candidate := range []string{path + ".1", path + ".1.gz"} {
+		file, err := os.Open(candidate)
+		if err != nil {
+			continue
+		}
+		var reader io.Reader = file
+		if strings.HasSuffix(candidate, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				continue
+			}
+			reader = gz
+		}
+		buffered := bufio.NewReader(reader)
+		head, _ := buffered.Peek(cursor.FingerprintLen)
+		if len(head) != cursor.FingerprintLen || fingerprint(head) != cursor.Fingerprint {
+			file.Close()
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, buffered, cursor.Offset); err != nil {
+			file.Close()
+			return nil
+		}
+		_, err = scanLines(buffered, true, emit)
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+// scanLines calls fn for each newline-terminated line and returns the number
+// of bytes consumed. A trailing partial line is only emitted (and counted)
+// when final is set, so a line still being written is read on the next pass.
+func scanLines(r io.Reader, final bool, fn func(string)) (int64, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var consumed int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			consumed += int64(len(line))
+			fn(strings.TrimRight(line, "\r\n"))
+			continue
+		}
+		if err == io.EOF {
+			if final && line != "" {
+				consumed += int64(len(line))
+				fn(strings.TrimRight(line, "\r"))
+			}
+			return consumed, nil
+		}
+		return consumed, err
+	}
+}
+
+func readHead(r io.ReaderAt, n int) ([]byte, error) {
+	head := make([]byte, n)
+	read, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return head[:read], nil
+}
+
+func fingerprint(head []byte) string {
+	sum := sha256.Sum256(head)
+	return hex.EncodeToString(sum[:])
+}
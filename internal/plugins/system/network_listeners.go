@@ -2,30 +2,91 @@ package system
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
+const listenerBucket = "network_listeners"
+
 type NetworkListeners struct {
-	tcpPath string
-	udpPath string
+	tcpPath       string
+	udpPath       string
+	netRoot       string
+	procRoot      string
+	localhostOnly map[string]bool
+	allowedPorts  map[string]bool
+	skipEphemeral bool
+	forgetAfter   time.Duration
+	store         storage.Store
+}
+
+type listenerRecord struct {
+	Proto     string    `json:"proto"`
+	Address   string    `json:"address"`
+	Port      int       `json:"port"`
+	Processes []string  `json:"processes,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
 }
 
 func (n *NetworkListeners) Name() string { return "system.network_listeners" }
 
+func (n *NetworkListeners) WithStore(store storage.Store) {
+	n.store = store
+}
+
 func (n *NetworkListeners) Init(config map[string]interface{}) error {
-	n.tcpPath = "/proc/net/tcp"
-	n.udpPath = "/proc/net/udp"
+	n.tcpPath = ""
+	n.udpPath = ""
+	n.netRoot = "/proc/net"
+	n.procRoot = "/proc"
+	n.forgetAfter = 7 * 24 * time.Hour
 	if v, ok := config["tcp_path"].(string); ok && v != "" {
 		n.tcpPath = v
+		n.netRoot = filepath.Dir(v)
 	}
 	if v, ok := config["udp_path"].(string); ok && v != "" {
 		n.udpPath = v
 	}
+	if v, ok := config["net_root"].(string); ok && v != "" {
+		n.netRoot = v
+	}
+	if n.tcpPath == "" {
+		n.tcpPath = filepath.Join(n.netRoot, "tcp")
+	}
+	if n.udpPath == "" {
+		n.udpPath = filepath.Join(n.netRoot, "udp")
+	}
+	if v, ok := config["proc_root"].(string); ok && v != "" {
+		n.procRoot = v
+	}
+	if v, ok := config["forget_after"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("forget_after: %w", err)
+		}
+		n.forgetAfter = d
+	}
+	n.skipEphemeral = false
+	if v, ok := config["ignore_ephemeral_udp"].(bool); ok {
+		n.skipEphemeral = v
+	}
+	var err error
+	if n.localhostOnly, err = portSpecs(config, "localhost_only", []string{"2375", "6379", "9200", "11211", "27017"}); err != nil {
+		return err
+	}
+	if n.allowedPorts, err = portSpecs(config, "allowed_ports", nil); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -38,34 +99,211 @@ func (n *NetworkListeners) Run(_ context.Context) (*scanner.Result, error) {
 	if err != nil {
 		return nil, fmt.Errorf("read udp: %w", err)
 	}
+	sockets := append(parseSocketTable("tcp", string(tcp)), parseSocketTable("udp", string(udp))...)
+	for _, proto := range []string{"tcp6", "udp6", "raw", "raw6"} {
+		// IPv6 and raw tables are absent when the stack is disabled.
+		data, err := os.ReadFile(filepath.Join(n.netRoot, proto))
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, parseSocketTable(proto, string(data))...)
+	}
 
+	now := time.Now().UTC()
 	result := &scanner.Result{
 		ScannerName: n.Name(),
 		Status:      scanner.StatusSuccess,
 		Metadata: map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
-			"tcp_count": countListeners(string(tcp)),
-			"udp_count": countListeners(string(udp)),
+			"timestamp": now.Format(time.RFC3339),
 		},
 	}
+
+	owners := socketOwners(n.procRoot)
+	ephemeralLow, ephemeralHigh := ephemeralPortRange(n.procRoot)
+	counts := map[string]int{}
+	listeners := map[string]socketEntry{}
+	processes := map[string][]string{}
+	containers := map[string]containerInfo{}
+	for _, sock := range sockets {
+		if !sock.Listening() {
+			continue
+		}
+		// Unconnected UDP sockets on ephemeral ports are usually clients
+		// (resolvers, NTP), but a backdoor can bind there too, so they are
+		// only skipped on request.
+		if n.skipEphemeral && strings.HasPrefix(sock.Proto, "udp") && sock.LocalPort >= ephemeralLow && sock.LocalPort <= ephemeralHigh {
+			continue
+		}
+		counts[strings.TrimSuffix(sock.Proto, "6")]++
+		key := sock.Key()
+		listeners[key] = sock
+		for _, owner := range owners[sock.Inode] {
+			processes[key] = appendUnique(processes[key], fmt.Sprintf("%d/%s", owner.PID, owner.Comm))
+			if _, ok := containers[key]; !ok && owner.Container.InContainer() {
+				containers[key] = owner.Container
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(listeners))
+	for key := range listeners {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		sock := listeners[key]
+		if sock.Wildcard() && matchPortSpec(n.localhostOnly, sock) {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "listener_wildcard_bind",
+				Severity:    scanner.SeverityHigh,
+				Category:    "network",
+				Description: fmt.Sprintf("%s listens on all interfaces but is expected on localhost only", key),
+				Evidence:    listenerEvidence(sock, processes[key], containers[key]),
+				Remediation: "Bind the service to 127.0.0.1 or ::1, or firewall the port.",
+			})
+		}
+		if len(n.allowedPorts) > 0 && !sock.Loopback() && !strings.HasPrefix(sock.Proto, "raw") && !matchPortSpec(n.allowedPorts, sock) {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "listener_port_not_allowed",
+				Severity:    scanner.SeverityMedium,
+				Category:    "network",
+				Description: fmt.Sprintf("%s is not in the allowed port list", key),
+				Evidence:    listenerEvidence(sock, processes[key], containers[key]),
+				Remediation: "Stop the service or add the port to allowed_ports if it is expected.",
+			})
+		}
+	}
+
+	if n.store != nil {
+		newListeners, baselineCreated, err := n.updateListenerSet(listeners, processes, now)
+		if err != nil {
+			return nil, err
+		}
+		if baselineCreated {
+			result.Metadata["baseline_created"] = true
+		}
+		for _, key := range newListeners {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "listener_new",
+				Severity:    scanner.SeverityMedium,
+				Category:    "network",
+				Description: fmt.Sprintf("New listener %s", key),
+				Evidence:    listenerEvidence(listeners[key], processes[key], containers[key]),
+				Remediation: "Confirm the owning process is expected to accept connections.",
+			})
+		}
+	}
+
+	result.Metadata["tcp_count"] = counts["tcp"]
+	result.Metadata["udp_count"] = counts["udp"]
+	result.Metadata["raw_count"] = counts["raw"]
+	result.Metadata["listeners"] = keys
 	return result, nil
 }
 
 func (n *NetworkListeners) Halt(_ context.Context) error { return nil }
 
-func countListeners(data string) int {
-	lines := strings.Split(data, "\n")
-	count := 0
-	for _, line := range lines[1:] {
-		fields := strings.Fields(line)
-		if len(fields) < 4 {
+func listenerEvidence(sock socketEntry, processes []string, container containerInfo) map[string]interface{} {
+	evidence := map[string]interface{}{
+		"proto":     sock.Proto,
+		"address":   sock.LocalAddr.String(),
+		"port":      sock.LocalPort,
+		"uid":       sock.UID,
+		"inode":     sock.Inode,
+		"processes": processes,
+	}
+	container.addEvidence(evidence)
+	return evidence
+}
+
+// updateListenerSet records every listener seen with first/last-seen times and
+// returns the ones not seen before. Entries unseen for forgetAfter are dropped
+// so a listener that returns much later is reported again.
+func (n *NetworkListeners) updateListenerSet(listeners map[string]socketEntry, processes map[string][]string, now time.Time) ([]string, bool, error) {
+	known := map[string]listenerRecord{}
+	err := n.store.ForEach(listenerBucket, func(key, value []byte) error {
+		var rec listenerRecord
+		if err := json.Unmarshal(value, &rec); err == nil {
+			known[string(key)] = rec
+		}
+		return nil
+	})
+	if err != nil && err != storage.ErrNotFound {
+		return nil, false, fmt.Errorf("load listeners: %w", err)
+	}
+	firstRun := len(known) == 0
+
+	added := []string{}
+	for key, sock := range listeners {
+		rec, ok := known[key]
+		if !ok {
+			rec = listenerRecord{Proto: sock.Proto, Address: sock.LocalAddr.String(), Port: sock.LocalPort, FirstSeen: now}
+			if !firstRun {
+				added = append(added, key)
+			}
+		}
+		rec.LastSeen = now
+		rec.Processes = processes[key]
+		if err := saveJSON(n.store, listenerBucket, key, rec); err != nil {
+			return nil, false, fmt.Errorf("save listener: %w", err)
+		}
+	}
+	for key, rec := range known {
+		if _, ok := listeners[key]; ok {
 			continue
 		}
-		// TCP state 0A = LISTEN; UDP uses 07 for listening sockets.
-		state := fields[3]
-		if state == "0A" || state == "07" {
-			count++
+		if n.forgetAfter > 0 && now.Sub(rec.LastSeen) > n.forgetAfter {
+			if err := n.store.Delete(listenerBucket, key); err != nil {
+				return nil, false, fmt.Errorf("prune listener: %w", err)
+			}
+		}
+	}
+	sort.Strings(added)
+	return added, firstRun, nil
+}
+
+// portSpecs parses a list of ports given as numbers, "22", or "tcp/22".
+func portSpecs(config map[string]interface{}, key string, defaults []string) (map[string]bool, error) {
+	raw := []string{}
+	switch v := config[key].(type) {
+	case nil:
+		raw = defaults
+	case []interface{}:
+		for _, item := range v {
+			switch spec := item.(type) {
+			case float64:
+				raw = append(raw, strconv.Itoa(int(spec)))
+			case string:
+				raw = append(raw, spec)
+			}
+		}
+	case []string:
+		raw = v
+	}
+	specs := map[string]bool{}
+	for _, spec := range raw {
+		proto, port, ok := strings.Cut(spec, "/")
+		if !ok {
+			proto, port = "", spec
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("%s: invalid port %q", key, spec)
+		}
+		specs[strings.ToLower(proto)+"/"+port] = true
+	}
+	return specs, nil
+}
+
+func matchPortSpec(specs map[string]bool, sock socketEntry) bool {
+	port := strconv.Itoa(sock.LocalPort)
+	return specs["/"+port] || specs[strings.TrimSuffix(sock.Proto, "6")+"/"+port]
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
 		}
 	}
-	return count
+	return append(list, value)
 }
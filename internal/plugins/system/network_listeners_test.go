@@ -4,7 +4,10 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
 func TestNetworkListeners(t *testing.T) {
@@ -36,3 +39,111 @@ func TestNetworkListeners(t *testing.T) {
 		t.Fatalf("expected tcp_count 1, got %v", result.Metadata["tcp_count"])
 	}
 }
+
+func TestNetworkListenersInventory(t *testing.T) {
+	netDir := t.TempDir()
+	procDir := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	header := "sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	write := func(name, body string) {
+		if err := os.WriteFile(filepath.Join(netDir, name), []byte(header+body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	write("tcp", "0: 00000000:18EB 00000000:0000 0A 00000000:00000000 00:00000000 00000000 999 0 4242 1\n"+
+		"1: 0100007F:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000 26 0 4343 1\n"+
+		"2: 0100007F:1538 0100007F:D431 01 00000000:00000000 00:00000000 00000000 26 0 4444 1\n")
+	write("udp", "0: 00000000:9C40 00000000:0000 07 00000000:00000000 00:00000000 00000000 0 0 4545 2\n")
+	write("tcp6", "0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 4646 1\n")
+
+	fdDir := filepath.Join(procDir, "321", "fd")
+	if err := os.MkdirAll(fdDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.Symlink("socket:[4242]", filepath.Join(fdDir, "3")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(procDir, "321", "comm"), []byte("redis-server\n"), 0o600); err != nil {
+		t.Fatalf("write comm: %v", err)
+	}
+
+	plugin := &NetworkListeners{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{
+		"net_root":      netDir,
+		"proc_root":     procDir,
+		"allowed_ports": []interface{}{"tcp/22", float64(6379), "udp/40000"},
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	listeners := result.Metadata["listeners"].([]string)
+	want := "tcp 0.0.0.0:6379,tcp 127.0.0.1:5432,tcp6 [::]:22,udp 0.0.0.0:40000"
+	if strings.Join(listeners, ",") != want {
+		t.Fatalf("expected listeners %s, got %v", want, listeners)
+	}
+	if len(result.Findings) != 1 || result.Findings[0].ID != "listener_wildcard_bind" {
+		t.Fatalf("expected wildcard bind finding only, got %+v", result.Findings)
+	}
+	if procs := result.Findings[0].Evidence["processes"].([]string); len(procs) != 1 || procs[0] != "321/redis-server" {
+		t.Fatalf("expected pid attribution, got %v", procs)
+	}
+
+	write("tcp6", "0: 00000000000000000000000000000000:0016 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 4646 1\n"+
+		"1: 00000000000000000000000000000000:1F90 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000 0 0 4747 1\n")
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	ids := []string{}
+	for _, finding := range result.Findings {
+		ids = append(ids, finding.ID)
+	}
+	if strings.Join(ids, ",") != "listener_wildcard_bind,listener_port_not_allowed,listener_new" {
+		t.Fatalf("unexpected findings: %v", ids)
+	}
+}
+
+func TestNetworkListenersEphemeralUDP(t *testing.T) {
+	netDir := t.TempDir()
+	header := "sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	for name, body := range map[string]string{
+		"tcp": "",
+		// An unconnected socket on 40000 and a connected one on 40001.
+		"udp": "0: 00000000:9C40 00000000:0000 07 00000000:00000000 00:00000000 00000000 0 0 4545 2\n" +
+			"1: 0100007F:9C41 0100007F:0035 01 00000000:00000000 00:00000000 00000000 0 0 4546 2\n",
+	} {
+		if err := os.WriteFile(filepath.Join(netDir, name), []byte(header+body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	run := func(config map[string]interface{}) ([]string, int) {
+		t.Helper()
+		config["net_root"] = netDir
+		config["proc_root"] = t.TempDir()
+		config["allowed_ports"] = []interface{}{"tcp/22"}
+		plugin := &NetworkListeners{}
+		if err := plugin.Init(config); err != nil {
+			t.Fatalf("init: %v", err)
+		}
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		return result.Metadata["listeners"].([]string), len(result.Findings)
+	}
+	if listeners, findings := run(map[string]interface{}{}); strings.Join(listeners, ",") != "udp 0.0.0.0:40000" || findings != 1 {
+		t.Fatalf("expected the high-port UDP listener to be reported, got %v with %d findings", listeners, findings)
+	}
+	if listeners, _ := run(map[string]interface{}{"ignore_ephemeral_udp": true}); len(listeners) != 0 {
+		t.Fatalf("expected ephemeral UDP to be skipped on request, got %v", listeners)
+	}
+}
@@ -0,0 +1,176 @@
+package system
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type socketEntry struct {
+	Proto      string
+	LocalAddr  net.IP
+	LocalPort  int
+	RemoteAddr net.IP
+	RemotePort int
+	State      string
+	UID        int
+	Inode      uint64
+}
+
+const (
+	tcpStateListen  = "0A"
+	udpStateUnconn  = "07"
+	socketInodeLink = "socket:["
+)
+
+// Listening reports whether the socket accepts traffic: TCP in LISTEN,
+// unconnected UDP, and every raw socket.
+func (s socketEntry) Listening() bool {
+	switch {
+	case strings.HasPrefix(s.Proto, "tcp"):
+		return s.State == tcpStateListen
+	case strings.HasPrefix(s.Proto, "udp"):
+		return s.State == udpStateUnconn && s.RemotePort == 0 && (s.RemoteAddr == nil || s.RemoteAddr.IsUnspecified())
+	default:
+		return true
+	}
+}
+
+func (s socketEntry) Wildcard() bool {
+	return s.LocalAddr.IsUnspecified()
+}
+
+func (s socketEntry) Loopback() bool {
+	return s.LocalAddr.IsLoopback()
+}
+
+// Key identifies a listener independent of the process that owns it.
+func (s socketEntry) Key() string {
+	return fmt.Sprintf("%s %s", s.Proto, net.JoinHostPort(s.LocalAddr.String(), strconv.Itoa(s.LocalPort)))
+}
+
+// parseSocketTable parses /proc/net/{tcp,udp,raw}[6]. Addresses are hex in
+// host byte order, 32-bit word by word for IPv6.
+func parseSocketTable(proto, data string) []socketEntry {
+	entries := []socketEntry{}
+	lines := strings.Split(data, "\n")
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		localAddr, localPort, err := decodeSocketAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		remoteAddr, remotePort, err := decodeSocketAddr(fields[2])
+		if err != nil {
+			continue
+		}
+		uid, _ := strconv.Atoi(fields[7])
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+		entries = append(entries, socketEntry{
+			Proto:      proto,
+			LocalAddr:  localAddr,
+			LocalPort:  localPort,
+			RemoteAddr: remoteAddr,
+			RemotePort: remotePort,
+			State:      fields[3],
+			UID:        uid,
+			Inode:      inode,
+		})
+	}
+	return entries
+}
+
+func decodeSocketAddr(raw string) (net.IP, int, error) {
+	hostHex, portHex, ok := strings.Cut(raw, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("malformed address %q", raw)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	host, err := hex.DecodeString(hostHex)
+	if err != nil || (len(host) != net.IPv4len && len(host) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("malformed address %q", raw)
+	}
+	ip := make(net.IP, len(host))
+	for i := 0; i < len(host); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(host[i:]))
+	}
+	if v4 := ip.To4(); v4 != nil && len(host) == net.IPv4len {
+		ip = v4
+	}
+	return ip, int(port), nil
+}
+
+type socketOwner struct {
+	PID       int
+	Comm      string
+	Container containerInfo
+}
+
+// socketOwners maps socket inodes to the processes holding them open by
+// reading every /proc/<pid>/fd link. Unreadable processes are skipped.
+func socketOwners(procRoot string) map[uint64][]socketOwner {
+	owners := map[uint64][]socketOwner{}
+	containers := newContainerResolver(procRoot)
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		comm := ""
+		seen := map[uint64]bool{}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, socketInodeLink) {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, socketInodeLink), "]"), 10, 64)
+			if err != nil || seen[inode] {
+				continue
+			}
+			seen[inode] = true
+			if comm == "" {
+				raw, _ := os.ReadFile(filepath.Join(procRoot, entry.Name(), "comm"))
+				comm = strings.TrimSpace(string(raw))
+			}
+			owners[inode] = append(owners[inode], socketOwner{PID: pid, Comm: comm, Container: containers.pid(pid)})
+		}
+	}
+	return owners
+}
+
+// ephemeralPortRange reads net.ipv4.ip_local_port_range, falling back to the
+// kernel default.
+func ephemeralPortRange(procRoot string) (int, int) {
+	raw, err := os.ReadFile(filepath.Join(procRoot, "sys/net/ipv4/ip_local_port_range"))
+	if err == nil {
+		fields := strings.Fields(string(raw))
+		if len(fields) == 2 {
+			low, errLow := strconv.Atoi(fields[0])
+			high, errHigh := strconv.Atoi(fields[1])
+			if errLow == nil && errHigh == nil {
+				return low, high
+			}
+		}
+	}
+	return 32768, 60999
+}
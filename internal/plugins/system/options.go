@@ -0,0 +1,18 @@
+package system
+
+func configStrings(config map[string]interface{}, key string) ([]string, bool) {
+	switch v := config[key].(type) {
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, raw := range v {
+			if s, ok := raw.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	case []string:
+		return append([]string{}, v...), true
+	default:
+		return nil, false
+	}
+}
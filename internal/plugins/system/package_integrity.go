@@ -0,0 +1,406 @@
+package system
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+// PackageIntegrity verifies installed files against the checksums recorded by
+// the package manager, so tampering is visible without a trusted baseline.
+type PackageIntegrity struct {
+	root        string
+	dpkgInfoDir string
+	rpmManifest string
+	unownedDirs []string
+	exclude     globSet
+}
+
+type packageFile struct {
+	Package string
+	Path    string
+	Digest  string
+	Config  bool
+}
+
+type packageManifest struct {
+	files    []packageFile
+	owned    map[string]struct{}
+	sources  []string
+	packages map[string]struct{}
+}
+
+func (p *PackageIntegrity) Name() string { return "system.package_integrity" }
+
+func (p *PackageIntegrity) Init(config map[string]interface{}) error {
+	p.root = "/"
+	p.dpkgInfoDir = ""
+	p.rpmManifest = ""
+	p.unownedDirs = []string{"/bin", "/sbin", "/usr/bin", "/usr/sbin"}
+	p.exclude = nil
+
+	if v, ok := config["root"].(string); ok && v != "" {
+		p.root = v
+	}
+	if v, ok := config["dpkg_info_dir"].(string); ok && v != "" {
+		p.dpkgInfoDir = v
+	}
+	if p.dpkgInfoDir == "" {
+		p.dpkgInfoDir = filepath.Join(p.root, "var/lib/dpkg/info")
+	}
+	if v, ok := config["rpm_manifest"].(string); ok {
+		p.rpmManifest = v
+	}
+	if v, ok := configStrings(config, "unowned_dirs"); ok {
+		p.unownedDirs = v
+	}
+	if v, ok := configStrings(config, "exclude"); ok {
+		globs, err := compileGlobs(v)
+		if err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+		p.exclude = globs
+	}
+	return nil
+}
+
+func (p *PackageIntegrity) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: p.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	manifest, err := p.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata["sources"] = manifest.sources
+	result.Metadata["packages"] = len(manifest.packages)
+	if len(manifest.sources) == 0 {
+		return result, nil
+	}
+
+	checked, modified, missing, configChanged := 0, 0, 0, 0
+	for _, file := range manifest.files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		path := p.hostPath(file.Path)
+		if p.exclude.Match(path) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				missing++
+				result.Findings = append(result.Findings, packageFinding(
+					"package_file_missing", scanner.SeverityMedium,
+					fmt.Sprintf("Packaged file %s is missing", path),
+					file, path, "Reinstall the owning package and investigate why the file was removed.",
+				))
+			}
+			continue
+		}
+		algorithm := digestAlgorithm(file.Digest)
+		if algorithm == "" || !info.Mode().IsRegular() {
+			continue
+		}
+		checked++
+		sum, err := hashFileWith(path, algorithm)
+		if err != nil {
+			finding := packageFinding(
+				"package_file_hash_error", scanner.SeverityLow,
+				fmt.Sprintf("Failed to hash %s", path),
+				file, path, "Verify file readability.",
+			)
+			finding.Evidence["error"] = err.Error()
+			result.Findings = append(result.Findings, finding)
+			continue
+		}
+		if strings.EqualFold(sum, file.Digest) {
+			continue
+		}
+		if file.Config {
+			configChanged++
+			continue
+		}
+		modified++
+		finding := packageFinding(
+			"package_file_modified", scanner.SeverityHigh,
+			fmt.Sprintf("%s does not match the checksum recorded by package %s", path, file.Package),
+			file, path, "Compare the file with the vendor package and reinstall it if the change is not expected.",
+		)
+		finding.Evidence["expected"] = strings.ToLower(file.Digest)
+		finding.Evidence["actual"] = sum
+		finding.Evidence["algorithm"] = algorithm
+		result.Findings = append(result.Findings, finding)
+	}
+
+	unowned, err := p.findUnowned(ctx, manifest.owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range unowned {
+		result.Findings = append(result.Findings, scanner.Finding{
+			ID:          "package_file_unowned",
+			Severity:    scanner.SeverityMedium,
+			Category:    "package_integrity",
+			Description: fmt.Sprintf("%s is not owned by any installed package", path),
+			Evidence: map[string]interface{}{
+				"path": path,
+			},
+			Remediation: "Identify how the file was installed and remove it if it is not expected.",
+		})
+	}
+
+	result.Metadata["files_checked"] = checked
+	result.Metadata["modified"] = modified
+	result.Metadata["missing"] = missing
+	result.Metadata["unowned"] = len(unowned)
+	result.Metadata["config_changed"] = configChanged
+	return result, nil
+}
+
+func (p *PackageIntegrity) Halt(_ context.Context) error { return nil }
+
+func packageFinding(id string, severity scanner.Severity, description string, file packageFile, path, remediation string) scanner.Finding {
+	return scanner.Finding{
+		ID:          id,
+		Severity:    severity,
+		Category:    "package_integrity",
+		Description: description,
+		Evidence: map[string]interface{}{
+			"path":    path,
+			"package": file.Package,
+		},
+		Remediation: remediation,
+	}
+}
+
+func (p *PackageIntegrity) hostPath(path string) string {
+	return filepath.Join(p.root, path)
+}
+
+func (p *PackageIntegrity) loadManifest() (*packageManifest, error) {
+	manifest := &packageManifest{
+		owned:    map[string]struct{}{},
+		packages: map[string]struct{}{},
+	}
+	if _, err := os.Stat(p.dpkgInfoDir); err == nil {
+		if err := p.loadDpkg(manifest); err != nil {
+			return nil, err
+		}
+		manifest.sources = append(manifest.sources, "dpkg")
+	}
+	if p.rpmManifest != "" {
+		if err := p.loadRPM(manifest); err != nil {
+			return nil, err
+		}
+		manifest.sources = append(manifest.sources, "rpm")
+	}
+	return manifest, nil
+}
+
+// loadDpkg reads <pkg>.md5sums for checksums and <pkg>.list for ownership.
+// Diverted files are verified at their diverted location.
+func (p *PackageIntegrity) loadDpkg(manifest *packageManifest) error {
+	diversions, err := p.loadDiversions()
+	if err != nil {
+		return err
+	}
+	for _, div := range diversions {
+		manifest.owned[p.hostPath(div.to)] = struct{}{}
+	}
+
+	lists, err := filepath.Glob(filepath.Join(p.dpkgInfoDir, "*.list"))
+	if err != nil {
+		return err
+	}
+	for _, list := range lists {
+		pkg := strings.TrimSuffix(filepath.Base(list), ".list")
+		manifest.packages[pkg] = struct{}{}
+		if err := readLines(list, func(line string) {
+			if line != "" && line != "/." {
+				manifest.owned[p.hostPath(line)] = struct{}{}
+			}
+		}); err != nil {
+			return fmt.Errorf("read %s: %w", list, err)
+		}
+	}
+
+	sums, err := filepath.Glob(filepath.Join(p.dpkgInfoDir, "*.md5sums"))
+	if err != nil {
+		return err
+	}
+	for _, sumFile := range sums {
+		pkg := strings.TrimSuffix(filepath.Base(sumFile), ".md5sums")
+		name := strings.SplitN(pkg, ":", 2)[0]
+		manifest.packages[pkg] = struct{}{}
+		if err := readLines(sumFile, func(line string) {
+			digest, path, ok := strings.Cut(line, "  ")
+			if !ok || digest == "" || path == "" {
+				return
+			}
+			path = "/" + strings.TrimPrefix(path, "/")
+			if div, ok := diversions[path]; ok && div.pkg != name {
+				path = div.to
+			}
+			manifest.owned[p.hostPath(path)] = struct{}{}
+			manifest.files = append(manifest.files, packageFile{Package: pkg, Path: path, Digest: digest})
+		}); err != nil {
+			return fmt.Errorf("read %s: %w", sumFile, err)
+		}
+	}
+	return nil
+}
+
+type dpkgDiversion struct {
+	to  string
+	pkg string
+}
+
+// loadDiversions parses /var/lib/dpkg/diversions, which stores each diversion
+// as three lines: original path, diverted path, and diverting package.
+func (p *PackageIntegrity) loadDiversions() (map[string]dpkgDiversion, error) {
+	diversions := map[string]dpkgDiversion{}
+	lines := []string{}
+	err := readLines(filepath.Join(p.root, "var/lib/dpkg/diversions"), func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return diversions, nil
+		}
+		return nil, fmt.Errorf("read diversions: %w", err)
+	}
+	for i := 0; i+2 < len(lines); i += 3 {
+		diversions[lines[i]] = dpkgDiversion{to: lines[i+1], pkg: lines[i+2]}
+	}
+	return diversions, nil
+}
+
+// loadRPM reads a tab-separated manifest of package, path, digest and an
+// optional file flags column, as produced by:
+//
+//	rpm -qa --qf '[%{=NVRA}\t%{FILENAMES}\t%{FILEDIGESTS}\t%{FILEFLAGS:fflags}\n]'
+func (p *PackageIntegrity) loadRPM(manifest *packageManifest) error {
+	err := readLines(p.rpmManifest, func(line string) {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 || fields[1] == "" {
+			return
+		}
+		file := packageFile{Package: fields[0], Path: fields[1], Digest: fields[2]}
+		ghost := false
+		if len(fields) > 3 {
+			file.Config = strings.Contains(fields[3], "c")
+			ghost = strings.Contains(fields[3], "g")
+		}
+		manifest.packages[file.Package] = struct{}{}
+		manifest.owned[p.hostPath(file.Path)] = struct{}{}
+		// %ghost files (logs, runtime state) are owned but not shipped, so
+		// they are neither expected on disk nor hashed.
+		if !ghost {
+			manifest.files = append(manifest.files, file)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("read rpm manifest: %w", err)
+	}
+	return nil
+}
+
+// findUnowned walks unownedDirs for regular files that no package lists.
+// Paths are compared with symlinked directories resolved so merged-/usr
+// layouts (/bin -> usr/bin) match either spelling.
+func (p *PackageIntegrity) findUnowned(ctx context.Context, owned map[string]struct{}) ([]string, error) {
+	resolvedDirs := map[string]string{}
+	resolve := func(path string) string {
+		dir := filepath.Dir(path)
+		resolved, ok := resolvedDirs[dir]
+		if !ok {
+			resolved = dir
+			if real, err := filepath.EvalSymlinks(dir); err == nil {
+				resolved = real
+			}
+			resolvedDirs[dir] = resolved
+		}
+		return filepath.Join(resolved, filepath.Base(path))
+	}
+	canonical := make(map[string]struct{}, len(owned))
+	for path := range owned {
+		canonical[resolve(path)] = struct{}{}
+	}
+
+	roots := map[string]struct{}{}
+	for _, dir := range p.unownedDirs {
+		real, err := filepath.EvalSymlinks(p.hostPath(dir))
+		if err != nil {
+			continue
+		}
+		roots[real] = struct{}{}
+	}
+
+	unowned := []string{}
+	for root := range roots {
+		err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil || d.IsDir() || !d.Type().IsRegular() {
+				return nil
+			}
+			if p.exclude.Match(path) {
+				return nil
+			}
+			if _, ok := canonical[path]; !ok {
+				unowned = append(unowned, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walk %s: %w", root, err)
+		}
+	}
+	sort.Strings(unowned)
+	return unowned, nil
+}
+
+func digestAlgorithm(digest string) string {
+	switch len(digest) {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	default:
+		return ""
+	}
+}
+
+func readLines(path string, fn func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lines := bufio.NewScanner(file)
+	lines.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lines.Scan() {
+		fn(strings.TrimRight(lines.Text(), "\r"))
+	}
+	return lines.Err()
+}
@@ -0,0 +1,89 @@
+package system
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageIntegrityDpkg(t *testing.T) {
+	root := t.TempDir()
+	info := filepath.Join(root, "var/lib/dpkg/info")
+	bin := filepath.Join(root, "usr/bin")
+	for _, dir := range []string{info, bin} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	sum := func(content string) string {
+		h := md5.Sum([]byte(content))
+		return hex.EncodeToString(h[:])
+	}
+
+	write(filepath.Join(bin, "good"), "good")
+	write(filepath.Join(bin, "bad"), "tampered")
+	write(filepath.Join(bin, "dropper"), "payload")
+	write(filepath.Join(info, "tools:amd64.md5sums"),
+		sum("good")+"  usr/bin/good\n"+sum("bad")+"  usr/bin/bad\n"+sum("gone")+"  usr/bin/gone\n")
+	write(filepath.Join(info, "tools:amd64.list"), "/.\n/usr\n/usr/bin\n/usr/bin/good\n/usr/bin/bad\n/usr/bin/gone\n")
+
+	pi := &PackageIntegrity{}
+	if err := pi.Init(map[string]interface{}{
+		"root":         root,
+		"unowned_dirs": []interface{}{"/usr/bin"},
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got := map[string]string{}
+	for _, finding := range result.Findings {
+		got[finding.ID] = finding.Evidence["path"].(string)
+	}
+	want := map[string]string{
+		"package_file_modified": filepath.Join(bin, "bad"),
+		"package_file_missing":  filepath.Join(bin, "gone"),
+		"package_file_unowned":  filepath.Join(bin, "dropper"),
+	}
+	if len(result.Findings) != len(want) {
+		t.Fatalf("expected %d findings, got %+v", len(want), result.Findings)
+	}
+	for id, path := range want {
+		if got[id] != path {
+			t.Fatalf("expected %s for %s, got %q", id, path, got[id])
+		}
+	}
+}
+
+func TestPackageIntegrityRPMGhost(t *testing.T) {
+	root := t.TempDir()
+	manifest := filepath.Join(t.TempDir(), "rpm.tsv")
+	content := "logrotate-3.18.0-8.el9.x86_64\t/var/lib/logrotate/logrotate.status\t\tg\n" +
+		"logrotate-3.18.0-8.el9.x86_64\t/usr/sbin/logrotate\t" + strings.Repeat("0", 64) + "\t\n"
+	if err := os.WriteFile(manifest, []byte(content), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	pi := &PackageIntegrity{}
+	if err := pi.Init(map[string]interface{}{"root": root, "rpm_manifest": manifest, "unowned_dirs": []interface{}{}}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pi.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 1 || result.Findings[0].Evidence["path"] != filepath.Join(root, "usr/sbin/logrotate") {
+		t.Fatalf("expected only the shipped file to be missing, got %+v", result.Findings)
+	}
+}
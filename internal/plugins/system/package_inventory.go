@@ -0,0 +1,150 @@
+package system
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ipsix/arcsent/internal/signatures"
+)
+
+// readDpkgStatus lists installed packages from a dpkg status file. Source
+// carries the source package, which Debian and Ubuntu advisories are keyed
+// by; a "Source: name (version)" field overrides the version too.
+func readDpkgStatus(path string) ([]signatures.InstalledPackage, error) {
+	var packages []signatures.InstalledPackage
+	var current signatures.InstalledPackage
+	var status, sourceVersion string
+	flush := func() {
+		if current.Name != "" && current.Version != "" && strings.HasSuffix(status, " installed") {
+			if sourceVersion != "" {
+				current.Version = sourceVersion
+			}
+			current.Format = signatures.VersionDpkg
+			packages = append(packages, current)
+		}
+		current, status, sourceVersion = signatures.InstalledPackage{}, "", ""
+	}
+	err := readLines(path, func(line string) {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			return
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			return
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			current.Name = value
+		case "Status":
+			status = value
+		case "Version":
+			current.Version = value
+		case "Source":
+			name, version, hasVersion := strings.Cut(value, " (")
+			current.Source = strings.TrimSpace(name)
+			if hasVersion {
+				sourceVersion = strings.TrimSuffix(version, ")")
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return packages, nil
+}
+
+// readRPMPackages reads one package per line, with the NEVRA in the first
+// tab-separated column, as produced by:
+//
+//	rpm -qa --qf '%{NVRA}\t%{EPOCHNUM}\t%{SOURCERPM}\n'
+//
+// The epoch and source columns are optional, so the system.package_integrity
+// file manifest works too.
+func readRPMPackages(path string) ([]signatures.InstalledPackage, error) {
+	var packages []signatures.InstalledPackage
+	seen := map[string]bool{}
+	err := readLines(path, func(line string) {
+		fields := strings.Split(line, "\t")
+		if seen[fields[0]] {
+			return
+		}
+		seen[fields[0]] = true
+		name, version, ok := splitNVRA(fields[0])
+		if !ok {
+			return
+		}
+		pkg := signatures.InstalledPackage{Name: name, Version: version, Format: signatures.VersionRPM}
+		if len(fields) > 1 && fields[1] != "" && fields[1] != "0" && !strings.HasPrefix(fields[1], "/") {
+			pkg.Version = fields[1] + ":" + version
+		}
+		if len(fields) > 2 && strings.HasSuffix(fields[2], ".src.rpm") {
+			if source, _, ok := splitNVRA(strings.TrimSuffix(fields[2], ".rpm")); ok {
+				pkg.Source = source
+			}
+		}
+		packages = append(packages, pkg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return packages, nil
+}
+
+// splitNVRA splits name-version-release.arch into the name and
+// version-release.
+func splitNVRA(nvra string) (string, string, bool) {
+	nvr := nvra
+	if i := strings.LastIndex(nvra, "."); i > 0 {
+		nvr = nvra[:i]
+	}
+	rel := strings.LastIndex(nvr, "-")
+	if rel <= 0 {
+		return "", "", false
+	}
+	ver := strings.LastIndex(nvr[:rel], "-")
+	if ver <= 0 {
+		return "", "", false
+	}
+	return nvr[:ver], nvr[ver+1:], true
+}
+
+var osReleaseQuote = regexp.MustCompile(`^["']|["']$`)
+
+// osEcosystem maps /etc/os-release to the OSV ecosystem prefix for the
+// running distribution, e.g. "Debian:12" or "Rocky Linux:9".
+func osEcosystem(path string) (string, error) {
+	fields := map[string]string{}
+	if err := readLines(path, func(line string) {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			fields[key] = osReleaseQuote.ReplaceAllString(strings.TrimSpace(value), "")
+		}
+	}); err != nil {
+		return "", err
+	}
+	version := fields["VERSION_ID"]
+	major, _, _ := strings.Cut(version, ".")
+	switch fields["ID"] {
+	case "debian":
+		return "Debian:" + major, nil
+	case "ubuntu":
+		return "Ubuntu:" + version, nil
+	case "rocky":
+		return "Rocky Linux:" + major, nil
+	case "almalinux":
+		return "AlmaLinux:" + major, nil
+	case "rhel":
+		return "Red Hat:enterprise_linux:" + major, nil
+	case "opensuse-leap":
+		return "openSUSE:Leap " + version, nil
+	case "sles":
+		return "SUSE:Linux Enterprise Server " + major, nil
+	}
+	return "", fmt.Errorf("no OSV ecosystem known for os-release ID %q; set ecosystem", fields["ID"])
+}
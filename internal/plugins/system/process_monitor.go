@@ -10,10 +10,19 @@ import (
 	"time"
 
 	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
 )
 
 type ProcessMonitor struct {
 	whitelistPrefixes []string
+	procRoot          string
+	lineageRules      []lineageRule
+	writableDirs      []string
+	hashExe           bool
+	allowlistMode     string
+	learnWindow       time.Duration
+	deltaRetention    time.Duration
+	store             storage.Store
 }
 
 func (p *ProcessMonitor) Name() string { return "system.process_monitor" }
@@ -30,63 +39,232 @@ func (p *ProcessMonitor) Init(config map[string]interface{}) error {
 	if v, ok := config["whitelist_prefixes"].([]string); ok {
 		p.whitelistPrefixes = append([]string{}, v...)
 	}
+	p.procRoot = "/proc"
+	if v, ok := config["proc_root"].(string); ok && v != "" {
+		p.procRoot = v
+	}
+	p.writableDirs = []string{"/tmp", "/var/tmp", "/dev/shm", "/run/shm"}
+	if v, ok := configStrings(config, "writable_dirs"); ok {
+		p.writableDirs = v
+	}
+	p.hashExe = false
+	if v, ok := config["hash_exe"].(bool); ok {
+		p.hashExe = v
+	}
+	p.allowlistMode = "off"
+	if v, ok := config["allowlist_mode"].(string); ok && v != "" {
+		p.allowlistMode = v
+	}
+	switch p.allowlistMode {
+	case "off", "learn", "enforce":
+	default:
+		return fmt.Errorf("allowlist_mode must be one of: off, learn, enforce")
+	}
+	// Matches the shipped config; an explicit "0" keeps learning until
+	// allowlist_mode is changed.
+	p.learnWindow = 72 * time.Hour
+	if v, ok := config["learn_window"].(string); ok && v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("learn_window: %w", err)
+		}
+		p.learnWindow = window
+	}
+	p.deltaRetention = 7 * 24 * time.Hour
+	if v, ok := config["delta_retention"].(string); ok && v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("delta_retention: %w", err)
+		}
+		p.deltaRetention = retention
+	}
+	rawRules := make([]interface{}, 0, len(defaultLineageRules))
+	for _, rule := range defaultLineageRules {
+		rawRules = append(rawRules, rule)
+	}
+	if v, ok := config["lineage_rules"].([]interface{}); ok {
+		rawRules = v
+	}
+	rules, err := parseLineageRules(rawRules)
+	if err != nil {
+		return err
+	}
+	p.lineageRules = rules
 	return nil
 }
 
 func (p *ProcessMonitor) Run(_ context.Context) (*scanner.Result, error) {
+	now := time.Now().UTC()
 	result := &scanner.Result{
 		ScannerName: p.Name(),
 		Status:      scanner.StatusSuccess,
 		Metadata: map[string]interface{}{
-			"timestamp": time.Now().Format(time.RFC3339),
+			"timestamp": now.Format(time.RFC3339),
 		},
 	}
 
-	procEntries, err := os.ReadDir("/proc")
+	table, err := listProcesses(p.procRoot)
 	if err != nil {
-		return nil, fmt.Errorf("read /proc: %w", err)
+		return nil, err
 	}
+	hashes := newExeHasher(p.procRoot)
 
-	processes := 0
-	for _, entry := range procEntries {
-		if !entry.IsDir() {
-			continue
-		}
-		pid, err := strconv.Atoi(entry.Name())
-		if err != nil || pid <= 0 {
-			continue
-		}
-		processes++
-
-		exePath, err := os.Readlink(filepath.Join("/proc", entry.Name(), "exe"))
-		if err != nil {
-			continue
-		}
+	for _, pid := range table.sortedPIDs() {
+		proc := table[pid]
+		chain := table.ancestors(pid)
+		first := len(result.Findings)
+		result.Findings = append(result.Findings, p.exeFindings(proc, chain)...)
 
-		if len(p.whitelistPrefixes) == 0 {
-			continue
-		}
-
-		if !hasPrefix(exePath, p.whitelistPrefixes) {
+		if proc.Exe != "" && len(p.whitelistPrefixes) > 0 && !hasPrefix(proc.Exe, p.whitelistPrefixes) {
+			evidence := proc.evidence()
+			evidence["lineage"] = lineageEvidence(chain)
 			result.Findings = append(result.Findings, scanner.Finding{
 				ID:          "process_not_whitelisted",
 				Severity:    scanner.SeverityMedium,
 				Category:    "process",
 				Description: "Process executable not in whitelist",
-				Evidence: map[string]interface{}{
-					"pid": pid,
-					"exe": exePath,
-				},
+				Evidence:    evidence,
 				Remediation: "Verify the process is expected or update the whitelist.",
 			})
 		}
+
+		for _, rule := range p.lineageRules {
+			ancestor, ok := rule.match(proc, chain)
+			if !ok {
+				continue
+			}
+			evidence := proc.evidence()
+			evidence["rule"] = rule.ID
+			evidence["lineage"] = lineageEvidence(chain)
+			evidence["matched_ancestor"] = ancestor.evidence()
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          rule.ID,
+				Severity:    rule.Severity,
+				Category:    "process",
+				Description: rule.Description,
+				Evidence:    evidence,
+				Remediation: "Inspect the process tree and command line for web-shell or reverse-shell activity and terminate it if unexpected.",
+			})
+		}
+
+		if p.hashExe && len(result.Findings) > first {
+			if sum, err := hashes.sum(pid); err == nil {
+				for i := first; i < len(result.Findings); i++ {
+					result.Findings[i].Evidence["exe_sha256"] = sum
+				}
+			}
+		}
 	}
 
-	result.Metadata["processes"] = processes
+	if p.allowlistMode != "off" {
+		if p.store == nil {
+			return nil, fmt.Errorf("process allowlist requires storage")
+		}
+		learning, err := p.allowlistLearning(now)
+		if err != nil {
+			return nil, err
+		}
+		if learning {
+			learned, err := p.learnHashes(table, hashes, now)
+			if err != nil {
+				return nil, err
+			}
+			result.Metadata["allowlist_mode"] = "learn"
+			result.Metadata["allowlist_learned"] = learned
+		} else {
+			findings, err := p.enforceHashes(table, hashes)
+			if err != nil {
+				return nil, err
+			}
+			result.Metadata["allowlist_mode"] = "enforce"
+			result.Findings = append(result.Findings, findings...)
+		}
+	}
+
+	if p.store != nil {
+		delta, err := p.recordDelta(table, hashes, now)
+		if err != nil {
+			return nil, err
+		}
+		if delta != nil {
+			result.Metadata["processes_started"] = len(delta.Started)
+			result.Metadata["processes_exited"] = len(delta.Exited)
+		}
+	}
+
+	containers := map[string]bool{}
+	for _, proc := range table {
+		if proc.Container.ID != "" {
+			containers[proc.Container.ID] = true
+		}
+	}
+	result.Metadata["processes"] = len(table)
+	result.Metadata["containers"] = len(containers)
+	result.Metadata["lineage_rules"] = len(p.lineageRules)
 	result.Metadata["whitelist_prefixes"] = strings.Join(p.whitelistPrefixes, ",")
 	return result, nil
 }
 
+// exeFindings flags executables that only exist in memory, were deleted after
+// launch, or live in a world-writable location.
+func (p *ProcessMonitor) exeFindings(proc processInfo, chain []processInfo) []scanner.Finding {
+	if proc.Exe == "" {
+		return nil
+	}
+	finding := func(id string, severity scanner.Severity, description, remediation string) scanner.Finding {
+		evidence := proc.evidence()
+		evidence["lineage"] = lineageEvidence(chain)
+		return scanner.Finding{
+			ID:          id,
+			Severity:    severity,
+			Category:    "process",
+			Description: description,
+			Evidence:    evidence,
+			Remediation: remediation,
+		}
+	}
+
+	if proc.Memfd() {
+		return []scanner.Finding{finding(
+			"process_fileless", scanner.SeverityCritical,
+			"Process is executing from an anonymous memfd file",
+			"Capture the process memory for analysis and terminate it; fileless execution is rarely legitimate.",
+		)}
+	}
+	findings := []scanner.Finding{}
+	if proc.Deleted() {
+		findings = append(findings, finding(
+			"process_deleted_binary", scanner.SeverityHigh,
+			"Process executable was deleted after launch",
+			"Restart the service if a package upgrade replaced the binary; otherwise preserve /proc/<pid>/exe and investigate.",
+		))
+	}
+	if p.writableLocation(proc) {
+		findings = append(findings, finding(
+			"process_writable_location", scanner.SeverityHigh,
+			"Process executable is in a world-writable location",
+			"Verify why a binary runs from a temporary directory and remove it if unexpected.",
+		))
+	}
+	return findings
+}
+
+func (p *ProcessMonitor) writableLocation(proc processInfo) bool {
+	exe := proc.ExePath()
+	for _, dir := range p.writableDirs {
+		if underAny(exe, []string{dir}) {
+			return true
+		}
+	}
+	dir := filepath.Dir(exe)
+	if proc.Container.InContainer() {
+		// The exe path is relative to the container's mount namespace.
+		dir = filepath.Join(p.procRoot, strconv.Itoa(proc.PID), "root", dir)
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().Perm()&0o002 != 0
+}
+
 func (p *ProcessMonitor) Halt(_ context.Context) error { return nil }
 
 func hasPrefix(value string, prefixes []string) bool {
@@ -1,6 +1,17 @@
 package system
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
 
 func TestProcessMonitorInit(t *testing.T) {
 	pm := &ProcessMonitor{}
@@ -14,3 +25,154 @@ func TestProcessMonitorInit(t *testing.T) {
 		t.Fatalf("expected whitelist prefix to be set")
 	}
 }
+
+func TestProcessMonitorLineageRules(t *testing.T) {
+	proc := t.TempDir()
+	writeFakeProcess(t, proc, 1, 0, "systemd", "/usr/lib/systemd/systemd")
+	writeFakeProcess(t, proc, 100, 1, "nginx", "/usr/sbin/nginx", "nginx: worker process")
+	writeFakeProcess(t, proc, 200, 100, "sh", "/usr/bin/dash", "sh", "-c", "id")
+	writeFakeProcess(t, proc, 300, 1, "cron", "/usr/sbin/cron")
+	writeFakeProcess(t, proc, 301, 300, "sh", "/usr/bin/dash", "sh", "-c", "python3 /tmp/x.py")
+	writeFakeProcess(t, proc, 302, 301, "python3", "/usr/bin/python3.12", "python3", "/tmp/x.py")
+
+	pm := &ProcessMonitor{}
+	if err := pm.Init(map[string]interface{}{"proc_root": proc}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pm.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 2 {
+		t.Fatalf("expected 2 lineage findings, got %+v", result.Findings)
+	}
+	web := result.Findings[0]
+	if web.ID != "web_shell_lineage" || web.Evidence["pid"] != 200 || web.Evidence["cmdline"] != "sh -c id" {
+		t.Fatalf("unexpected web shell finding: %+v", web)
+	}
+	if cron := result.Findings[1]; cron.ID != "cron_interpreter_lineage" || cron.Evidence["pid"] != 302 {
+		t.Fatalf("unexpected cron finding: %+v", cron)
+	}
+}
+
+func writeFakeProcess(t *testing.T, root string, pid, ppid int, comm, exe string, args ...string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	stat := fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 100 0 0", pid, comm, ppid, pid, pid)
+	files := map[string]string{
+		"stat":    stat,
+		"status":  "Name:\t" + comm + "\nUid:\t33\t33\t33\t33\n",
+		"cmdline": strings.Join(args, "\x00"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+}
+
+func TestProcessMonitorSuspiciousExecutables(t *testing.T) {
+	proc := t.TempDir()
+	staging := t.TempDir()
+	dropped := filepath.Join(staging, "kworker")
+	if err := os.WriteFile(dropped, []byte("payload"), 0o755); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	writeFakeProcess(t, proc, 10, 1, "3", "/memfd:payload (deleted)")
+	writeFakeProcess(t, proc, 11, 1, "updater", "/usr/sbin/updater (deleted)")
+	writeFakeProcess(t, proc, 12, 1, "kworker", dropped)
+
+	pm := &ProcessMonitor{}
+	if err := pm.Init(map[string]interface{}{
+		"proc_root":     proc,
+		"lineage_rules": []interface{}{},
+		"writable_dirs": []interface{}{staging},
+		"hash_exe":      true,
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pm.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	ids := []string{}
+	for _, finding := range result.Findings {
+		ids = append(ids, finding.ID)
+	}
+	want := "process_fileless,process_deleted_binary,process_writable_location"
+	if strings.Join(ids, ",") != want {
+		t.Fatalf("expected %s, got %v", want, ids)
+	}
+	if hash, _ := result.Findings[2].Evidence["exe_sha256"].(string); len(hash) != 64 {
+		t.Fatalf("expected exe hash evidence, got %v", result.Findings[2].Evidence)
+	}
+}
+
+func TestProcessMonitorHashAllowlistAndDelta(t *testing.T) {
+	proc := t.TempDir()
+	bins := t.TempDir()
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+
+	trusted := filepath.Join(bins, "trusted")
+	dropped := filepath.Join(bins, "dropped")
+	for path, content := range map[string]string{trusted: "trusted", dropped: "dropped"} {
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	writeFakeProcess(t, proc, 10, 1, "trusted", trusted)
+
+	pm := &ProcessMonitor{}
+	pm.WithStore(store)
+	config := map[string]interface{}{
+		"proc_root":      proc,
+		"lineage_rules":  []interface{}{},
+		"writable_dirs":  []interface{}{},
+		"allowlist_mode": "learn",
+	}
+	if err := pm.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := pm.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if result.Metadata["allowlist_learned"] != 1 {
+		t.Fatalf("expected one learned hash, got %v", result.Metadata)
+	}
+
+	writeFakeProcess(t, proc, 11, 1, "dropped", dropped)
+	writeFakeProcess(t, proc, 12, 1, "trusted", trusted)
+	config["allowlist_mode"] = "enforce"
+	if err := pm.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err = pm.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(result.Findings) != 1 || result.Findings[0].ID != "process_hash_not_allowlisted" || result.Findings[0].Evidence["exe"] != dropped {
+		t.Fatalf("expected dropped binary to be flagged, got %+v", result.Findings)
+	}
+	if result.Metadata["processes_started"] != 2 || result.Metadata["processes_exited"] != 0 {
+		t.Fatalf("unexpected delta metadata: %v", result.Metadata)
+	}
+
+	deltas, err := storage.NewProcessDeltaStore(store).Since(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+	if len(deltas) != 1 || len(deltas[0].Started) != 2 || deltas[0].Started[0].SHA256 == "" {
+		t.Fatalf("expected persisted delta with hashed new processes, got %+v", deltas)
+	}
+}
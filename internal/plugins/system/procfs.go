@@ -0,0 +1,247 @@
+package system
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is USER_HZ, which is 100 on every mainstream Linux architecture.
+const clockTicks = 100
+
+const maxCmdlineLen = 4096
+
+type processInfo struct {
+	PID       int
+	PPID      int
+	Comm      string
+	Exe       string
+	Cmdline   []string
+	UID       int
+	EUID      int
+	StartTime time.Time
+	CWD       string
+	Cgroup    string
+	Container containerInfo
+	// Namespaces holds the pid, mnt and net namespace inodes. They are
+	// evidence only: bwrap, flatpak, browser sandboxes and systemd services
+	// with PrivatePIDs have their own namespaces too, so attribution comes
+	// from the cgroup alone.
+	Namespaces map[string]uint64
+}
+
+// Name is the executable base name, falling back to comm for kernel threads
+// and processes whose exe link cannot be read.
+func (p processInfo) Name() string {
+	if p.Exe != "" {
+		return filepath.Base(p.ExePath())
+	}
+	return p.Comm
+}
+
+// ExePath is the executable path without the " (deleted)" marker.
+func (p processInfo) ExePath() string {
+	return strings.TrimSuffix(p.Exe, " (deleted)")
+}
+
+func (p processInfo) Deleted() bool {
+	return strings.HasSuffix(p.Exe, " (deleted)")
+}
+
+// Memfd reports whether the process runs from an anonymous memfd_create file.
+func (p processInfo) Memfd() bool {
+	return strings.HasPrefix(p.Exe, "/memfd:")
+}
+
+func (p processInfo) CmdlineString() string {
+	cmd := strings.Join(p.Cmdline, " ")
+	if len(cmd) > maxCmdlineLen {
+		cmd = cmd[:maxCmdlineLen]
+	}
+	return cmd
+}
+
+func (p processInfo) evidence() map[string]interface{} {
+	evidence := map[string]interface{}{
+		"pid":     p.PID,
+		"ppid":    p.PPID,
+		"name":    p.Name(),
+		"exe":     p.Exe,
+		"cmdline": p.CmdlineString(),
+		"uid":     p.UID,
+		"euid":    p.EUID,
+		"cwd":     p.CWD,
+	}
+	if !p.StartTime.IsZero() {
+		evidence["start_time"] = p.StartTime.UTC().Format(time.RFC3339)
+	}
+	if p.Cgroup != "" {
+		evidence["cgroup"] = p.Cgroup
+	}
+	for ns, inode := range p.Namespaces {
+		evidence[ns+"_ns"] = inode
+	}
+	p.Container.addEvidence(evidence)
+	return evidence
+}
+
+type processTable map[int]processInfo
+
+// listProcesses reads every numeric entry under procRoot. Processes that exit
+// mid-read are skipped.
+func listProcesses(procRoot string) (processTable, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", procRoot, err)
+	}
+	bootTime := readBootTime(procRoot)
+	table := processTable{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid <= 0 {
+			continue
+		}
+		info, err := readProcess(procRoot, pid, bootTime)
+		if err != nil {
+			continue
+		}
+		table[pid] = info
+	}
+	return table, nil
+}
+
+func readProcess(procRoot string, pid int, bootTime time.Time) (processInfo, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	info := processInfo{PID: pid, UID: -1, EUID: -1}
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return processInfo{}, err
+	}
+	// comm is wrapped in parentheses and may itself contain spaces or ")".
+	open := bytes.IndexByte(stat, '(')
+	closing := bytes.LastIndexByte(stat, ')')
+	if open < 0 || closing < open {
+		return processInfo{}, fmt.Errorf("malformed stat for pid %d", pid)
+	}
+	info.Comm = string(stat[open+1 : closing])
+	fields := strings.Fields(string(stat[closing+1:]))
+	// fields[0] is state (field 3), so field N is fields[N-3].
+	if len(fields) > 1 {
+		info.PPID, _ = strconv.Atoi(fields[1])
+	}
+	if len(fields) > 19 && !bootTime.IsZero() {
+		if ticks, err := strconv.ParseInt(fields[19], 10, 64); err == nil {
+			info.StartTime = bootTime.Add(time.Duration(ticks) * time.Second / clockTicks)
+		}
+	}
+
+	if status, err := os.ReadFile(filepath.Join(dir, "status")); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if rest, ok := strings.CutPrefix(line, "Uid:"); ok {
+				ids := strings.Fields(rest)
+				if len(ids) > 1 {
+					info.UID, _ = strconv.Atoi(ids[0])
+					info.EUID, _ = strconv.Atoi(ids[1])
+				}
+				break
+			}
+		}
+	}
+	if raw, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		raw = bytes.TrimRight(raw, "\x00")
+		if len(raw) > 0 {
+			for _, arg := range bytes.Split(raw, []byte{0}) {
+				info.Cmdline = append(info.Cmdline, string(arg))
+			}
+		}
+	}
+	info.Exe, _ = os.Readlink(filepath.Join(dir, "exe"))
+	info.CWD, _ = os.Readlink(filepath.Join(dir, "cwd"))
+	if raw, err := os.ReadFile(filepath.Join(dir, "cgroup")); err == nil {
+		info.Cgroup = strings.Join(strings.Fields(string(raw)), ",")
+		info.Container = parseCgroupContainer(string(raw))
+	}
+	info.Namespaces = readNamespaces(dir)
+	return info, nil
+}
+
+// readNamespaces returns the inode of each pid, mnt and net namespace link
+// under a /proc/<pid> directory that could be read.
+func readNamespaces(dir string) map[string]uint64 {
+	var namespaces map[string]uint64
+	for _, ns := range []string{"pid", "mnt", "net"} {
+		link, err := os.Readlink(filepath.Join(dir, "ns", ns))
+		if err != nil {
+			continue
+		}
+		// Links look like "mnt:[4026531841]".
+		_, inode, ok := strings.Cut(link, ":[")
+		if !ok {
+			continue
+		}
+		if v, err := strconv.ParseUint(strings.TrimSuffix(inode, "]"), 10, 64); err == nil {
+			if namespaces == nil {
+				namespaces = map[string]uint64{}
+			}
+			namespaces[ns] = v
+		}
+	}
+	return namespaces
+}
+
+func readBootTime(procRoot string) time.Time {
+	raw, err := os.ReadFile(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(raw), "\n") {
+		if rest, ok := strings.CutPrefix(line, "btime "); ok {
+			if secs, err := strconv.ParseInt(strings.TrimSpace(rest), 10, 64); err == nil {
+				return time.Unix(secs, 0)
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// ancestors returns the parent chain of pid, nearest first. The walk stops at
+// pid 0, at a missing parent, or if the chain loops.
+func (t processTable) ancestors(pid int) []processInfo {
+	chain := []processInfo{}
+	seen := map[int]bool{pid: true}
+	current, ok := t[pid]
+	for ok && current.PPID > 0 && !seen[current.PPID] {
+		seen[current.PPID] = true
+		current, ok = t[current.PPID]
+		if ok {
+			chain = append(chain, current)
+		}
+	}
+	return chain
+}
+
+func (t processTable) sortedPIDs() []int {
+	pids := make([]int, 0, len(t))
+	for pid := range t {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+func lineageEvidence(chain []processInfo) []string {
+	out := make([]string, 0, len(chain))
+	for _, proc := range chain {
+		out = append(out, fmt.Sprintf("%d:%s", proc.PID, proc.Name()))
+	}
+	return out
+}
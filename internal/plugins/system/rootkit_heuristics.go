@@ -0,0 +1,457 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+// RootkitHeuristics cross-checks views of processes, ports and kernel modules
+// that the kernel exposes through different interfaces. A rootkit that hooks
+// one of them (getdents on /proc, the /proc/net seq files, the module list)
+// rarely hooks them all, so every disagreement is reported.
+type RootkitHeuristics struct {
+	procRoot       string
+	sysRoot        string
+	maxPID         int
+	fastPIDSweep   bool
+	probeProcesses bool
+	probePorts     bool
+	probeModules   bool
+	ignorePorts    map[string]bool
+
+	// Probes are fields so tests can stand in for the live kernel.
+	pidAlive  func(pid int) bool
+	portInUse func(proto string, port int) bool
+}
+
+func (r *RootkitHeuristics) Name() string { return "system.rootkit_heuristics" }
+
+func (r *RootkitHeuristics) Init(config map[string]interface{}) error {
+	r.procRoot = "/proc"
+	r.sysRoot = "/sys"
+	if v, ok := config["proc_root"].(string); ok && v != "" {
+		r.procRoot = v
+	}
+	if v, ok := config["sys_root"].(string); ok && v != "" {
+		r.sysRoot = v
+	}
+	r.maxPID = 0
+	if v, ok := config["max_pid"].(float64); ok && v > 0 {
+		r.maxPID = int(v)
+	}
+	r.fastPIDSweep = false
+	if v, ok := config["pid_sweep"].(string); ok && v != "" {
+		switch v {
+		case "full":
+		case "fast":
+			r.fastPIDSweep = true
+		default:
+			return fmt.Errorf("pid_sweep must be one of: full, fast")
+		}
+	}
+	r.probeProcesses, r.probePorts, r.probeModules = true, true, true
+	if v, ok := config["processes"].(bool); ok {
+		r.probeProcesses = v
+	}
+	if v, ok := config["ports"].(bool); ok {
+		r.probePorts = v
+	}
+	if v, ok := config["modules"].(bool); ok {
+		r.probeModules = v
+	}
+	// Kernel-owned tunnel sockets (VXLAN, flannel, Geneve, WireGuard) hold
+	// their port without appearing in /proc/net.
+	var err error
+	if r.ignorePorts, err = portSpecs(config, "ignore_ports", []string{"udp/4789", "udp/8472", "udp/6081", "udp/51820"}); err != nil {
+		return err
+	}
+	r.pidAlive = pidAlive
+	r.portInUse = portInUse
+	return nil
+}
+
+func (r *RootkitHeuristics) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: r.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+	if r.probeProcesses {
+		findings, err := r.hiddenProcesses(ctx, result.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		result.Findings = append(result.Findings, findings...)
+	}
+	if r.probePorts {
+		findings, err := r.hiddenPorts(ctx, result.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		result.Findings = append(result.Findings, findings...)
+	}
+	if r.probeModules {
+		findings, err := r.hiddenModules(result.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		result.Findings = append(result.Findings, findings...)
+	}
+	return result, nil
+}
+
+func (r *RootkitHeuristics) Halt(_ context.Context) error { return nil }
+
+// pidProbeMargin is how far past the highest listed or last allocated PID the
+// fast sweep reaches, for processes started during the scan.
+const pidProbeMargin = 4096
+
+// pidSweepChunk is how many PIDs are probed between context checks.
+const pidSweepChunk = 4096
+
+// hiddenProcesses probes PIDs with kill(pid, 0) and a stat of /proc/<pid>,
+// and reports live processes missing from the /proc listing. Unless max_pid
+// is set, the sweep runs to pid_max: a rootkit can fork until it is handed a
+// PID far above every listed one. The fast sweep instead stops
+// pidProbeMargin past the highest listed PID or ns_last_pid. Candidates are
+// probed and listed again so processes that started or exited during the
+// sweep are not reported.
+func (r *RootkitHeuristics) hiddenProcesses(ctx context.Context, metadata map[string]interface{}) ([]scanner.Finding, error) {
+	listed, err := r.listPIDs()
+	if err != nil {
+		return nil, err
+	}
+	maxPID := r.maxPID
+	if maxPID == 0 {
+		maxPID = readIntFile(filepath.Join(r.procRoot, "sys/kernel/pid_max"), 32768)
+		if r.fastPIDSweep {
+			highest := readIntFile(filepath.Join(r.procRoot, "sys/kernel/ns_last_pid"), 0)
+			for pid := range listed {
+				if pid > highest {
+					highest = pid
+				}
+			}
+			if highest+pidProbeMargin < maxPID {
+				maxPID = highest + pidProbeMargin
+			}
+		}
+	}
+	candidates := map[int][]string{}
+	for start := 1; start <= maxPID; start += pidSweepChunk {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for pid := start; pid < start+pidSweepChunk && pid <= maxPID; pid++ {
+			if listed[pid] {
+				continue
+			}
+			if methods := r.probePID(pid); len(methods) > 0 {
+				candidates[pid] = methods
+			}
+		}
+	}
+	metadata["pids_listed"] = len(listed)
+	metadata["pids_probed"] = maxPID
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	if listed, err = r.listPIDs(); err != nil {
+		return nil, err
+	}
+	hidden := map[int][]string{}
+	for _, pid := range sortedInts(candidates) {
+		methods := r.probePID(pid)
+		if listed[pid] || len(methods) == 0 {
+			continue
+		}
+		// Thread IDs answer both probes but are only listed under their
+		// thread group leader.
+		tgid := readStatusInt(filepath.Join(r.procRoot, strconv.Itoa(pid), "status"), "Tgid:")
+		if tgid > 0 && tgid != pid {
+			if listed[tgid] {
+				continue
+			}
+			pid = tgid
+		}
+		hidden[pid] = methods
+	}
+
+	findings := []scanner.Finding{}
+	for _, pid := range sortedInts(hidden) {
+		dir := filepath.Join(r.procRoot, strconv.Itoa(pid))
+		evidence := map[string]interface{}{
+			"pid":         pid,
+			"detected_by": hidden[pid],
+		}
+		// Hooks usually only filter readdir, so the entry is still readable.
+		if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+			evidence["comm"] = strings.TrimSpace(string(comm))
+		}
+		if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+			evidence["exe"] = exe
+		}
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			evidence["cmdline"] = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "hidden_process",
+			Severity:    scanner.SeverityCritical,
+			Category:    "rootkit",
+			Description: fmt.Sprintf("Process %d is alive but hidden from the /proc listing", pid),
+			Evidence:    evidence,
+			Remediation: "Treat the host as compromised: isolate it, capture memory, and rebuild it from known-good media.",
+		})
+	}
+	return findings, nil
+}
+
+func (r *RootkitHeuristics) probePID(pid int) []string {
+	methods := []string{}
+	if r.pidAlive(pid) {
+		methods = append(methods, "kill")
+	}
+	if _, err := os.Lstat(filepath.Join(r.procRoot, strconv.Itoa(pid))); err == nil {
+		methods = append(methods, "stat")
+	}
+	return methods
+}
+
+func (r *RootkitHeuristics) listPIDs() (map[int]bool, error) {
+	entries, err := os.ReadDir(r.procRoot)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", r.procRoot, err)
+	}
+	pids := map[int]bool{}
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && pid > 0 {
+			pids[pid] = true
+		}
+	}
+	return pids, nil
+}
+
+// hiddenPorts binds every TCP and UDP port and reports ports the kernel says
+// are in use that no socket in /proc/net/{tcp,udp}[6] holds, in any state.
+func (r *RootkitHeuristics) hiddenPorts(ctx context.Context, metadata map[string]interface{}) ([]scanner.Finding, error) {
+	known := r.socketPorts()
+	candidates := []string{}
+	for _, proto := range []string{"tcp", "udp"} {
+		for port := 1; port <= 65535; port++ {
+			if port%4096 == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+			}
+			key := fmt.Sprintf("%s/%d", proto, port)
+			if known[key] || r.ignorePorts[key] || r.ignorePorts["/"+strconv.Itoa(port)] {
+				continue
+			}
+			if r.portInUse(proto, port) {
+				candidates = append(candidates, key)
+			}
+		}
+	}
+	metadata["ports_probed"] = 2 * 65535
+	metadata["sockets_listed"] = len(known)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	known = r.socketPorts()
+	findings := []scanner.Finding{}
+	for _, key := range candidates {
+		proto, portText, _ := strings.Cut(key, "/")
+		port, _ := strconv.Atoi(portText)
+		if known[key] || !r.portInUse(proto, port) {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "hidden_port",
+			Severity:    scanner.SeverityCritical,
+			Category:    "rootkit",
+			Description: fmt.Sprintf("%s port %d is in use but no socket in /proc/net holds it", strings.ToUpper(proto), port),
+			Evidence:    map[string]interface{}{"proto": proto, "port": port},
+			Remediation: "Treat the host as compromised unless the port belongs to a kernel tunnel; add it to ignore_ports in that case.",
+		})
+	}
+	return findings, nil
+}
+
+func (r *RootkitHeuristics) socketPorts() map[string]bool {
+	ports := map[string]bool{}
+	for _, table := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		data, err := os.ReadFile(filepath.Join(r.procRoot, "net", table))
+		if err != nil {
+			continue
+		}
+		for _, sock := range parseSocketTable(table, string(data)) {
+			ports[fmt.Sprintf("%s/%d", strings.TrimSuffix(table, "6"), sock.LocalPort)] = true
+		}
+	}
+	return ports
+}
+
+// hiddenModules compares /proc/modules with the loadable modules in
+// /sys/module (those with an initstate file; built-ins have none). Both
+// sides are read twice so a module loading mid-check is not reported.
+func (r *RootkitHeuristics) hiddenModules(metadata map[string]interface{}) ([]scanner.Finding, error) {
+	var listed, sysfs map[string]bool
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if listed, err = r.procModules(); err != nil {
+			return nil, err
+		}
+		sysfs = r.sysfsModules()
+		if sameKeys(listed, sysfs) {
+			break
+		}
+	}
+	metadata["modules_listed"] = len(listed)
+	findings := []scanner.Finding{}
+	for _, name := range sortedKeys(sysfs) {
+		if listed[name] {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "hidden_module",
+			Severity:    scanner.SeverityCritical,
+			Category:    "rootkit",
+			Description: fmt.Sprintf("Kernel module %s is in /sys/module but hidden from /proc/modules", name),
+			Evidence:    map[string]interface{}{"module": name, "sysfs": filepath.Join(r.sysRoot, "module", name)},
+			Remediation: "Treat the host as compromised: a module unlinking itself from the module list is a rootkit technique.",
+		})
+	}
+	for _, name := range sortedKeys(listed) {
+		if sysfs[name] {
+			continue
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "module_missing_sysfs",
+			Severity:    scanner.SeverityCritical,
+			Category:    "rootkit",
+			Description: fmt.Sprintf("Kernel module %s is in /proc/modules but missing from /sys/module", name),
+			Evidence:    map[string]interface{}{"module": name},
+			Remediation: "Treat the host as compromised: a module removing its sysfs entry is hiding from inspection tools.",
+		})
+	}
+	return findings, nil
+}
+
+func (r *RootkitHeuristics) procModules() (map[string]bool, error) {
+	modules, err := readModules(filepath.Join(r.procRoot, "modules"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read modules: %w", err)
+	}
+	names := map[string]bool{}
+	for _, mod := range modules {
+		names[mod.Name] = true
+	}
+	return names, nil
+}
+
+func (r *RootkitHeuristics) sysfsModules() map[string]bool {
+	names := map[string]bool{}
+	dir := filepath.Join(r.sysRoot, "module")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), "initstate")); err == nil {
+			names[entry.Name()] = true
+		}
+	}
+	return names
+}
+
+// pidAlive uses kill(pid, 0): EPERM still means the process exists.
+func pidAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// portInUse reports whether binding the port on the IPv4 or IPv6 wildcard
+// address fails with EADDRINUSE. TCP probes set SO_REUSEADDR so TIME_WAIT
+// sockets do not count; ports that need privileges fail differently and are
+// treated as free.
+func portInUse(proto string, port int) bool {
+	return bindInUse(syscall.AF_INET, proto, port) || bindInUse(syscall.AF_INET6, proto, port)
+}
+
+func bindInUse(family int, proto string, port int) bool {
+	sockType := syscall.SOCK_STREAM
+	if proto == "udp" {
+		sockType = syscall.SOCK_DGRAM
+	}
+	fd, err := syscall.Socket(family, sockType, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+	if proto == "tcp" {
+		_ = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}
+	var addr syscall.Sockaddr = &syscall.SockaddrInet4{Port: port}
+	if family == syscall.AF_INET6 {
+		_ = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 1)
+		addr = &syscall.SockaddrInet6{Port: port}
+	}
+	return syscall.Bind(fd, addr) == syscall.EADDRINUSE
+}
+
+func readIntFile(path string, fallback int) int {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fallback
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+// readStatusInt returns the first number after key in a /proc status file,
+// or 0.
+func readStatusInt(path, key string) int {
+	value := 0
+	_ = readLines(path, func(line string) {
+		if rest, ok := strings.CutPrefix(line, key); ok && value == 0 {
+			value, _ = strconv.Atoi(strings.TrimSpace(rest))
+		}
+	})
+	return value
+}
+
+func sortedInts[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key := range a {
+		if !b[key] {
+			return false
+		}
+	}
+	return true
+}
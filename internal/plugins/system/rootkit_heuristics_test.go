@@ -0,0 +1,102 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRootkitHeuristics(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("proc/1/status", "Name:\tsystemd\nTgid:\t1\n")
+	write("proc/100/status", "Name:\tsshd\nTgid:\t100\n")
+	write("proc/sys/kernel/pid_max", "40000\n")
+	write("proc/sys/kernel/ns_last_pid", "700\n")
+	write("proc/net/tcp", "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"+
+		"   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1000 1 0000000000000000 100 0 0 10 0\n")
+	write("proc/net/udp", "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n")
+	write("proc/modules", "ext4 1007616 1 - Live 0x0000000000000000\nghost 16384 0 - Live 0x0000000000000000 (OE)\n")
+	write("sys/module/ext4/initstate", "live\n")
+	write("sys/module/diamorphine/initstate", "live\n")
+	write("sys/module/kernel/parameters/panic", "0\n")
+
+	plugin := &RootkitHeuristics{}
+	if err := plugin.Init(map[string]interface{}{
+		"proc_root": filepath.Join(dir, "proc"),
+		"sys_root":  filepath.Join(dir, "sys"),
+	}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	// 30000 sits far past every listed PID, as a rootkit that forked its way
+	// up would.
+	alive := func(pid int) bool { return pid == 1 || pid == 100 || pid == 666 || pid == 30000 }
+	plugin.pidAlive = alive
+	plugin.portInUse = func(proto string, port int) bool {
+		switch proto + "/" + strconv.Itoa(port) {
+		case "tcp/22", "tcp/31337", "udp/4789":
+			return true
+		}
+		return false
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := []string{}
+	for _, finding := range result.Findings {
+		got = append(got, finding.ID+":"+finding.Description)
+		if finding.Severity != "critical" {
+			t.Fatalf("severity %s for %s", finding.Severity, finding.ID)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"hidden_module:Kernel module diamorphine is in /sys/module but hidden from /proc/modules",
+		"hidden_port:TCP port 31337 is in use but no socket in /proc/net holds it",
+		"hidden_process:Process 30000 is alive but hidden from the /proc listing",
+		"hidden_process:Process 666 is alive but hidden from the /proc listing",
+		"module_missing_sysfs:Kernel module ghost is in /proc/modules but missing from /sys/module",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if result.Metadata["pids_probed"] != 40000 || result.Metadata["pids_listed"] != 2 {
+		t.Fatalf("metadata: %v", result.Metadata)
+	}
+
+	// The fast sweep stops short of pid_max.
+	if err := plugin.Init(map[string]interface{}{
+		"proc_root": filepath.Join(dir, "proc"),
+		"sys_root":  filepath.Join(dir, "sys"),
+		"pid_sweep": "fast",
+		"ports":     false,
+		"modules":   false,
+	}); err != nil {
+		t.Fatalf("init fast: %v", err)
+	}
+	plugin.pidAlive = alive
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run fast: %v", err)
+	}
+	if len(result.Findings) != 1 || result.Findings[0].Evidence["pid"] != 666 || result.Metadata["pids_probed"] != 700+pidProbeMargin {
+		t.Fatalf("fast sweep: %+v %v", result.Findings, result.Metadata)
+	}
+	if err := plugin.Init(map[string]interface{}{"pid_sweep": "quick"}); err == nil {
+		t.Fatalf("expected invalid pid_sweep to fail")
+	}
+}
@@ -0,0 +1,347 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const sshAuditBucket = "ssh_audit_state"
+
+// SSHAudit checks sshd_config for risky settings and tracks every user's
+// authorized_keys between runs.
+type SSHAudit struct {
+	root       string
+	configPath string
+	keyFiles   []string
+	minRSABits int
+	store      storage.Store
+}
+
+type sshKeyRecord struct {
+	User string `json:"user"`
+	File string `json:"file"`
+	authorizedKey
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+type sshdRule struct {
+	Keyword     string
+	Default     string
+	Risky       func(value string) bool
+	ID          string
+	Severity    scanner.Severity
+	Remediation string
+}
+
+var sshdRules = []sshdRule{
+	{"PermitRootLogin", "prohibit-password", equalsFold("yes"), "ssh_permit_root_login", scanner.SeverityHigh, "Set PermitRootLogin to no or prohibit-password."},
+	{"PasswordAuthentication", "yes", equalsFold("yes"), "ssh_password_authentication", scanner.SeverityMedium, "Set PasswordAuthentication no and use keys."},
+	{"PermitEmptyPasswords", "no", equalsFold("yes"), "ssh_permit_empty_passwords", scanner.SeverityCritical, "Set PermitEmptyPasswords no."},
+	{"PermitUserEnvironment", "no", func(v string) bool { return !strings.EqualFold(v, "no") }, "ssh_permit_user_environment", scanner.SeverityMedium, "Set PermitUserEnvironment no."},
+	{"HostbasedAuthentication", "no", equalsFold("yes"), "ssh_hostbased_authentication", scanner.SeverityMedium, "Set HostbasedAuthentication no."},
+	{"IgnoreRhosts", "yes", equalsFold("no"), "ssh_rhosts_allowed", scanner.SeverityMedium, "Set IgnoreRhosts yes."},
+	{"StrictModes", "yes", equalsFold("no"), "ssh_strict_modes_disabled", scanner.SeverityMedium, "Set StrictModes yes."},
+	{"X11Forwarding", "no", equalsFold("yes"), "ssh_x11_forwarding", scanner.SeverityLow, "Set X11Forwarding no unless it is needed."},
+	{"Ciphers", "", weakAlgorithms, "ssh_weak_algorithms", scanner.SeverityMedium, "Remove CBC, arcfour, and 3DES ciphers."},
+	{"MACs", "", weakAlgorithms, "ssh_weak_algorithms", scanner.SeverityMedium, "Remove MD5, SHA1-96, and RIPEMD MACs."},
+	{"KexAlgorithms", "", weakAlgorithms, "ssh_weak_algorithms", scanner.SeverityMedium, "Remove SHA1 Diffie-Hellman key exchanges."},
+}
+
+var weakSSHAlgorithms = map[string]bool{
+	"3des-cbc": true, "aes128-cbc": true, "aes192-cbc": true, "aes256-cbc": true, "blowfish-cbc": true,
+	"cast128-cbc": true, "arcfour": true, "arcfour128": true, "arcfour256": true, "rijndael-cbc@lysator.liu.se": true,
+	"hmac-md5": true, "hmac-md5-96": true, "hmac-sha1-96": true, "hmac-md5-etm@openssh.com": true,
+	"hmac-md5-96-etm@openssh.com": true, "hmac-sha1-96-etm@openssh.com": true, "hmac-ripemd160": true,
+	"diffie-hellman-group1-sha1": true, "diffie-hellman-group-exchange-sha1": true,
+}
+
+func equalsFold(want string) func(string) bool {
+	return func(v string) bool { return strings.EqualFold(v, want) }
+}
+
+// weakAlgorithms reports whether an algorithm list enables a weak entry. Lists
+// prefixed with "-" only remove algorithms.
+func weakAlgorithms(value string) bool {
+	if strings.HasPrefix(value, "-") {
+		return false
+	}
+	for _, alg := range strings.Split(strings.TrimLeft(value, "+^"), ",") {
+		if weakSSHAlgorithms[strings.ToLower(strings.TrimSpace(alg))] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SSHAudit) Name() string { return "system.ssh_audit" }
+
+func (s *SSHAudit) WithStore(store storage.Store) {
+	s.store = store
+}
+
+func (s *SSHAudit) Init(config map[string]interface{}) error {
+	s.root = "/"
+	s.configPath = ""
+	s.keyFiles = nil
+	s.minRSABits = 2048
+	if v, ok := config["root"].(string); ok && v != "" {
+		s.root = v
+	}
+	if v, ok := config["sshd_config"].(string); ok && v != "" {
+		s.configPath = v
+	}
+	if s.configPath == "" {
+		s.configPath = filepath.Join(s.root, "etc/ssh/sshd_config")
+	}
+	if v, ok := configStrings(config, "authorized_keys_files"); ok {
+		s.keyFiles = v
+	}
+	if v, ok := config["min_rsa_bits"].(float64); ok && v > 0 {
+		s.minRSABits = int(v)
+	}
+	return nil
+}
+
+func (s *SSHAudit) Run(_ context.Context) (*scanner.Result, error) {
+	now := time.Now().UTC()
+	result := &scanner.Result{
+		ScannerName: s.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": now.Format(time.RFC3339),
+		},
+	}
+
+	cfg, err := parseSSHDConfig(s.root, s.configPath)
+	switch {
+	case err == nil:
+		result.Metadata["sshd_config_files"] = cfg.Files
+		result.Findings = append(result.Findings, s.configFindings(cfg)...)
+	case errors.Is(err, os.ErrNotExist):
+		cfg = &sshdConfig{Global: map[string]sshdSetting{}}
+	default:
+		return nil, fmt.Errorf("read sshd_config: %w", err)
+	}
+
+	keys, err := s.collectKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata["authorized_keys"] = len(keys)
+	for _, id := range sortedKeys(keys) {
+		rec := keys[id]
+		weak := rec.Type == "ssh-dss" || (rec.Type == "ssh-rsa" && rec.Bits > 0 && rec.Bits < s.minRSABits)
+		if weak {
+			result.Findings = append(result.Findings, scanner.Finding{
+				ID:          "ssh_weak_key",
+				Severity:    scanner.SeverityMedium,
+				Category:    "ssh",
+				Description: fmt.Sprintf("Weak %s key authorized for %s in %s", rec.Type, rec.User, rec.File),
+				Evidence:    sshKeyEvidence(rec),
+				Remediation: fmt.Sprintf("Replace the key with ed25519 or RSA of at least %d bits.", s.minRSABits),
+			})
+		}
+	}
+
+	if s.store != nil {
+		previous := map[string]sshKeyRecord{}
+		ok, err := loadJSON(s.store, sshAuditBucket, "authorized_keys", &previous)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			result.Metadata["baseline_created"] = true
+		}
+		for _, id := range sortedKeys(keys) {
+			if before, seen := previous[id]; seen {
+				rec := keys[id]
+				rec.FirstSeen = before.FirstSeen
+				keys[id] = rec
+				continue
+			}
+			rec := keys[id]
+			rec.FirstSeen = now
+			keys[id] = rec
+			if ok {
+				result.Findings = append(result.Findings, scanner.Finding{
+					ID:          "ssh_key_added",
+					Severity:    scanner.SeverityHigh,
+					Category:    "ssh",
+					Description: fmt.Sprintf("New authorized key %s for %s in %s", rec.Fingerprint, rec.User, rec.File),
+					Evidence:    sshKeyEvidence(rec),
+					Remediation: "Confirm the key owner; remove the key and investigate how it was planted if it is unknown.",
+				})
+			}
+		}
+		for _, id := range sortedKeys(previous) {
+			if _, ok := keys[id]; !ok {
+				rec := previous[id]
+				result.Findings = append(result.Findings, scanner.Finding{
+					ID:          "ssh_key_removed",
+					Severity:    scanner.SeverityLow,
+					Category:    "ssh",
+					Description: fmt.Sprintf("Authorized key %s for %s was removed from %s", rec.Fingerprint, rec.User, rec.File),
+					Evidence:    sshKeyEvidence(rec),
+					Remediation: "Confirm the removal was intended.",
+				})
+			}
+		}
+		if err := saveJSON(s.store, sshAuditBucket, "authorized_keys", keys); err != nil {
+			return nil, fmt.Errorf("save authorized keys: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func (s *SSHAudit) Halt(_ context.Context) error { return nil }
+
+// AcceptBaseline records the current authorized keys so only keys added
+// afterwards are reported.
+func (s *SSHAudit) AcceptBaseline(_ context.Context) error {
+	if s.store == nil {
+		return fmt.Errorf("ssh audit baseline requires storage")
+	}
+	cfg, err := parseSSHDConfig(s.root, s.configPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		cfg = &sshdConfig{Global: map[string]sshdSetting{}}
+	}
+	keys, err := s.collectKeys(cfg)
+	if err != nil {
+		return err
+	}
+	return saveJSON(s.store, sshAuditBucket, "authorized_keys", keys)
+}
+
+// configFindings evaluates the global settings, falling back to sshd
+// defaults, and every setting made inside a Match block.
+func (s *SSHAudit) configFindings(cfg *sshdConfig) []scanner.Finding {
+	findings := []scanner.Finding{}
+	add := func(rule sshdRule, setting sshdSetting, explicit bool, match *sshdMatch) {
+		evidence := map[string]interface{}{
+			"keyword":  rule.Keyword,
+			"value":    setting.Value,
+			"explicit": explicit,
+		}
+		where := "by default"
+		if explicit {
+			evidence["file"] = setting.File
+			evidence["line"] = setting.Line
+			where = fmt.Sprintf("at %s:%d", setting.File, setting.Line)
+		}
+		if match != nil {
+			evidence["match"] = match.Criteria
+			where += " for Match " + match.Criteria
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          rule.ID,
+			Severity:    rule.Severity,
+			Category:    "ssh",
+			Description: fmt.Sprintf("sshd %s %s %s", rule.Keyword, setting.Value, where),
+			Evidence:    evidence,
+			Remediation: rule.Remediation,
+		})
+	}
+	for _, rule := range sshdRules {
+		value, setting, explicit := cfg.Get(rule.Keyword, rule.Default)
+		if value != "" && rule.Risky(value) {
+			add(rule, setting, explicit, nil)
+		}
+		for _, match := range cfg.Matches {
+			if setting, ok := match.Settings[strings.ToLower(rule.Keyword)]; ok && rule.Risky(setting.Value) {
+				add(rule, setting, true, match)
+			}
+		}
+	}
+	return findings
+}
+
+// collectKeys reads the AuthorizedKeysFile of every local user, keyed by file
+// and fingerprint.
+func (s *SSHAudit) collectKeys(cfg *sshdConfig) (map[string]sshKeyRecord, error) {
+	users, err := readPasswd(filepath.Join(s.root, "etc/passwd"))
+	if err != nil {
+		return nil, fmt.Errorf("read passwd: %w", err)
+	}
+	patterns := s.keyFiles
+	if patterns == nil {
+		value, _, _ := cfg.Get("AuthorizedKeysFile", ".ssh/authorized_keys .ssh/authorized_keys2")
+		patterns = strings.Fields(value)
+	}
+
+	keys := map[string]sshKeyRecord{}
+	seen := map[string]bool{}
+	for _, user := range users {
+		for _, pattern := range patterns {
+			if strings.EqualFold(pattern, "none") {
+				continue
+			}
+			file := expandSSHTokens(pattern, user)
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(user.Home, file)
+			}
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			parsed, err := parseAuthorizedKeys(filepath.Join(s.root, file))
+			if err != nil {
+				continue
+			}
+			for _, key := range parsed {
+				keys[file+" "+key.Fingerprint] = sshKeyRecord{User: user.Name, File: file, authorizedKey: key}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// expandSSHTokens expands the %h, %u, %U and %% tokens of AuthorizedKeysFile.
+func expandSSHTokens(pattern string, user passwdEntry) string {
+	var out strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			out.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'h':
+			out.WriteString(user.Home)
+		case 'u':
+			out.WriteString(user.Name)
+		case 'U':
+			out.WriteString(strconv.Itoa(user.UID))
+		default:
+			out.WriteByte(pattern[i])
+		}
+	}
+	return out.String()
+}
+
+func sshKeyEvidence(rec sshKeyRecord) map[string]interface{} {
+	evidence := map[string]interface{}{
+		"user":        rec.User,
+		"file":        rec.File,
+		"line":        rec.Line,
+		"type":        rec.Type,
+		"fingerprint": rec.Fingerprint,
+		"comment":     rec.Comment,
+		"options":     rec.Options,
+	}
+	if rec.Bits > 0 {
+		evidence["bits"] = rec.Bits
+	}
+	return evidence
+}
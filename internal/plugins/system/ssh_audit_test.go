@@ -0,0 +1,78 @@
+package system
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	testEd25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGGzbiAbIAhBDStfoonO54tGQc5fkCxqqun84kbv663B"
+	testRSA1024Key = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDlqOUaZyO7BMFdifMdz97rcBZlJcCjZhzXfI0fkzdqwFmCzp6hKdmn49t2wOgVsxz2DIQPciwk4sCdWYLqosiB4TqL9c8nHgC2xvqMaFhpDkx+uQU1lx6Pv3scyD2IVAaf2zfib0yMnG/VdZg1BxltRxAKH03aYQdhtGrM4RPndQ=="
+)
+
+func TestSSHAudit(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("etc/passwd", "root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000::/home/alice:/bin/bash\n")
+	write("etc/ssh/sshd_config", "Include sshd_config.d/*.conf\nPermitRootLogin no\nPasswordAuthentication no\n")
+	write("etc/ssh/sshd_config.d/10-local.conf", "PermitRootLogin=yes\nCiphers +aes128-cbc\nMatch User backup\n  PermitEmptyPasswords yes\n")
+	write("root/.ssh/authorized_keys", testEd25519Key+" test@host\n")
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	plugin := &SSHAudit{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{"root": root}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	run := func(step string) []string {
+		t.Helper()
+		result, err := plugin.Run(context.Background())
+		if err != nil {
+			t.Fatalf("%s: run: %v", step, err)
+		}
+		ids := []string{}
+		for _, finding := range result.Findings {
+			ids = append(ids, finding.ID)
+			if finding.ID == "ssh_key_added" {
+				ev := finding.Evidence
+				if ev["user"] != "alice" || ev["options"] != `from="10.0.0.0/8,192.168.1.1",command="echo hi there"` || ev["bits"] != 1024 || ev["fingerprint"] != "SHA256:8iOn4Sj1kaqqqEknut3QziwNLdscKiG4tZRRHm4GFPE" {
+					t.Fatalf("unexpected key evidence %v", ev)
+				}
+			}
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// The included file is read first, so its PermitRootLogin wins.
+	got := strings.Join(run("first"), ",")
+	if got != "ssh_permit_empty_passwords,ssh_permit_root_login,ssh_weak_algorithms" {
+		t.Fatalf("first run: got %s", got)
+	}
+
+	write("home/alice/.ssh/authorized_keys", `from="10.0.0.0/8,192.168.1.1",command="echo hi there" `+testRSA1024Key+" root@vm\n")
+	write("root/.ssh/authorized_keys", "# emptied\n")
+	got = strings.Join(run("drift"), ",")
+	if got != "ssh_key_added,ssh_key_removed,ssh_permit_empty_passwords,ssh_permit_root_login,ssh_weak_algorithms,ssh_weak_key" {
+		t.Fatalf("drift run: got %s", got)
+	}
+}
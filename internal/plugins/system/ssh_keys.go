@@ -0,0 +1,110 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"math/big"
+	"strings"
+)
+
+type authorizedKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	Bits        int    `json:"bits,omitempty"`
+	Options     string `json:"options,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+	Line        int    `json:"line"`
+}
+
+// parseAuthorizedKeys parses an authorized_keys file: an optional
+// comma-separated option list (which may quote spaces), the key type, the
+// base64 key blob and a free-form comment.
+func parseAuthorizedKeys(path string) ([]authorizedKey, error) {
+	keys := []authorizedKey{}
+	lineNo := 0
+	err := readLines(path, func(line string) {
+		lineNo++
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+		options := ""
+		if !sshKeyType(firstField(line)) {
+			options, line = splitKeyOptions(line)
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !sshKeyType(fields[0]) {
+			return
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return
+		}
+		sum := sha256.Sum256(blob)
+		keys = append(keys, authorizedKey{
+			Type:        fields[0],
+			Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+			Bits:        sshKeyBits(fields[0], blob),
+			Options:     options,
+			Comment:     strings.Join(fields[2:], " "),
+			Line:        lineNo,
+		})
+	})
+	return keys, err
+}
+
+func sshKeyType(field string) bool {
+	return strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-") || strings.HasPrefix(field, "sk-")
+}
+
+func firstField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// splitKeyOptions returns the leading option list and the rest of the line.
+// Options end at the first whitespace outside double quotes.
+func splitKeyOptions(line string) (string, string) {
+	quoted := false
+	for i, r := range line {
+		switch {
+		case r == '\\' && quoted:
+			continue
+		case r == '"' && (i == 0 || line[i-1] != '\\'):
+			quoted = !quoted
+		case (r == ' ' || r == '\t') && !quoted:
+			return line[:i], strings.TrimSpace(line[i:])
+		}
+	}
+	return line, ""
+}
+
+// sshKeyBits returns the modulus size of RSA and DSA keys from the wire
+// encoding (string type, then mpints), or 0 for other types.
+func sshKeyBits(keyType string, blob []byte) int {
+	if keyType != "ssh-rsa" && keyType != "ssh-dss" {
+		return 0
+	}
+	fields := [][]byte{}
+	for len(blob) >= 4 && len(fields) < 3 {
+		size := binary.BigEndian.Uint32(blob)
+		if uint64(size) > uint64(len(blob)-4) {
+			return 0
+		}
+		fields = append(fields, blob[4:4+size])
+		blob = blob[4+size:]
+	}
+	// ssh-rsa is (type, e, n); ssh-dss is (type, p, q, g, y).
+	index := 2
+	if keyType == "ssh-dss" {
+		index = 1
+	}
+	if len(fields) <= index {
+		return 0
+	}
+	return new(big.Int).SetBytes(fields[index]).BitLen()
+}
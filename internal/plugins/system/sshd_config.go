@@ -0,0 +1,120 @@
+package system
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type sshdSetting struct {
+	Keyword string
+	Value   string
+	File    string
+	Line    int
+}
+
+type sshdMatch struct {
+	Criteria string
+	File     string
+	Line     int
+	Settings map[string]sshdSetting
+}
+
+// sshdConfig is a parsed sshd_config. As in sshd, the first value obtained
+// for a keyword wins; settings after a Match line apply to that block only.
+type sshdConfig struct {
+	Global  map[string]sshdSetting
+	Matches []*sshdMatch
+	Files   []string
+}
+
+// Get returns the effective global value of keyword, or def when unset.
+func (c *sshdConfig) Get(keyword, def string) (string, sshdSetting, bool) {
+	setting, ok := c.Global[strings.ToLower(keyword)]
+	if !ok {
+		return def, sshdSetting{Keyword: keyword, Value: def}, false
+	}
+	return setting.Value, setting, true
+}
+
+// parseSSHDConfig reads path and every file it includes. Include patterns are
+// globbed under root; relative ones are taken from /etc/ssh as sshd does. A
+// Match block opened in an included file ends with that file.
+func parseSSHDConfig(root, path string) (*sshdConfig, error) {
+	cfg := &sshdConfig{Global: map[string]sshdSetting{}}
+	var read func(path string, block *sshdMatch, depth int) error
+	read = func(path string, block *sshdMatch, depth int) error {
+		if depth > 16 {
+			return fmt.Errorf("%s: include depth exceeded", path)
+		}
+		cfg.Files = append(cfg.Files, path)
+		lineNo := 0
+		var includeErr error
+		err := readLines(path, func(line string) {
+			lineNo++
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || includeErr != nil {
+				return
+			}
+			keyword, value := splitSSHDLine(line)
+			switch strings.ToLower(keyword) {
+			case "match":
+				block = nil
+				if strings.ToLower(value) != "all" {
+					block = &sshdMatch{Criteria: value, File: path, Line: lineNo, Settings: map[string]sshdSetting{}}
+					cfg.Matches = append(cfg.Matches, block)
+				}
+			case "include":
+				for _, pattern := range strings.Fields(value) {
+					if !filepath.IsAbs(pattern) {
+						pattern = filepath.Join("/etc/ssh", pattern)
+					}
+					matches, err := filepath.Glob(filepath.Join(root, pattern))
+					if err != nil {
+						includeErr = fmt.Errorf("%s:%d: %w", path, lineNo, err)
+						return
+					}
+					sort.Strings(matches)
+					for _, match := range matches {
+						if err := read(match, block, depth+1); err != nil {
+							includeErr = err
+							return
+						}
+					}
+				}
+			default:
+				settings := cfg.Global
+				if block != nil {
+					settings = block.Settings
+				}
+				key := strings.ToLower(keyword)
+				if _, ok := settings[key]; !ok {
+					settings[key] = sshdSetting{Keyword: keyword, Value: value, File: path, Line: lineNo}
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return includeErr
+	}
+	if err := read(path, nil, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// splitSSHDLine separates a keyword from its arguments, which may be joined
+// by whitespace or a single "=".
+func splitSSHDLine(line string) (string, string) {
+	idx := strings.IndexAny(line, " \t=")
+	if idx < 0 {
+		return line, ""
+	}
+	value := strings.TrimSpace(line[idx+1:])
+	if line[idx] != '=' {
+		value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	}
+	return line[:idx], strings.Trim(value, `"`)
+}
@@ -0,0 +1,55 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func loadJSON(store storage.Store, bucket, key string, v interface{}) (bool, error) {
+	if store == nil {
+		return false, nil
+	}
+	raw, err := store.Get(bucket, key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, fmt.Errorf("decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func saveJSON(store storage.Store, bucket, key string, v interface{}) error {
+	if store == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s/%s: %w", bucket, key, err)
+	}
+	return store.Put(bucket, key, raw)
+}
+
+func clearBucket(store storage.Store, bucket string) error {
+	if store == nil {
+		return nil
+	}
+	keys := []string{}
+	if err := store.ForEach(bucket, func(key, _ []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/signatures"
+)
+
+// Vulnerabilities matches installed dpkg and rpm packages against the OSV,
+// GHSA and NVD data the signatures updater keeps in cache_dir, using CISA
+// KEV membership and EPSS scores to raise severity.
+type Vulnerabilities struct {
+	cacheDir      string
+	dpkgStatus    string
+	rpmManifest   string
+	osRelease     string
+	ecosystem     string
+	nvdMatching   string
+	epssThreshold float64
+	ignore        map[string]bool
+
+	db      *signatures.VulnDB
+	dbStamp string
+}
+
+func (v *Vulnerabilities) Name() string { return "system.vulnerabilities" }
+
+func (v *Vulnerabilities) Init(config map[string]interface{}) error {
+	root := "/"
+	if val, ok := config["root"].(string); ok && val != "" {
+		root = val
+	}
+	v.cacheDir = "/var/lib/arcsent/signatures"
+	v.dpkgStatus = filepath.Join(root, "var/lib/dpkg/status")
+	v.rpmManifest = ""
+	v.osRelease = filepath.Join(root, "etc/os-release")
+	v.ecosystem = ""
+	v.nvdMatching = "auto"
+	v.epssThreshold = 0.1
+	v.ignore = map[string]bool{}
+	v.db, v.dbStamp = nil, ""
+
+	if val, ok := config["cache_dir"].(string); ok && val != "" {
+		v.cacheDir = val
+	}
+	if val, ok := config["dpkg_status"].(string); ok && val != "" {
+		v.dpkgStatus = val
+	}
+	if val, ok := config["rpm_manifest"].(string); ok {
+		v.rpmManifest = val
+	}
+	if val, ok := config["ecosystem"].(string); ok {
+		v.ecosystem = val
+	}
+	switch val := config["nvd_matching"].(type) {
+	case bool:
+		v.nvdMatching = fmt.Sprint(val)
+	case string:
+		if val != "auto" && val != "true" && val != "false" {
+			return fmt.Errorf("nvd_matching must be true, false or \"auto\"")
+		}
+		v.nvdMatching = val
+	}
+	if val, ok := config["epss_threshold"].(float64); ok {
+		v.epssThreshold = val
+	}
+	if ids, ok := configStrings(config, "ignore"); ok {
+		for _, id := range ids {
+			v.ignore[id] = true
+		}
+	}
+	return nil
+}
+
+func (v *Vulnerabilities) Run(ctx context.Context) (*scanner.Result, error) {
+	result := &scanner.Result{
+		ScannerName: v.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": time.Now().Format(time.RFC3339),
+		},
+	}
+
+	packages, err := v.inventory()
+	if err != nil {
+		return nil, err
+	}
+	ecosystem := v.ecosystem
+	if ecosystem == "" {
+		if ecosystem, err = osEcosystem(v.osRelease); err != nil {
+			return nil, err
+		}
+	}
+	db, err := v.loadDB(ecosystem)
+	if err != nil {
+		return nil, err
+	}
+	if db.Advisories == 0 {
+		return nil, fmt.Errorf("no vulnerability data under %s; enable the osv or nvd signature source", v.cacheDir)
+	}
+	useNVD := v.nvdMatching == "true" || v.nvdMatching == "auto" && !db.HasEcosystem(ecosystem)
+
+	vulnerable, kevMatches := 0, 0
+	for _, pkg := range packages {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pkg.Ecosystem = ecosystem
+		findings := v.packageFindings(db, pkg, useNVD)
+		if len(findings) > 0 {
+			vulnerable++
+		}
+		for _, finding := range findings {
+			if finding.Evidence["kev"] == true {
+				kevMatches++
+			}
+		}
+		result.Findings = append(result.Findings, findings...)
+	}
+	result.Metadata["ecosystem"] = ecosystem
+	result.Metadata["packages"] = len(packages)
+	result.Metadata["advisories"] = db.Advisories
+	result.Metadata["feeds"] = db.Sources
+	result.Metadata["nvd_matching"] = useNVD
+	result.Metadata["vulnerable_packages"] = vulnerable
+	result.Metadata["kev_matches"] = kevMatches
+	if len(db.ParseErrors) > 0 {
+		result.Metadata["feed_errors"] = limitStrings(db.ParseErrors, 20)
+	}
+	return result, nil
+}
+
+func (v *Vulnerabilities) Halt(_ context.Context) error { return nil }
+
+func (v *Vulnerabilities) inventory() ([]signatures.InstalledPackage, error) {
+	var packages []signatures.InstalledPackage
+	dpkg, err := readDpkgStatus(v.dpkgStatus)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read dpkg status: %w", err)
+	}
+	packages = append(packages, dpkg...)
+	if v.rpmManifest != "" {
+		rpm, err := readRPMPackages(v.rpmManifest)
+		if err != nil {
+			return nil, fmt.Errorf("read rpm manifest: %w", err)
+		}
+		packages = append(packages, rpm...)
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("no installed packages found in %s or rpm_manifest", v.dpkgStatus)
+	}
+	return packages, nil
+}
+
+// loadDB reuses the parsed feeds until a file in the cache changes.
+func (v *Vulnerabilities) loadDB(ecosystem string) (*signatures.VulnDB, error) {
+	stamp, err := signatures.FeedStamp(v.cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("stat signature cache: %w", err)
+	}
+	stamp = ecosystem + "|" + stamp
+	if v.db != nil && stamp == v.dbStamp {
+		return v.db, nil
+	}
+	db, err := signatures.LoadVulnDB(v.cacheDir, signatures.VulnOptions{Ecosystems: []string{ecosystem}})
+	if err != nil {
+		return nil, err
+	}
+	v.db, v.dbStamp = db, stamp
+	return db, nil
+}
+
+type vulnerabilityGroup struct {
+	advisories []string
+	cves       []string
+	fixed      string
+	summary    string
+	severity   string
+	cvss       float64
+	upstream   bool
+	vendors    []string
+}
+
+// packageFindings reports one finding per CVE (or advisory without one), so
+// a Debian DSA and the CVE record it fixes are not counted twice.
+func (v *Vulnerabilities) packageFindings(db *signatures.VulnDB, pkg signatures.InstalledPackage, useNVD bool) []scanner.Finding {
+	groups := map[string]*vulnerabilityGroup{}
+	for _, match := range db.Match(pkg, useNVD) {
+		advisory := match.Advisory
+		cves := advisory.CVEs()
+		if v.ignore[advisory.ID] || anyIgnored(v.ignore, cves) {
+			continue
+		}
+		key := advisory.ID
+		if len(cves) > 0 {
+			key = cves[0]
+		}
+		group := groups[key]
+		if group == nil {
+			group = &vulnerabilityGroup{upstream: true}
+			groups[key] = group
+		}
+		group.advisories = appendUnique(group.advisories, advisory.ID)
+		for _, cve := range cves {
+			group.cves = appendUnique(group.cves, cve)
+		}
+		if match.Fixed != "" && (group.fixed == "" || signatures.CompareVersions(pkg.Format, match.Fixed, group.fixed) > 0) {
+			group.fixed = match.Fixed
+		}
+		if group.summary == "" {
+			group.summary = advisory.Summary
+		}
+		if severityRank(advisory.Severity) > severityRank(group.severity) {
+			group.severity = advisory.Severity
+		}
+		if advisory.CVSS > group.cvss {
+			group.cvss = advisory.CVSS
+		}
+		group.upstream = group.upstream && match.Upstream
+		if match.Vendor != "" {
+			group.vendors = appendUnique(group.vendors, match.Vendor)
+		}
+	}
+
+	findings := []scanner.Finding{}
+	for _, key := range sortedKeys(groups) {
+		group := groups[key]
+		evidence := map[string]interface{}{
+			"package":    pkg.Name,
+			"version":    pkg.Version,
+			"ecosystem":  pkg.Ecosystem,
+			"advisories": group.advisories,
+			"cves":       group.cves,
+			"kev":        false,
+			"match":      "distribution",
+		}
+		if pkg.Source != "" && pkg.Source != pkg.Name {
+			evidence["source_package"] = pkg.Source
+		}
+		if group.upstream {
+			evidence["match"] = "nvd_cpe"
+			evidence["cpe_vendors"] = group.vendors
+		}
+		if group.fixed != "" {
+			evidence["fixed_version"] = group.fixed
+		}
+		if group.summary != "" {
+			evidence["summary"] = group.summary
+		}
+		severity := group.severity
+		var epss signatures.EPSSScore
+		for _, cve := range group.cves {
+			if score, ok := db.CVSS(cve); ok && score > group.cvss {
+				group.cvss = score
+			}
+			if kev, ok := db.KEV(cve); ok {
+				evidence["kev"] = true
+				evidence["kev_date_added"] = kev.DateAdded
+				if kev.Ransomware {
+					evidence["ransomware"] = true
+				}
+			}
+			if score, ok := db.EPSS(cve); ok && score.Score > epss.Score {
+				epss = score
+			}
+		}
+		if group.cvss > 0 {
+			evidence["cvss"] = group.cvss
+			severity = cvssSeverity(group.cvss)
+		}
+		level := vulnerabilitySeverity(severity)
+		if epss.Score > 0 {
+			evidence["epss"] = epss.Score
+			evidence["epss_percentile"] = epss.Percentile
+			if epss.Score >= v.epssThreshold {
+				level = raiseSeverity(level)
+			}
+		}
+		if evidence["kev"] == true {
+			level = scanner.SeverityCritical
+		}
+		remediation := fmt.Sprintf("Upgrade %s to %s or later.", pkg.Name, group.fixed)
+		if group.fixed == "" {
+			remediation = fmt.Sprintf("No fixed %s version is published; remove the package or apply the advisory's mitigation.", pkg.Name)
+		}
+		findings = append(findings, scanner.Finding{
+			ID:          "package_vulnerable",
+			Severity:    level,
+			Category:    "vulnerability",
+			Description: fmt.Sprintf("%s %s is affected by %s", pkg.Name, pkg.Version, key),
+			Evidence:    evidence,
+			Remediation: remediation,
+		})
+	}
+	return findings
+}
+
+func anyIgnored(ignore map[string]bool, ids []string) bool {
+	for _, id := range ids {
+		if ignore[id] {
+			return true
+		}
+	}
+	return false
+}
+
+func cvssSeverity(score float64) string {
+	switch {
+	case score >= 9:
+		return "critical"
+	case score >= 7:
+		return "high"
+	case score >= 4:
+		return "medium"
+	}
+	return "low"
+}
+
+var severityOrder = []scanner.Severity{
+	scanner.SeverityLow,
+	scanner.SeverityMedium,
+	scanner.SeverityHigh,
+	scanner.SeverityCritical,
+}
+
+func severityRank(label string) int {
+	return map[string]int{"low": 1, "medium": 2, "high": 3, "critical": 4}[label]
+}
+
+// vulnerabilitySeverity maps a feed label to a finding severity; advisories
+// without one default to medium.
+func vulnerabilitySeverity(label string) scanner.Severity {
+	switch strings.ToLower(label) {
+	case "critical":
+		return scanner.SeverityCritical
+	case "high":
+		return scanner.SeverityHigh
+	case "low":
+		return scanner.SeverityLow
+	}
+	return scanner.SeverityMedium
+}
+
+func raiseSeverity(severity scanner.Severity) scanner.Severity {
+	for i, level := range severityOrder[:len(severityOrder)-1] {
+		if level == severity {
+			return severityOrder[i+1]
+		}
+	}
+	return severity
+}
@@ -0,0 +1,157 @@
+package system
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+)
+
+func TestVulnerabilities(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return path
+	}
+	write("root/etc/os-release", "PRETTY_NAME=\"Debian GNU/Linux 12 (bookworm)\"\nID=debian\nVERSION_ID=\"12\"\n")
+	write("root/var/lib/dpkg/status", `Package: libssl3
+Status: install ok installed
+Source: openssl
+Version: 3.0.11-1~deb12u1
+
+Package: curl
+Status: install ok installed
+Version: 7.88.1-10+deb12u5
+
+Package: bash
+Status: install ok installed
+Version: 5.2.15-2+b2
+
+Package: removed
+Status: deinstall ok config-files
+Version: 1.0-1
+`)
+
+	// OSV records arrive as a zip of JSON files, as in the Debian all.zip export.
+	if err := os.MkdirAll(filepath.Join(dir, "cache/osv"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	zipFile, err := os.Create(filepath.Join(dir, "cache/osv/all.zip"))
+	if err != nil {
+		t.Fatalf("create zip: %v", err)
+	}
+	zw := zip.NewWriter(zipFile)
+	for name, content := range map[string]string{
+		"DEBIAN-CVE-2024-0727.json": `{"id":"DEBIAN-CVE-2024-0727","upstream":["CVE-2024-0727"],"summary":"PKCS12 NULL dereference",
+			"affected":[{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.0.13-1~deb12u1"}]}],"ecosystem_specific":{"urgency":"low"}}]}`,
+		"DSA-5621-1.json": `{"id":"DSA-5621-1","upstream":["CVE-2024-0727"],
+			"affected":[{"package":{"ecosystem":"Debian:12","name":"openssl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"3.0.13-1~deb12u1"}]}]}]}`,
+		"DEBIAN-CVE-2023-38545.json": `{"id":"DEBIAN-CVE-2023-38545","upstream":["CVE-2023-38545"],
+			"affected":[{"package":{"ecosystem":"Debian:12","name":"curl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"7.88.1-10+deb12u4"}]}]}]}`,
+		"DEBIAN-CVE-2024-2398.json": `{"id":"DEBIAN-CVE-2024-2398","upstream":["CVE-2024-2398"],
+			"affected":[{"package":{"ecosystem":"Debian:12","name":"curl"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"},{"fixed":"7.88.1-10+deb12u6"}]}]}]}`,
+		"DEBIAN-CVE-2099-0001.json": `{"id":"DEBIAN-CVE-2099-0001","upstream":["CVE-2099-0001"],
+			"affected":[{"package":{"ecosystem":"Debian:11","name":"bash"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"0"}]}]}]}`,
+	} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("zip: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("zip write: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip close: %v", err)
+	}
+	zipFile.Close()
+
+	if err := os.MkdirAll(filepath.Join(dir, "cache/nvd"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	nvd, err := os.Create(filepath.Join(dir, "cache/nvd/nvdcve-2.0-recent.json.gz"))
+	if err != nil {
+		t.Fatalf("create nvd: %v", err)
+	}
+	gz := gzip.NewWriter(nvd)
+	gz.Write([]byte(`{"vulnerabilities":[{"cve":{"id":"CVE-2022-3715","metrics":{"cvssMetricV31":[{"cvssData":{"baseScore":7.8,"baseSeverity":"HIGH"}}]},
+		"configurations":[{"nodes":[{"cpeMatch":[{"vulnerable":true,"criteria":"cpe:2.3:a:gnu:bash:*:*:*:*:*:*:*:*","versionEndExcluding":"5.3"}]}]}]}}]}`))
+	gz.Close()
+	nvd.Close()
+	write("cache/cisa_kev/known_exploited_vulnerabilities.json", `{"vulnerabilities":[{"cveID":"CVE-2024-0727","vulnerabilityName":"OpenSSL PKCS12","dateAdded":"2024-06-01","knownRansomwareCampaignUse":"Unknown"}]}`)
+	write("cache/epss/epss_scores-current.csv", "#model_version:v2023.03.01,score_date:2024-06-01T00:00:00+0000\ncve,epss,percentile\nCVE-2022-3715,0.35,0.97\n")
+
+	plugin := &Vulnerabilities{}
+	config := map[string]interface{}{
+		"root":      filepath.Join(dir, "root"),
+		"cache_dir": filepath.Join(dir, "cache"),
+	}
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	got := []string{}
+	for _, finding := range result.Findings {
+		got = append(got, string(finding.Severity)+":"+finding.Description+":"+finding.Evidence["fixed_version"].(string))
+	}
+	sort.Strings(got)
+	want := []string{
+		"critical:libssl3 3.0.11-1~deb12u1 is affected by CVE-2024-0727:3.0.13-1~deb12u1",
+		"medium:curl 7.88.1-10+deb12u5 is affected by CVE-2024-2398:7.88.1-10+deb12u6",
+	}
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, finding := range result.Findings {
+		if finding.Evidence["package"] == "libssl3" {
+			if advisories := finding.Evidence["advisories"].([]string); len(advisories) != 2 || finding.Evidence["kev"] != true {
+				t.Fatalf("openssl evidence: %v", finding.Evidence)
+			}
+		}
+	}
+	if result.Metadata["ecosystem"] != "Debian:12" || result.Metadata["packages"] != 3 || result.Metadata["nvd_matching"] != false {
+		t.Fatalf("metadata: %v", result.Metadata)
+	}
+
+	config["nvd_matching"] = true
+	config["ignore"] = []interface{}{"CVE-2024-0727"}
+	if err := plugin.Init(config); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatalf("run with nvd: %v", err)
+	}
+	ids := []string{}
+	for _, finding := range result.Findings {
+		ids = append(ids, finding.Evidence["package"].(string)+":"+finding.Evidence["match"].(string))
+		if finding.Evidence["package"] == "bash" && finding.Severity != scanner.SeverityCritical {
+			t.Fatalf("bash severity %s, want critical (CVSS 7.8 raised by EPSS)", finding.Severity)
+		}
+		if finding.Evidence["package"] == "bash" && fmt.Sprint(finding.Evidence["cpe_vendors"]) != "[gnu]" {
+			t.Fatalf("bash cpe_vendors %v, want [gnu]", finding.Evidence["cpe_vendors"])
+		}
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != "bash:nvd_cpe,curl:distribution" {
+		t.Fatalf("nvd findings: %v", ids)
+	}
+}
@@ -0,0 +1,388 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/scanner"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	webPathTraversal    = "path_traversal"
+	webSQLInjection     = "sql_injection"
+	webCommandInjection = "command_injection"
+	webScannerAgent     = "scanner_agent"
+	webShellProbe       = "webshell_probe"
+	webErrorBurst       = "error_burst"
+	maxWebSamples       = 5
+)
+
+var webAttackPatterns = map[string]*regexp.Regexp{
+	webPathTraversal:    regexp.MustCompile(`(?:\.\.[/\\]|[/\\]\.\.$|/etc/(?:passwd|shadow|hosts)\b|/proc/self/(?:environ|cmdline)|\bwin\.ini\b|\bboot\.ini\b|\bfile://)`),
+	webSQLInjection:     regexp.MustCompile(`(?:\bunion(?:\s|/\*.*?\*/)+(?:all(?:\s|/\*.*?\*/)+)?select\b|'\s*(?:or|and)\s+'?\w+'?\s*=\s*'?\w|\bor\s+1\s*=\s*1\b|\b(?:sleep|benchmark|pg_sleep|extractvalue|updatexml|load_file)\s*\(|\bwaitfor\s+delay\b|\binformation_schema\b|\binto\s+(?:out|dump)file\b|;\s*(?:drop|delete|insert|update)\s|'\s*(?:--|#))`),
+	webCommandInjection: regexp.MustCompile("(?:(?:;|\\||&&|\\$\\(|`)\\s*(?:cat|id|whoami|uname|wget|curl|nc|ncat|bash|sh|python\\d?|perl|ping|nslookup|echo)\\b|/bin/(?:ba)?sh\\b|\\$\\{ifs\\}|\\$\\{jndi:|\\(\\)\\s*\\{\\s*:;\\s*\\};)"),
+}
+
+// The user agent is only checked for header-borne exploits: Log4Shell and
+// Shellshock.
+var webAgentInjection = regexp.MustCompile(`(?i)\$\{jndi:|\(\)\s*\{\s*:;\s*\};`)
+
+var defaultScannerAgents = []string{
+	"sqlmap", "nikto", "nmap", "masscan", "zgrab", "nuclei", "wpscan", "dirbuster", "gobuster",
+	"ffuf", "feroxbuster", "acunetix", "nessus", "openvas", "havij", "w3af", "zmeu", "whatweb",
+	"jaeles", "netsparker", "appscan", "burpcollaborator", "wfuzz", "dirb/",
+}
+
+var defaultWebshellPaths = []string{
+	"c99.php", "c100.php", "r57.php", "wso*.php", "b374k*.php", "alfa*.php", "shell.php", "cmd.php",
+	"webshell*.php", "indoxploit*.php", "priv8*.php", "eval-stdin.php", "shell.jsp", "cmd.jsp",
+	"shell.aspx", "cmd.aspx", "cmd.asp",
+}
+
+// WebAccessLog tails nginx and Apache access logs and reports attack probes
+// aggregated per client address.
+type WebAccessLog struct {
+	paths          []string
+	format         string
+	maxLines       int
+	scannerAgents  []string
+	webshellPaths  globSet
+	ignoreSources  []*net.IPNet
+	errorThreshold int
+	window         time.Duration
+	maxSources     int
+	store          storage.Store
+}
+
+// webHits aggregates the requests of one client for one category.
+type webHits struct {
+	Count    int
+	Success  int
+	Statuses map[int]int
+	Samples  []string
+	Agents   []string
+	Logs     []string
+	First    time.Time
+	Last     time.Time
+}
+
+func (w *WebAccessLog) Name() string { return "system.web_access_log" }
+
+func (w *WebAccessLog) WithStore(store storage.Store) {
+	w.store = store
+}
+
+func (w *WebAccessLog) Init(config map[string]interface{}) error {
+	w.paths = []string{"/var/log/nginx/access.log", "/var/log/apache2/access.log", "/var/log/httpd/access_log"}
+	if v, ok := configStrings(config, "paths"); ok && len(v) > 0 {
+		w.paths = v
+	}
+	w.format = "auto"
+	if v, ok := config["format"].(string); ok && v != "" {
+		w.format = v
+	}
+	switch w.format {
+	case "auto", "combined", "json":
+	default:
+		return fmt.Errorf("format must be one of: auto, combined, json")
+	}
+	w.maxLines = 1000
+	if v, ok := config["max_lines"].(float64); ok && v > 0 {
+		w.maxLines = int(v)
+	}
+	agents := defaultScannerAgents
+	if v, ok := configStrings(config, "scanner_agents"); ok {
+		agents = v
+	}
+	w.scannerAgents = make([]string, 0, len(agents))
+	for _, agent := range agents {
+		w.scannerAgents = append(w.scannerAgents, strings.ToLower(agent))
+	}
+	webshells := defaultWebshellPaths
+	if v, ok := configStrings(config, "webshell_paths"); ok {
+		webshells = v
+	}
+	globs, err := compileGlobs(webshells)
+	if err != nil {
+		return fmt.Errorf("webshell_paths: %w", err)
+	}
+	w.webshellPaths = globs
+	w.ignoreSources = nil
+	ignore, _ := configStrings(config, "ignore_sources")
+	for _, raw := range ignore {
+		if !strings.Contains(raw, "/") {
+			if strings.Contains(raw, ":") {
+				raw += "/128"
+			} else {
+				raw += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(raw)
+		if err != nil {
+			return fmt.Errorf("ignore_sources: %w", err)
+		}
+		w.ignoreSources = append(w.ignoreSources, network)
+	}
+	w.errorThreshold = 50
+	if v, ok := config["error_threshold"].(float64); ok && v > 0 {
+		w.errorThreshold = int(v)
+	}
+	w.window = 5 * time.Minute
+	if v, ok := config["window"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("window: invalid duration %q", v)
+		}
+		w.window = d
+	}
+	w.maxSources = 100
+	if v, ok := config["max_sources"].(float64); ok && v > 0 {
+		w.maxSources = int(v)
+	}
+	return nil
+}
+
+// Run reads the lines appended to each log since the previous run and
+// reports one finding per client and category. Error bursts are counted
+// within window over the lines read in this run.
+func (w *WebAccessLog) Run(_ context.Context) (*scanner.Result, error) {
+	now := time.Now()
+	result := &scanner.Result{
+		ScannerName: w.Name(),
+		Status:      scanner.StatusSuccess,
+		Metadata: map[string]interface{}{
+			"timestamp": now.Format(time.RFC3339),
+		},
+	}
+
+	hits := map[string]map[string]*webHits{}
+	errorTimes := map[string][]time.Time{}
+	bursts := map[string]int{}
+	record := func(category, source, log string, request webRequest) {
+		if hits[category] == nil {
+			hits[category] = map[string]*webHits{}
+		}
+		entry := hits[category][source]
+		if entry == nil {
+			entry = &webHits{Statuses: map[int]int{}, First: request.Time}
+			hits[category][source] = entry
+		}
+		entry.Count++
+		entry.Statuses[request.Status]++
+		if request.Status >= 200 && request.Status < 300 {
+			entry.Success++
+		}
+		if len(entry.Samples) < maxWebSamples {
+			entry.Samples = append(entry.Samples, request.Line())
+		}
+		if request.UserAgent != "" && len(entry.Agents) < maxWebSamples {
+			entry.Agents = appendUnique(entry.Agents, request.UserAgent)
+		}
+		entry.Logs = appendUnique(entry.Logs, log)
+		entry.Last = request.Time
+	}
+
+	logs, missing := w.logFiles()
+	lines, parsed, unparsed := 0, 0, 0
+	for _, path := range logs {
+		stats, err := tailLog(w.store, path, w.maxLines, func(line string) {
+			request, ok := parseWebRequest(line, w.format)
+			if !ok {
+				if strings.TrimSpace(line) != "" {
+					unparsed++
+				}
+				return
+			}
+			parsed++
+			if request.Time.IsZero() {
+				request.Time = now
+			}
+			if w.ignored(request.Source) {
+				return
+			}
+			for _, category := range w.classify(request) {
+				record(category, request.Source, path, request)
+			}
+			if request.Status >= 400 {
+				record(webErrorBurst, request.Source, path, request)
+				recent := append(errorTimes[request.Source], request.Time)
+				cutoff := request.Time.Add(-w.window)
+				for len(recent) > 0 && recent[0].Before(cutoff) {
+					recent = recent[1:]
+				}
+				errorTimes[request.Source] = recent
+				if len(recent) > bursts[request.Source] {
+					bursts[request.Source] = len(recent)
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		lines += stats.Lines
+	}
+
+	for source := range hits[webErrorBurst] {
+		if bursts[source] < w.errorThreshold {
+			delete(hits[webErrorBurst], source)
+		}
+	}
+	for _, category := range []string{webPathTraversal, webSQLInjection, webCommandInjection, webShellProbe, webScannerAgent, webErrorBurst} {
+		sources := hits[category]
+		result.Metadata[category] = len(sources)
+		for _, source := range w.topSources(sources) {
+			result.Findings = append(result.Findings, w.finding(category, source, sources[source], bursts[source]))
+		}
+	}
+	result.Metadata["paths"] = logs
+	if len(missing) > 0 {
+		result.Metadata["missing_paths"] = missing
+	}
+	result.Metadata["lines_scanned"] = lines
+	result.Metadata["requests_parsed"] = parsed
+	result.Metadata["lines_unparsed"] = unparsed
+	return result, nil
+}
+
+func (w *WebAccessLog) Halt(_ context.Context) error { return nil }
+
+// logFiles expands the configured paths, which may be globs. Logs that do
+// not exist are skipped so one config covers nginx and Apache hosts.
+func (w *WebAccessLog) logFiles() ([]string, []string) {
+	logs, missing := []string{}, []string{}
+	for _, pattern := range w.paths {
+		matches, _ := filepath.Glob(pattern)
+		if len(matches) == 0 {
+			missing = append(missing, pattern)
+			continue
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				logs = appendUnique(logs, match)
+			}
+		}
+	}
+	return logs, missing
+}
+
+func (w *WebAccessLog) ignored(source string) bool {
+	ip := net.ParseIP(source)
+	if ip == nil {
+		return false
+	}
+	for _, network := range w.ignoreSources {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the attack categories a request falls into.
+func (w *WebAccessLog) classify(request webRequest) []string {
+	categories := []string{}
+	decoded := request.Decoded()
+	raw := strings.ToLower(request.Target)
+	for _, category := range []string{webPathTraversal, webSQLInjection, webCommandInjection} {
+		pattern := webAttackPatterns[category]
+		if pattern.MatchString(decoded) || pattern.MatchString(raw) {
+			categories = append(categories, category)
+		}
+	}
+	if webAgentInjection.MatchString(request.UserAgent) {
+		categories = appendUnique(categories, webCommandInjection)
+	}
+	if path := request.Path(); path != "" && w.webshellPaths.Match(path) {
+		categories = append(categories, webShellProbe)
+	}
+	agent := strings.ToLower(request.UserAgent)
+	for _, name := range w.scannerAgents {
+		if name != "" && strings.Contains(agent, name) {
+			categories = append(categories, webScannerAgent)
+			break
+		}
+	}
+	return categories
+}
+
+// topSources returns the clients with the most hits, at most maxSources.
+func (w *WebAccessLog) topSources(sources map[string]*webHits) []string {
+	keys := sortedKeys(sources)
+	sort.SliceStable(keys, func(i, j int) bool { return sources[keys[i]].Count > sources[keys[j]].Count })
+	if len(keys) > w.maxSources {
+		keys = keys[:w.maxSources]
+	}
+	return keys
+}
+
+func (w *WebAccessLog) finding(category, source string, entry *webHits, burst int) scanner.Finding {
+	statuses := map[string]int{}
+	for status, count := range entry.Statuses {
+		statuses[strconv.Itoa(status)] = count
+	}
+	evidence := map[string]interface{}{
+		"source_ip":  source,
+		"requests":   entry.Count,
+		"successful": entry.Success,
+		"statuses":   statuses,
+		"samples":    entry.Samples,
+		"logs":       entry.Logs,
+		"first_seen": entry.First.UTC().Format(time.RFC3339),
+		"last_seen":  entry.Last.UTC().Format(time.RFC3339),
+	}
+	if len(entry.Agents) > 0 {
+		evidence["user_agents"] = entry.Agents
+	}
+
+	// Probes that were answered with a 2xx may have worked.
+	severity := scanner.SeverityMedium
+	if entry.Success > 0 {
+		severity = scanner.SeverityHigh
+	}
+	finding := scanner.Finding{
+		ID:       "web_" + category,
+		Severity: severity,
+		Category: "web",
+		Evidence: evidence,
+	}
+	switch category {
+	case webPathTraversal:
+		finding.Description = fmt.Sprintf("%s sent %d path traversal requests", source, entry.Count)
+		finding.Remediation = "Block the client and check that the application never serves files outside its document root."
+	case webSQLInjection:
+		finding.Description = fmt.Sprintf("%s sent %d SQL injection requests", source, entry.Count)
+		finding.Remediation = "Block the client and review the targeted endpoints for unparameterized queries."
+	case webCommandInjection:
+		finding.Description = fmt.Sprintf("%s sent %d command injection requests", source, entry.Count)
+		finding.Remediation = "Block the client and check the targeted endpoints and processes spawned by the web server."
+	case webShellProbe:
+		finding.Description = fmt.Sprintf("%s requested known web shell paths %d times", source, entry.Count)
+		// Web shell scans are constant background noise; a 2xx means the
+		// file exists.
+		finding.Severity = scanner.SeverityLow
+		if entry.Success > 0 {
+			finding.Severity = scanner.SeverityCritical
+		}
+		finding.Remediation = "Confirm the requested files do not exist under the document root and remove any that do."
+	case webScannerAgent:
+		finding.Description = fmt.Sprintf("%s sent %d requests with a vulnerability scanner user agent", source, entry.Count)
+		finding.Severity = scanner.SeverityLow
+		finding.Remediation = "Block the client unless the scan was authorized."
+	case webErrorBurst:
+		evidence["errors_in_window"] = burst
+		evidence["window"] = w.window.String()
+		finding.Description = fmt.Sprintf("%s caused %d error responses within %s", source, burst, w.window)
+		finding.Severity = scanner.SeverityMedium
+		finding.Remediation = "Check whether the client is scanning or brute forcing and rate limit or block it."
+	}
+	return finding
+}
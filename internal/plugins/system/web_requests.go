@@ -0,0 +1,190 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webRequest is one access log entry, from the combined format or JSON.
+type webRequest struct {
+	Time      time.Time
+	Source    string
+	Method    string
+	Target    string
+	Status    int
+	UserAgent string
+}
+
+// combinedPattern matches the common and combined formats of nginx and
+// Apache, optionally prefixed by the vhost as in vhost_combined.
+var combinedPattern = regexp.MustCompile(`^(?:\S+:\d+ )?(\S+) \S+ \S+ \[([^\]]+)\] "((?:[^"\\]|\\.)*)" (\d{3}) \S+(?: "(?:[^"\\]|\\.)*" "((?:[^"\\]|\\.)*)")?`)
+
+const combinedTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	jsonSourceFields = []string{"remote_addr", "client_ip", "remote_ip", "clientip", "client", "ip"}
+	jsonMethodFields = []string{"request_method", "method"}
+	jsonTargetFields = []string{"request_uri", "uri", "path", "url"}
+	jsonAgentFields  = []string{"http_user_agent", "user_agent", "useragent", "agent"}
+	jsonTimeFields   = []string{"time_iso8601", "time_local", "@timestamp", "timestamp", "time", "msec"}
+)
+
+// parseWebRequest parses a combined or JSON access log line. format is
+// "auto", "combined" or "json".
+func parseWebRequest(line, format string) (webRequest, bool) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case format == "json" || (format == "auto" && strings.HasPrefix(trimmed, "{")):
+		return parseJSONRequest(trimmed)
+	default:
+		return parseCombinedRequest(trimmed)
+	}
+}
+
+func parseCombinedRequest(line string) (webRequest, bool) {
+	m := combinedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return webRequest{}, false
+	}
+	request := webRequest{Source: m[1], UserAgent: unescapeLogQuotes(m[5])}
+	request.Time, _ = time.Parse(combinedTimeLayout, m[2])
+	request.Status, _ = strconv.Atoi(m[4])
+	request.Method, request.Target = splitRequestLine(unescapeLogQuotes(m[3]))
+	return request, true
+}
+
+func parseJSONRequest(line string) (webRequest, bool) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return webRequest{}, false
+	}
+	request := webRequest{
+		Source:    jsonField(fields, jsonSourceFields),
+		Method:    jsonField(fields, jsonMethodFields),
+		Target:    jsonField(fields, jsonTargetFields),
+		UserAgent: jsonField(fields, jsonAgentFields),
+	}
+	if line := jsonField(fields, []string{"request"}); line != "" && (request.Method == "" || request.Target == "") {
+		request.Method, request.Target = splitRequestLine(line)
+	}
+	request.Status, _ = strconv.Atoi(jsonField(fields, []string{"status", "status_code"}))
+	request.Time = parseLogTime(jsonField(fields, jsonTimeFields))
+	if request.Source == "" || (request.Target == "" && request.Method == "") {
+		return webRequest{}, false
+	}
+	return request, true
+}
+
+func jsonField(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		switch v := fields[name].(type) {
+		case string:
+			if v != "" && v != "-" {
+				return v
+			}
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// parseLogTime accepts RFC 3339, the combined layout and nginx's $msec.
+func parseLogTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t
+	}
+	if t, err := time.Parse(combinedTimeLayout, value); err == nil {
+		return t
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.UnixMilli(int64(seconds * 1000))
+	}
+	return time.Time{}
+}
+
+// splitRequestLine splits "GET /path HTTP/1.1". Garbage sent instead of a
+// request line (TLS handshakes, binary probes) is kept whole as the target.
+func splitRequestLine(line string) (string, string) {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && len(fields) <= 3 {
+		return fields[0], fields[1]
+	}
+	return "", line
+}
+
+func unescapeLogQuotes(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(value)
+}
+
+// Path returns the decoded, lower-cased path without the query string.
+func (r webRequest) Path() string {
+	path, _, _ := strings.Cut(r.Target, "?")
+	return strings.ToLower(decodeRequestTarget(path))
+}
+
+// Decoded returns the whole target URL-decoded twice (double encoding is a
+// common filter bypass) and lower-cased, with + read as a space.
+func (r webRequest) Decoded() string {
+	return strings.ToLower(decodeRequestTarget(strings.ReplaceAll(r.Target, "+", " ")))
+}
+
+func decodeRequestTarget(target string) string {
+	for i := 0; i < 2; i++ {
+		decoded := percentDecode(target)
+		if decoded == target {
+			break
+		}
+		target = decoded
+	}
+	return target
+}
+
+// percentDecode decodes every valid %XX escape and keeps malformed ones as
+// they are. url.PathUnescape rejects the whole string instead, so a single
+// stray "%zz" would hide the rest of an encoded payload.
+func percentDecode(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// Line renders the request for evidence.
+func (r webRequest) Line() string {
+	if r.Method == "" {
+		return fmt.Sprintf("%q %d", r.Target, r.Status)
+	}
+	return fmt.Sprintf("%s %s %d", r.Method, r.Target, r.Status)
+}
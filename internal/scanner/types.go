@@ -12,6 +12,19 @@ type Plugin interface {
 	Halt(ctx context.Context) error
 }
 
+// Baseliner is implemented by plugins that compare against a persisted
+// baseline and support accepting the current state as the new baseline.
+type Baseliner interface {
+	AcceptBaseline(ctx context.Context) error
+}
+
+// Streamer is implemented by plugins that can publish results between
+// scheduled runs, e.g. from filesystem notifications. Stream blocks until ctx
+// is cancelled.
+type Streamer interface {
+	Stream(ctx context.Context, emit func(Result)) error
+}
+
 type Status string
 
 const (
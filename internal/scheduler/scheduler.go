@@ -53,6 +53,12 @@ func (s *Scheduler) SetOnResult(fn func(scanner.Result)) {
 	s.onResult = fn
 }
 
+func (s *Scheduler) Emit(result scanner.Result) {
+	if s.onResult != nil {
+		s.onResult(result)
+	}
+}
+
 func (s *Scheduler) ListJobs() []JobConfig {
 	s.mu.Lock()
 	defer s.mu.Unlock()
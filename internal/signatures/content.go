@@ -0,0 +1,547 @@
+package signatures
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ContentRule is a YARA-like rule: named text, hex or regex strings combined
+// by a boolean condition, optionally limited to files of a given size range
+// or starting with given magic bytes.
+type ContentRule struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description,omitempty"`
+	Severity    string          `json:"severity,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	Paths       []string        `json:"paths,omitempty"`
+	MinSize     int64           `json:"min_size,omitempty"`
+	MaxSize     int64           `json:"max_size,omitempty"`
+	Magic       []string        `json:"magic,omitempty"`
+	Strings     []ContentString `json:"strings"`
+	Condition   string          `json:"condition,omitempty"`
+}
+
+// ContentString is one pattern. Exactly one of Text, Hex or Regex is set;
+// hex strings may use ?? for any byte.
+type ContentString struct {
+	ID     string `json:"id"`
+	Text   string `json:"text,omitempty"`
+	Hex    string `json:"hex,omitempty"`
+	Regex  string `json:"regex,omitempty"`
+	NoCase bool   `json:"nocase,omitempty"`
+	Wide   bool   `json:"wide,omitempty"`
+}
+
+// CompiledRule is a ContentRule ready to evaluate.
+type CompiledRule struct {
+	ContentRule
+	magic     [][]byte
+	matchers  []contentMatcher
+	condition condition
+}
+
+// ContentMatch lists the strings that matched and their first offsets.
+type ContentMatch struct {
+	Strings []string         `json:"strings"`
+	Offsets map[string]int64 `json:"offsets"`
+}
+
+type contentMatcher struct {
+	id     string
+	find   func(data, lower []byte) int
+	nocase bool
+}
+
+type condition func(matched map[string]bool) bool
+
+// ParseContentRules reads a rule pack: a JSON array of rules or an object
+// with a "rules" array.
+func ParseContentRules(r io.Reader) ([]ContentRule, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	var rules []ContentRule
+	if bytes.HasPrefix(raw, []byte("[")) {
+		err = json.Unmarshal(raw, &rules)
+	} else {
+		var pack struct {
+			Rules []ContentRule `json:"rules"`
+		}
+		err = json.Unmarshal(raw, &pack)
+		rules = pack.Rules
+	}
+	return rules, err
+}
+
+// LoadContentRules compiles every rule pack under dir (plain, gzipped or
+// zipped JSON, whatever the file is called, since downloads keep the URL's
+// name). Packs or rules that fail to parse are reported in problems and
+// skipped; the first definition of a rule ID wins.
+func LoadContentRules(dir string) ([]*CompiledRule, []string, error) {
+	var rules []*CompiledRule
+	var problems []string
+	seen := map[string]bool{}
+	_, err := walkFeedFiles(dir, func(name string, r io.Reader) error {
+		parsed, err := ParseContentRules(r)
+		if err != nil {
+			return err
+		}
+		for _, rule := range parsed {
+			if seen[rule.ID] {
+				problems = append(problems, fmt.Sprintf("%s: duplicate rule %s", name, rule.ID))
+				continue
+			}
+			compiled, err := CompileContentRule(rule)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			seen[rule.ID] = true
+			rules = append(rules, compiled)
+		}
+		return nil
+	}, func(err error) {
+		problems = append(problems, err.Error())
+	})
+	return rules, problems, err
+}
+
+// CompileContentRule validates a rule and compiles its strings and
+// condition. An empty condition means "any of them".
+func CompileContentRule(rule ContentRule) (*CompiledRule, error) {
+	if rule.ID == "" {
+		return nil, fmt.Errorf("rule without id")
+	}
+	switch rule.Severity {
+	case "", "info", "low", "medium", "high", "critical":
+	default:
+		return nil, fmt.Errorf("rule %s: unknown severity %q", rule.ID, rule.Severity)
+	}
+	compiled := &CompiledRule{ContentRule: rule}
+	for _, magic := range rule.Magic {
+		b, err := hex.DecodeString(strings.ReplaceAll(magic, " ", ""))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("rule %s: invalid magic %q", rule.ID, magic)
+		}
+		compiled.magic = append(compiled.magic, b)
+	}
+	ids := map[string]bool{}
+	for _, str := range rule.Strings {
+		if !strings.HasPrefix(str.ID, "$") || len(str.ID) < 2 || ids[str.ID] {
+			return nil, fmt.Errorf("rule %s: string ids must be unique and start with $ (got %q)", rule.ID, str.ID)
+		}
+		ids[str.ID] = true
+		matcher, err := compileContentString(str)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s: %w", rule.ID, str.ID, err)
+		}
+		compiled.matchers = append(compiled.matchers, matcher)
+	}
+	if len(compiled.matchers) == 0 && len(compiled.magic) == 0 {
+		return nil, fmt.Errorf("rule %s: needs strings or magic", rule.ID)
+	}
+	expr := strings.TrimSpace(rule.Condition)
+	if expr == "" {
+		expr = "any of them"
+		if len(compiled.matchers) == 0 {
+			expr = "true"
+		}
+	}
+	cond, err := parseCondition(expr, compiled.stringIDs())
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: condition: %w", rule.ID, err)
+	}
+	compiled.condition = cond
+	return compiled, nil
+}
+
+func (r *CompiledRule) stringIDs() []string {
+	ids := make([]string, 0, len(r.matchers))
+	for _, m := range r.matchers {
+		ids = append(ids, m.id)
+	}
+	return ids
+}
+
+// Wants reports whether a file of this size and leading bytes can match, so
+// callers can skip reading files no rule applies to.
+func (r *CompiledRule) Wants(size int64, head []byte) bool {
+	if size < r.MinSize || (r.MaxSize > 0 && size > r.MaxSize) {
+		return false
+	}
+	if len(r.magic) == 0 {
+		return true
+	}
+	for _, magic := range r.magic {
+		if bytes.HasPrefix(head, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match evaluates the rule against file content. lower is the ASCII
+// lower-cased content for nocase strings and may be nil when no rule uses
+// one; see LowerASCII.
+func (r *CompiledRule) Match(data, lower []byte) (ContentMatch, bool) {
+	if !r.Wants(int64(len(data)), data) {
+		return ContentMatch{}, false
+	}
+	result := ContentMatch{Strings: []string{}, Offsets: map[string]int64{}}
+	matched := map[string]bool{}
+	for _, m := range r.matchers {
+		if offset := m.find(data, lower); offset >= 0 {
+			matched[m.id] = true
+			result.Strings = append(result.Strings, m.id)
+			result.Offsets[m.id] = int64(offset)
+		}
+	}
+	if !r.condition(matched) {
+		return ContentMatch{}, false
+	}
+	return result, true
+}
+
+// UsesNoCase reports whether any string needs lower-cased content.
+func (r *CompiledRule) UsesNoCase() bool {
+	for _, m := range r.matchers {
+		if m.nocase {
+			return true
+		}
+	}
+	return false
+}
+
+// LowerASCII lower-cases ASCII letters only, keeping offsets aligned with
+// the original bytes.
+func LowerASCII(data []byte) []byte {
+	out := make([]byte, len(data))
+	for i, c := range data {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		out[i] = c
+	}
+	return out
+}
+
+func compileContentString(str ContentString) (contentMatcher, error) {
+	matcher := contentMatcher{id: str.ID, nocase: str.NoCase}
+	set := 0
+	for _, v := range []string{str.Text, str.Hex, str.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return matcher, fmt.Errorf("exactly one of text, hex or regex is required")
+	}
+	switch {
+	case str.Text != "":
+		needle := []byte(str.Text)
+		if str.NoCase {
+			needle = LowerASCII(needle)
+		}
+		if str.Wide {
+			wide := make([]byte, 0, len(needle)*2)
+			for _, c := range needle {
+				wide = append(wide, c, 0)
+			}
+			needle = wide
+		}
+		matcher.find = func(data, lower []byte) int {
+			if str.NoCase {
+				return bytes.Index(lower, needle)
+			}
+			return bytes.Index(data, needle)
+		}
+		matcher.nocase = str.NoCase
+	case str.Hex != "":
+		pattern, err := parseHexPattern(str.Hex)
+		if err != nil {
+			return matcher, err
+		}
+		matcher.find = func(data, _ []byte) int { return findHexPattern(data, pattern) }
+		matcher.nocase = false
+	default:
+		expr := str.Regex
+		if str.NoCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return matcher, err
+		}
+		matcher.find = func(data, _ []byte) int {
+			if loc := re.FindIndex(data); loc != nil {
+				return loc[0]
+			}
+			return -1
+		}
+		matcher.nocase = false
+	}
+	return matcher, nil
+}
+
+// parseHexPattern turns "4d 5a ?? 00" into byte values, with -1 for a
+// wildcard.
+func parseHexPattern(s string) ([]int, error) {
+	s = strings.Join(strings.Fields(s), "")
+	if len(s) == 0 || len(s)%2 != 0 {
+		return nil, fmt.Errorf("hex string must have an even number of digits")
+	}
+	pattern := make([]int, 0, len(s)/2)
+	concrete := false
+	for i := 0; i < len(s); i += 2 {
+		pair := s[i : i+2]
+		if pair == "??" {
+			pattern = append(pattern, -1)
+			continue
+		}
+		v, err := strconv.ParseUint(pair, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex byte %q", pair)
+		}
+		pattern = append(pattern, int(v))
+		concrete = true
+	}
+	if !concrete {
+		return nil, fmt.Errorf("hex string needs at least one fixed byte")
+	}
+	return pattern, nil
+}
+
+func findHexPattern(data []byte, pattern []int) int {
+	anchor := 0
+	for pattern[anchor] < 0 {
+		anchor++
+	}
+	for start := 0; start+len(pattern) <= len(data); {
+		i := bytes.IndexByte(data[start+anchor:len(data)-len(pattern)+anchor+1], byte(pattern[anchor]))
+		if i < 0 {
+			return -1
+		}
+		pos := start + i
+		match := true
+		for j, b := range pattern {
+			if b >= 0 && data[pos+j] != byte(b) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return pos
+		}
+		start = pos + 1
+	}
+	return -1
+}
+
+// parseCondition compiles a boolean expression over string IDs:
+//
+//	$a and ($b or not $c)
+//	any of them | all of them | 2 of them
+//	any of ($a, $web*)
+//	true | false
+func parseCondition(expr string, ids []string) (condition, error) {
+	p := &conditionParser{tokens: tokenizeCondition(expr), ids: ids}
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return cond, nil
+}
+
+func tokenizeCondition(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t\n(),", rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+	ids    []string
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *conditionParser) or() (condition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]bool) bool { return l(m) || right(m) }
+	}
+	return left, nil
+}
+
+func (p *conditionParser) and() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(m map[string]bool) bool { return l(m) && right(m) }
+	}
+	return left, nil
+}
+
+func (p *conditionParser) unary() (condition, error) {
+	if p.peek() == "not" {
+		p.next()
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(m map[string]bool) bool { return !inner(m) }, nil
+	}
+	return p.primary()
+}
+
+func (p *conditionParser) primary() (condition, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case tok == "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	case tok == "true":
+		return func(map[string]bool) bool { return true }, nil
+	case tok == "false":
+		return func(map[string]bool) bool { return false }, nil
+	case strings.HasPrefix(tok, "$"):
+		if !containsString(p.ids, tok) {
+			return nil, fmt.Errorf("unknown string %s", tok)
+		}
+		return func(m map[string]bool) bool { return m[tok] }, nil
+	}
+	return p.quantifier(tok)
+}
+
+// quantifier parses "<any|all|N> of <them|(set)>".
+func (p *conditionParser) quantifier(tok string) (condition, error) {
+	need := -1
+	switch tok {
+	case "any":
+		need = 1
+	case "all":
+		need = 0
+	default:
+		n, err := strconv.Atoi(tok)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+		need = n
+	}
+	if p.next() != "of" {
+		return nil, fmt.Errorf("expected 'of' after %s", tok)
+	}
+	var set []string
+	if p.peek() == "them" {
+		p.next()
+		set = p.ids
+	} else {
+		if p.next() != "(" {
+			return nil, fmt.Errorf("expected 'them' or ( after 'of'")
+		}
+		for {
+			item := p.next()
+			if !strings.HasPrefix(item, "$") {
+				return nil, fmt.Errorf("expected string id in set, got %q", item)
+			}
+			before := len(set)
+			for _, id := range p.ids {
+				if id == item || strings.HasSuffix(item, "*") && strings.HasPrefix(id, strings.TrimSuffix(item, "*")) {
+					set = append(set, id)
+				}
+			}
+			if len(set) == before {
+				return nil, fmt.Errorf("%s matches no strings", item)
+			}
+			sep := p.next()
+			if sep == ")" {
+				break
+			}
+			if sep != "," {
+				return nil, fmt.Errorf("expected , or ) in set")
+			}
+		}
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("%s of an empty set", tok)
+	}
+	if need == 0 {
+		need = len(set)
+	}
+	if need > len(set) {
+		return nil, fmt.Errorf("%d of a set of %d strings can never match", need, len(set))
+	}
+	return func(m map[string]bool) bool {
+		count := 0
+		for _, id := range set {
+			if m[id] {
+				count++
+			}
+		}
+		return count >= need
+	}, nil
+}
@@ -0,0 +1,58 @@
+package signatures
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentRules(t *testing.T) {
+	rule, err := CompileContentRule(ContentRule{
+		ID:    "elf_miner",
+		Magic: []string{"7f454c46"},
+		Strings: []ContentString{
+			{ID: "$pool", Text: "STRATUM+TCP://", NoCase: true},
+			{ID: "$wallet", Regex: `4[0-9AB][1-9A-HJ-NP-Za-km-z]{93}`},
+			{ID: "$code", Hex: "48 8b ?? 24 ?? c3"},
+			{ID: "$name", Text: "xmrig", Wide: true},
+		},
+		Condition: "$pool and (1 of ($wallet, $code) or not $name)",
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	data := append([]byte("\x7fELF\x02\x01"), []byte("..stratum+tcp://pool:3333..\x48\x8b\x44\x24\x08\xc3")...)
+	match, ok := rule.Match(data, LowerASCII(data))
+	if !ok || len(match.Strings) != 2 || match.Offsets["$code"] != int64(len(data)-6) {
+		t.Fatalf("match: %+v %v", match, ok)
+	}
+	if _, ok := rule.Match(data[4:], LowerASCII(data[4:])); ok {
+		t.Fatalf("matched without ELF magic")
+	}
+	wide := append(append([]byte{}, data[:len(data)-6]...), []byte("x\x00m\x00r\x00i\x00g\x00")...)
+	if _, ok := rule.Match(wide, LowerASCII(wide)); ok {
+		t.Fatalf("matched although wide $name excludes it")
+	}
+
+	for _, bad := range []ContentRule{
+		{ID: "no_strings"},
+		{ID: "unknown", Strings: []ContentString{{ID: "$a", Text: "x"}}, Condition: "$b"},
+		{ID: "too_many", Strings: []ContentString{{ID: "$a", Text: "x"}}, Condition: "2 of them"},
+		{ID: "wildcard_hex", Strings: []ContentString{{ID: "$a", Hex: "?? ??"}}},
+		{ID: "two_kinds", Strings: []ContentString{{ID: "$a", Text: "x", Regex: "y"}}},
+		{ID: "dangling", Strings: []ContentString{{ID: "$a", Text: "x"}}, Condition: "$a and"},
+	} {
+		if _, err := CompileContentRule(bad); err == nil {
+			t.Fatalf("%s: expected compile error", bad.ID)
+		}
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "pack.json"), []byte(`{"rules":[{"id":"a","strings":[{"id":"$a","text":"x"}]},{"id":"a","strings":[{"id":"$a","text":"y"}]},{"id":"b","strings":[]}]}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "latest.data"), []byte(`[{"id":"c","strings":[{"id":"$c","text":"z"}]}]`), 0o644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("not a pack"), 0o644)
+	rules, problems, err := LoadContentRules(dir)
+	if err != nil || len(rules) != 2 || len(problems) != 3 {
+		t.Fatalf("load: %d rules, problems %v, err %v", len(rules), problems, err)
+	}
+}
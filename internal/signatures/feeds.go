@@ -0,0 +1,93 @@
+package signatures
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkFeedFiles calls fn for every data file under dir, decompressing .gz
+// files and iterating the members of .zip archives. A file or member that
+// cannot be read or parsed is passed to onErr and skipped. found is false
+// when the directory does not exist.
+func walkFeedFiles(dir string, fn func(name string, r io.Reader) error, onErr func(error)) (bool, error) {
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			onErr(err)
+			return nil
+		}
+		name := d.Name()
+		if d.IsDir() || strings.HasPrefix(name, "download-") || strings.HasPrefix(name, ".") {
+			return nil
+		}
+		found = true
+		switch {
+		case strings.HasSuffix(name, ".zip"):
+			readZipFeed(path, fn, onErr)
+		case strings.HasSuffix(name, ".gz"):
+			if err := readGzipFeed(path, fn); err != nil {
+				onErr(fmt.Errorf("%s: %w", path, err))
+			}
+		default:
+			file, err := os.Open(path)
+			if err != nil {
+				onErr(err)
+				return nil
+			}
+			defer file.Close()
+			if err := fn(name, file); err != nil {
+				onErr(fmt.Errorf("%s: %w", path, err))
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func readZipFeed(path string, fn func(name string, r io.Reader) error, onErr func(error)) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		onErr(fmt.Errorf("%s: %w", path, err))
+		return
+	}
+	defer archive.Close()
+	for _, member := range archive.File {
+		if member.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := member.Open()
+		if err == nil {
+			err = fn(member.Name, rc)
+			rc.Close()
+		}
+		if err != nil {
+			onErr(fmt.Errorf("%s: %s: %w", path, member.Name, err))
+		}
+	}
+}
+
+func readGzipFeed(path string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	return fn(strings.TrimSuffix(filepath.Base(path), ".gz"), gz)
+}
@@ -0,0 +1,370 @@
+package signatures
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+const (
+	indexBucketPrefix = "signatures_index_"
+	maxParseErrors    = 20
+)
+
+var indexedSources = []string{SourceMITREATTACK, SourceCISAKEV, SourceEPSS, SourceOSV, SourceGHSA, SourceNVD, SourceExploitDB, SourceContentRules}
+
+var techniqueID = regexp.MustCompile(`^T\d{4}(\.\d{3})?$`)
+
+// IndexStats describes one source's index rebuild.
+type IndexStats struct {
+	Version     string
+	Records     int
+	ParseErrors []string
+}
+
+// PackageAdvisory is one advisory's view of a package, as stored under the
+// package key.
+type PackageAdvisory struct {
+	Source    string         `json:"source"`
+	ID        string         `json:"id"`
+	CVEs      []string       `json:"cves,omitempty"`
+	Ecosystem string         `json:"ecosystem,omitempty"`
+	Vendor    string         `json:"vendor,omitempty"`
+	Name      string         `json:"name"`
+	Ranges    []VersionRange `json:"ranges,omitempty"`
+	Versions  []string       `json:"versions,omitempty"`
+	Upstream  bool           `json:"upstream,omitempty"`
+}
+
+// CVEInfo merges what every indexed source knows about one CVE.
+type CVEInfo struct {
+	ID         string     `json:"id"`
+	Summary    string     `json:"summary,omitempty"`
+	CVSS       float64    `json:"cvss,omitempty"`
+	Severity   string     `json:"severity,omitempty"`
+	KEV        *KEVEntry  `json:"kev,omitempty"`
+	EPSS       *EPSSScore `json:"epss,omitempty"`
+	Advisories []Advisory `json:"advisories,omitempty"`
+	Exploits   []Exploit  `json:"exploits,omitempty"`
+	Sources    []string   `json:"sources"`
+}
+
+// LookupResult answers a lookup; Kind says which field is set.
+type LookupResult struct {
+	Query     string            `json:"query"`
+	Kind      string            `json:"kind"`
+	CVE       *CVEInfo          `json:"cve,omitempty"`
+	Technique *Technique        `json:"technique,omitempty"`
+	Advisory  *Advisory         `json:"advisory,omitempty"`
+	Exploit   *Exploit          `json:"exploit,omitempty"`
+	Packages  []PackageAdvisory `json:"packages,omitempty"`
+}
+
+// Indexable reports whether RebuildIndex understands the source.
+func Indexable(source string) bool {
+	for _, id := range indexedSources {
+		if id == source {
+			return true
+		}
+	}
+	return false
+}
+
+// RebuildIndex parses the files downloaded for source under dir and replaces
+// that source's index. Records are keyed cve/<CVE>, technique/<ID>,
+// package/<name>, advisory/<ID> and exploit/<EDB-ID> within a bucket per
+// source, so each source can be rebuilt without touching the others.
+func (s *Store) RebuildIndex(source, dir string) (IndexStats, error) {
+	stats := IndexStats{}
+	records := map[string]interface{}{}
+	addError := func(err error) {
+		stats.ParseErrors = append(stats.ParseErrors, err.Error())
+	}
+	var err error
+	switch source {
+	case SourceMITREATTACK:
+		_, err = walkFeedFiles(dir, func(_ string, r io.Reader) error {
+			techniques, version, err := parseAttack(r)
+			if err != nil {
+				return err
+			}
+			stats.Version = version
+			for _, technique := range techniques {
+				records["technique/"+technique.ID] = technique
+				stats.Records++
+			}
+			return nil
+		}, addError)
+	case SourceExploitDB:
+		refs := map[string][]string{}
+		_, err = walkFeedFiles(dir, func(_ string, r io.Reader) error {
+			exploits, err := parseExploitDB(r)
+			for _, exploit := range exploits {
+				records["exploit/"+exploit.ID] = exploit
+				for _, cve := range exploit.CVEs {
+					refs[cve] = append(refs[cve], exploit.ID)
+				}
+				stats.Records++
+			}
+			return err
+		}, addError)
+		for cve, ids := range refs {
+			records["cve/"+cve] = ids
+		}
+	case SourceOSV, SourceGHSA, SourceNVD, SourceCISAKEV, SourceEPSS:
+		db := newVulnDB()
+		_, err = db.loadSource(source, dir, VulnOptions{})
+		stats.ParseErrors = append(stats.ParseErrors, db.ParseErrors...)
+		stats.Version = db.versions[source]
+		switch source {
+		case SourceCISAKEV:
+			for cve, entry := range db.kev {
+				records["cve/"+cve] = entry
+			}
+			stats.Records = len(db.kev)
+		case SourceEPSS:
+			for cve, score := range db.epss {
+				records["cve/"+cve] = score
+			}
+			stats.Records = len(db.epss)
+		default:
+			indexAdvisories(source, db.advisories, records)
+			stats.Records = len(db.advisories)
+		}
+	case SourceContentRules:
+		// Rule packs are read straight from the cache by the plugin; indexing
+		// only validates them so broken packs show up in the updater status.
+		rules, problems, err := LoadContentRules(dir)
+		if err != nil {
+			return stats, err
+		}
+		stats.Records, stats.ParseErrors = len(rules), capParseErrors(problems)
+		if stats.Records == 0 && len(problems) > 0 {
+			return stats, fmt.Errorf("no valid rules in %s", dir)
+		}
+		return stats, nil
+	default:
+		return stats, fmt.Errorf("no index parser for source %s", source)
+	}
+	if err != nil {
+		return stats, err
+	}
+	if stats.Records == 0 && len(stats.ParseErrors) > 0 {
+		return stats, fmt.Errorf("no records parsed; keeping the previous index")
+	}
+	stats.ParseErrors = capParseErrors(stats.ParseErrors)
+	return stats, s.replaceBucket(indexBucketPrefix+source, records)
+}
+
+func capParseErrors(problems []string) []string {
+	if len(problems) <= maxParseErrors {
+		return problems
+	}
+	more := len(problems) - maxParseErrors
+	return append(problems[:maxParseErrors], fmt.Sprintf("... and %d more", more))
+}
+
+func indexAdvisories(source string, advisories []*Advisory, records map[string]interface{}) {
+	cveRefs := map[string][]string{}
+	packages := map[string][]PackageAdvisory{}
+	for _, advisory := range advisories {
+		records["advisory/"+advisory.ID] = advisory
+		cves := advisory.CVEs()
+		for _, cve := range cves {
+			cveRefs[cve] = append(cveRefs[cve], advisory.ID)
+		}
+		for _, affected := range advisory.Affected {
+			key := packageKey(affected.Name)
+			packages[key] = append(packages[key], PackageAdvisory{
+				Source:    source,
+				ID:        advisory.ID,
+				CVEs:      cves,
+				Ecosystem: affected.Ecosystem,
+				Vendor:    affected.Vendor,
+				Name:      affected.Name,
+				Ranges:    affected.Ranges,
+				Versions:  affected.Versions,
+				Upstream:  affected.Upstream,
+			})
+		}
+	}
+	for cve, ids := range cveRefs {
+		records["cve/"+cve] = ids
+	}
+	for key, list := range packages {
+		records["package/"+key] = list
+	}
+}
+
+// replaceBucket swaps the bucket contents. New records are written first
+// (in bulk when the store supports it) and only then are keys missing from
+// them deleted, so a failed write leaves the previous index readable rather
+// than an empty bucket.
+func (s *Store) replaceBucket(bucket string, records map[string]interface{}) error {
+	encoded := make(map[string][]byte, len(records))
+	for key, record := range records {
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", key, err)
+		}
+		encoded[key] = raw
+	}
+	if bulk, ok := s.store.(storage.BulkStore); ok {
+		if err := bulk.PutMany(bucket, encoded); err != nil {
+			return fmt.Errorf("write %s: %w", bucket, err)
+		}
+	} else {
+		for key, raw := range encoded {
+			if err := s.store.Put(bucket, key, raw); err != nil {
+				return err
+			}
+		}
+	}
+	var stale []string
+	if err := s.store.ForEach(bucket, func(key, _ []byte) error {
+		if _, ok := encoded[string(key)]; !ok {
+			stale = append(stale, string(key))
+		}
+		return nil
+	}); err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	for _, key := range stale {
+		if err := s.store.Delete(bucket, key); err != nil {
+			return fmt.Errorf("prune %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// Lookup resolves a CVE, ATT&CK technique, Exploit-DB ID, advisory ID or
+// package name against the index. storage.ErrNotFound means no source
+// knows the query.
+func (s *Store) Lookup(query string) (LookupResult, error) {
+	query = strings.TrimSpace(query)
+	upper := strings.ToUpper(query)
+	result := LookupResult{Query: query}
+	switch {
+	case strings.HasPrefix(upper, "CVE-"):
+		info, err := s.lookupCVE(upper)
+		if err != nil {
+			return result, err
+		}
+		result.Kind, result.CVE = "cve", info
+		return result, nil
+	case techniqueID.MatchString(upper):
+		var technique Technique
+		if err := s.getIndexed(SourceMITREATTACK, "technique/"+upper, &technique); err != nil {
+			return result, err
+		}
+		result.Kind, result.Technique = "technique", &technique
+		return result, nil
+	case strings.HasPrefix(upper, "EDB-"):
+		var exploit Exploit
+		if err := s.getIndexed(SourceExploitDB, "exploit/"+upper, &exploit); err != nil {
+			return result, err
+		}
+		result.Kind, result.Exploit = "exploit", &exploit
+		return result, nil
+	}
+	for _, source := range []string{SourceOSV, SourceGHSA, SourceNVD} {
+		var advisory Advisory
+		err := s.getIndexed(source, "advisory/"+query, &advisory)
+		if err == nil {
+			result.Kind, result.Advisory = "advisory", &advisory
+			return result, nil
+		}
+		if err != storage.ErrNotFound {
+			return result, err
+		}
+	}
+	for _, source := range []string{SourceOSV, SourceGHSA, SourceNVD} {
+		var list []PackageAdvisory
+		err := s.getIndexed(source, "package/"+packageKey(query), &list)
+		if err != nil && err != storage.ErrNotFound {
+			return result, err
+		}
+		result.Packages = append(result.Packages, list...)
+	}
+	if len(result.Packages) == 0 {
+		return result, storage.ErrNotFound
+	}
+	result.Kind = "package"
+	return result, nil
+}
+
+func (s *Store) lookupCVE(id string) (*CVEInfo, error) {
+	info := &CVEInfo{ID: id, Sources: []string{}}
+	found := func(source string) {
+		if !containsString(info.Sources, source) {
+			info.Sources = append(info.Sources, source)
+		}
+	}
+	for _, source := range []string{SourceNVD, SourceOSV, SourceGHSA} {
+		var ids []string
+		if err := s.getIndexed(source, "cve/"+id, &ids); err != nil {
+			if err == storage.ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+		for _, advisoryID := range ids {
+			var advisory Advisory
+			if err := s.getIndexed(source, "advisory/"+advisoryID, &advisory); err != nil {
+				continue
+			}
+			found(source)
+			if advisory.CVSS > info.CVSS {
+				info.CVSS, info.Severity = advisory.CVSS, advisory.Severity
+			}
+			if info.Summary == "" {
+				info.Summary = advisory.Summary
+			}
+			if info.Severity == "" {
+				info.Severity = advisory.Severity
+			}
+			info.Advisories = append(info.Advisories, advisory)
+		}
+	}
+	var kev KEVEntry
+	if err := s.getIndexed(SourceCISAKEV, "cve/"+id, &kev); err == nil {
+		info.KEV = &kev
+		found(SourceCISAKEV)
+	}
+	var epss EPSSScore
+	if err := s.getIndexed(SourceEPSS, "cve/"+id, &epss); err == nil {
+		info.EPSS = &epss
+		found(SourceEPSS)
+	}
+	var exploitIDs []string
+	if err := s.getIndexed(SourceExploitDB, "cve/"+id, &exploitIDs); err == nil {
+		sort.Strings(exploitIDs)
+		for _, exploitID := range exploitIDs {
+			var exploit Exploit
+			if err := s.getIndexed(SourceExploitDB, "exploit/"+exploitID, &exploit); err == nil {
+				info.Exploits = append(info.Exploits, exploit)
+			}
+		}
+		found(SourceExploitDB)
+	}
+	if len(info.Sources) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return info, nil
+}
+
+func (s *Store) getIndexed(source, key string, v interface{}) error {
+	raw, err := s.store.Get(indexBucketPrefix+source, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("decode %s %s: %w", source, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package signatures
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipsix/arcsent/internal/logging"
+	"github.com/ipsix/arcsent/internal/storage"
+)
+
+func TestIndexLookup(t *testing.T) {
+	cache := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(cache, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	write("mitre_attack/enterprise-attack.json", `{"type":"bundle","spec_version":"2.0","objects":[
+		{"type":"x-mitre-collection","x_mitre_version":"15.1"},
+		{"type":"attack-pattern","name":"Unix Shell","x_mitre_is_subtechnique":true,"x_mitre_platforms":["Linux","macOS"],
+		 "description":"Adversaries may abuse Unix shells.\n\nMore detail.",
+		 "kill_chain_phases":[{"kill_chain_name":"mitre-attack","phase_name":"execution"}],
+		 "external_references":[{"source_name":"mitre-attack","external_id":"T1059.004","url":"https://attack.mitre.org/techniques/T1059/004"}]},
+		{"type":"attack-pattern","name":"Old","revoked":true,"external_references":[{"source_name":"mitre-attack","external_id":"T9999"}]}]}`)
+	write("cisa_kev/known_exploited_vulnerabilities.json", `{"catalogVersion":"2024.06.01","vulnerabilities":[{"cveID":"CVE-2021-44228","vulnerabilityName":"Log4Shell","dateAdded":"2021-12-10","knownRansomwareCampaignUse":"Known"}]}`)
+	write("epss/epss_scores-current.csv", "#model_version:v2023.03.01,score_date:2024-06-01T00:00:00+0000\ncve,epss,percentile\nCVE-2021-44228,0.97,1.0\n")
+	write("osv/GHSA-jfh8-c2jp-5v3q.json", `{"id":"GHSA-jfh8-c2jp-5v3q","modified":"2024-05-01T00:00:00Z","aliases":["CVE-2021-44228"],"summary":"Remote code injection in Log4j",
+		"database_specific":{"severity":"CRITICAL"},
+		"affected":[{"package":{"ecosystem":"Maven","name":"org.apache.logging.log4j:log4j-core"},"ranges":[{"type":"ECOSYSTEM","events":[{"introduced":"2.0-beta9"},{"fixed":"2.15.0"}]}]}]}`)
+	write("osv/broken.json", `{"id":`)
+	write("exploit_db/files_exploits.csv", "id,file,description,date_published,author,type,platform,port,date_added,date_updated,verified,codes\n"+
+		"50592,exploits/java/remote/50592.py,\"Apache Log4j 2 - Remote Code Execution (RCE)\",2021-12-14,kozmer,remote,java,,2021-12-14,2021-12-14,0,CVE-2021-44228\n")
+
+	store, err := storage.NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	index := NewStore(store)
+	for _, source := range []string{SourceMITREATTACK, SourceCISAKEV, SourceEPSS, SourceOSV, SourceExploitDB} {
+		stats, err := index.RebuildIndex(source, filepath.Join(cache, source))
+		if err != nil {
+			t.Fatalf("%s: rebuild: %v", source, err)
+		}
+		if stats.Records != 1 {
+			t.Fatalf("%s: expected 1 record, got %+v", source, stats)
+		}
+		switch source {
+		case SourceMITREATTACK:
+			if stats.Version != "15.1" {
+				t.Fatalf("attack version %q", stats.Version)
+			}
+		case SourceOSV:
+			if len(stats.ParseErrors) != 1 || stats.Version != "2024-05-01T00:00:00Z" {
+				t.Fatalf("osv stats %+v", stats)
+			}
+		}
+	}
+
+	result, err := index.Lookup("cve-2021-44228")
+	if err != nil {
+		t.Fatalf("lookup cve: %v", err)
+	}
+	info := result.CVE
+	if result.Kind != "cve" || info == nil || info.KEV == nil || !info.KEV.Ransomware || info.EPSS == nil || info.EPSS.Score != 0.97 ||
+		len(info.Advisories) != 1 || len(info.Exploits) != 1 || info.Severity != "critical" || len(info.Sources) != 4 {
+		t.Fatalf("cve lookup: %+v", info)
+	}
+
+	result, err = index.Lookup("T1059.004")
+	if err != nil || result.Technique == nil || result.Technique.Description != "Adversaries may abuse Unix shells." || result.Technique.Tactics[0] != "execution" {
+		t.Fatalf("technique lookup: %+v %v", result.Technique, err)
+	}
+	if _, err := index.Lookup("T9999"); err != storage.ErrNotFound {
+		t.Fatalf("revoked technique: %v", err)
+	}
+	result, err = index.Lookup("org.apache.logging.log4j:log4j-core")
+	if err != nil || result.Kind != "package" || len(result.Packages) != 1 || result.Packages[0].Ranges[0].Fixed != "2.15.0" {
+		t.Fatalf("package lookup: %+v %v", result, err)
+	}
+	result, err = index.Lookup("GHSA-jfh8-c2jp-5v3q")
+	if err != nil || result.Kind != "advisory" {
+		t.Fatalf("advisory lookup: %+v %v", result, err)
+	}
+
+	// A rebuild replaces the source's previous records.
+	write("cisa_kev/known_exploited_vulnerabilities.json", `{"catalogVersion":"2024.06.02","vulnerabilities":[{"cveID":"CVE-2024-3400","vulnerabilityName":"PAN-OS"}]}`)
+	if _, err := index.RebuildIndex(SourceCISAKEV, filepath.Join(cache, SourceCISAKEV)); err != nil {
+		t.Fatalf("rebuild kev: %v", err)
+	}
+	if result, err := index.Lookup("CVE-2021-44228"); err != nil || result.CVE.KEV != nil {
+		t.Fatalf("stale kev entry: %+v %v", result.CVE, err)
+	}
+	if _, err := index.Lookup("CVE-2024-3400"); err != nil {
+		t.Fatalf("new kev entry: %v", err)
+	}
+
+	// A failed write keeps the previous records.
+	write("cisa_kev/known_exploited_vulnerabilities.json", `{"catalogVersion":"2024.06.03","vulnerabilities":[{"cveID":"CVE-2024-6387","vulnerabilityName":"regreSSHion"}]}`)
+	failing := NewStore(failingBulkStore{store})
+	if _, err := failing.RebuildIndex(SourceCISAKEV, filepath.Join(cache, SourceCISAKEV)); err == nil {
+		t.Fatalf("expected the failed write to be reported")
+	}
+	if result, err := index.Lookup("CVE-2024-3400"); err != nil || result.CVE.KEV == nil {
+		t.Fatalf("kev entry lost after failed rebuild: %+v %v", result.CVE, err)
+	}
+
+	// The updater status keeps describing the records still in the index.
+	updater := NewUpdater(Config{}, failing, logging.New("text"))
+	last := SourceStatus{Version: "2024.06.02", Records: 1, IndexedAt: time.Unix(1717200000, 0)}
+	status := SourceStatus{Source: SourceCISAKEV}
+	updater.index(SourceCISAKEV, filepath.Join(cache, SourceCISAKEV), last, &status)
+	if status.Version != last.Version || status.Records != last.Records || !status.IndexedAt.Equal(last.IndexedAt) || len(status.ParseErrors) != 1 {
+		t.Fatalf("status after failed rebuild: %+v", status)
+	}
+}
+
+type failingBulkStore struct {
+	*storage.BadgerStore
+}
+
+func (failingBulkStore) PutMany(string, map[string][]byte) error {
+	return errors.New("disk full")
+}
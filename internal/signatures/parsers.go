@@ -0,0 +1,153 @@
+package signatures
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Technique is a MITRE ATT&CK technique or sub-technique.
+type Technique struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Tactics      []string `json:"tactics,omitempty"`
+	Platforms    []string `json:"platforms,omitempty"`
+	Description  string   `json:"description,omitempty"`
+	URL          string   `json:"url,omitempty"`
+	Subtechnique bool     `json:"subtechnique,omitempty"`
+}
+
+// Exploit is a public exploit listed in Exploit-DB.
+type Exploit struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Type        string   `json:"type,omitempty"`
+	Platform    string   `json:"platform,omitempty"`
+	Published   string   `json:"published,omitempty"`
+	File        string   `json:"file,omitempty"`
+	CVEs        []string `json:"cves,omitempty"`
+}
+
+// parseAttack reads an ATT&CK STIX bundle and returns its current
+// techniques along with the collection version. Revoked and deprecated
+// techniques are dropped.
+func parseAttack(r io.Reader) ([]Technique, string, error) {
+	var bundle struct {
+		SpecVersion string `json:"spec_version"`
+		Objects     []struct {
+			Type            string   `json:"type"`
+			Name            string   `json:"name"`
+			Description     string   `json:"description"`
+			Revoked         bool     `json:"revoked"`
+			Deprecated      bool     `json:"x_mitre_deprecated"`
+			Subtechnique    bool     `json:"x_mitre_is_subtechnique"`
+			Platforms       []string `json:"x_mitre_platforms"`
+			Version         string   `json:"x_mitre_version"`
+			KillChainPhases []struct {
+				KillChainName string `json:"kill_chain_name"`
+				PhaseName     string `json:"phase_name"`
+			} `json:"kill_chain_phases"`
+			ExternalReferences []struct {
+				SourceName string `json:"source_name"`
+				ExternalID string `json:"external_id"`
+				URL        string `json:"url"`
+			} `json:"external_references"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, "", err
+	}
+	version := bundle.SpecVersion
+	var techniques []Technique
+	for _, obj := range bundle.Objects {
+		if obj.Type == "x-mitre-collection" && obj.Version != "" {
+			version = obj.Version
+		}
+		if obj.Type != "attack-pattern" || obj.Revoked || obj.Deprecated {
+			continue
+		}
+		technique := Technique{
+			Name:         obj.Name,
+			Platforms:    obj.Platforms,
+			Description:  firstParagraph(obj.Description),
+			Subtechnique: obj.Subtechnique,
+		}
+		for _, ref := range obj.ExternalReferences {
+			if ref.SourceName == "mitre-attack" {
+				technique.ID, technique.URL = ref.ExternalID, ref.URL
+				break
+			}
+		}
+		if technique.ID == "" {
+			continue
+		}
+		for _, phase := range obj.KillChainPhases {
+			if phase.KillChainName == "mitre-attack" {
+				technique.Tactics = append(technique.Tactics, phase.PhaseName)
+			}
+		}
+		techniques = append(techniques, technique)
+	}
+	return techniques, version, nil
+}
+
+// parseExploitDB reads files_exploits.csv, taking CVE references from the
+// codes column.
+func parseExploitDB(r io.Reader) ([]Exploit, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"id", "description", "codes"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	var exploits []Exploit
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return exploits, err
+		}
+		exploit := Exploit{
+			ID:          "EDB-" + field(record, "id"),
+			Description: field(record, "description"),
+			Type:        field(record, "type"),
+			Platform:    field(record, "platform"),
+			Published:   field(record, "date_published"),
+			File:        field(record, "file"),
+		}
+		for _, code := range strings.Split(field(record, "codes"), ";") {
+			if code = strings.TrimSpace(code); strings.HasPrefix(code, "CVE-") {
+				exploit.CVEs = append(exploit.CVEs, code)
+			}
+		}
+		exploits = append(exploits, exploit)
+	}
+	return exploits, nil
+}
+
+func firstParagraph(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "\n\n"); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
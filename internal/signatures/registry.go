@@ -11,18 +11,21 @@ const (
 	SourceExploitDB   = "exploit_db"
 	SourceEPSS        = "epss"
 	SourceGHSA        = "ghsa"
+	// SourceContentRules carries rule packs for the system.content_scan plugin.
+	SourceContentRules = "content_rules"
 )
 
 var knownSources = map[string]struct{}{
-	SourceMITREATTACK: {},
-	SourceMITRECAPEC:  {},
-	SourceMITRECWE:    {},
-	SourceNVD:         {},
-	SourceOSV:         {},
-	SourceCISAKEV:     {},
-	SourceExploitDB:   {},
-	SourceEPSS:        {},
-	SourceGHSA:        {},
+	SourceMITREATTACK:  {},
+	SourceMITRECAPEC:   {},
+	SourceMITRECWE:     {},
+	SourceNVD:          {},
+	SourceOSV:          {},
+	SourceCISAKEV:      {},
+	SourceExploitDB:    {},
+	SourceEPSS:         {},
+	SourceGHSA:         {},
+	SourceContentRules: {},
 }
 
 // DefaultSources returns the default set of public sources available for opt-in.
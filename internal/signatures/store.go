@@ -22,13 +22,17 @@ type Status struct {
 }
 
 type SourceStatus struct {
-	Source    string    `json:"source"`
-	URL       string    `json:"url"`
-	Path      string    `json:"path"`
-	Bytes     int64     `json:"bytes"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Duration  string    `json:"duration"`
-	Error     string    `json:"error"`
+	Source      string    `json:"source"`
+	URL         string    `json:"url"`
+	Path        string    `json:"path"`
+	Bytes       int64     `json:"bytes"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Duration    string    `json:"duration"`
+	Error       string    `json:"error"`
+	Version     string    `json:"version,omitempty"`
+	Records     int       `json:"records"`
+	IndexedAt   time.Time `json:"indexed_at,omitempty"`
+	ParseErrors []string  `json:"parse_errors,omitempty"`
 }
 
 type Store struct {
@@ -185,6 +185,11 @@ func (u *Updater) runOnce(ctx context.Context) (Status, error) {
 		status.NextRun = u.nextRun
 	}
 
+	previous := Status{}
+	if u.store != nil {
+		previous, _ = u.store.LoadStatus()
+	}
+
 	if cfg.AirgapImportPath != "" {
 		if err := importAirgap(cfg.AirgapImportPath, cfg.CacheDir); err != nil {
 			status.Sources["airgap"] = SourceStatus{
@@ -197,6 +202,14 @@ func (u *Updater) runOnce(ctx context.Context) (Status, error) {
 				Path:      cfg.CacheDir,
 				UpdatedAt: time.Now(),
 			}
+			for _, srcID := range cfg.Sources {
+				if !Indexable(srcID) {
+					continue
+				}
+				srcStatus := SourceStatus{Source: srcID, Path: filepath.Join(cfg.CacheDir, srcID)}
+				u.index(srcID, srcStatus.Path, previous.Sources[srcID], &srcStatus)
+				status.Sources[srcID] = srcStatus
+			}
 		}
 		if u.store != nil {
 			_ = u.store.SaveStatus(status)
@@ -218,9 +231,14 @@ func (u *Updater) runOnce(ctx context.Context) (Status, error) {
 		srcStatus, err := src.Update(ctx, srcDir, u.client)
 		if err != nil {
 			srcStatus.Error = err.Error()
+			// The index still holds the last good download.
+			if last, ok := previous.Sources[srcID]; ok {
+				srcStatus.Version, srcStatus.Records, srcStatus.IndexedAt = last.Version, last.Records, last.IndexedAt
+			}
 			u.logger.Warn("signatures update failed", logging.Field{Key: "source", Value: srcID}, logging.Field{Key: "error", Value: err.Error()})
 		} else {
-			u.logger.Info("signatures update complete", logging.Field{Key: "source", Value: srcID})
+			u.index(srcID, srcDir, previous.Sources[srcID], &srcStatus)
+			u.logger.Info("signatures update complete", logging.Field{Key: "source", Value: srcID}, logging.Field{Key: "records", Value: srcStatus.Records})
 		}
 		status.Sources[srcID] = srcStatus
 	}
@@ -232,6 +250,24 @@ func (u *Updater) runOnce(ctx context.Context) (Status, error) {
 	return status, nil
 }
 
+// index rebuilds the lookup index for a source and records the outcome on
+// its status. A failed rebuild leaves the previous records in place, so the
+// status keeps describing them.
+func (u *Updater) index(source, dir string, last SourceStatus, status *SourceStatus) {
+	if u.store == nil || !Indexable(source) {
+		return
+	}
+	stats, err := u.store.RebuildIndex(source, dir)
+	if err != nil {
+		status.Version, status.Records, status.IndexedAt = last.Version, last.Records, last.IndexedAt
+		status.ParseErrors = append(stats.ParseErrors, "index: "+err.Error())
+		u.logger.Warn("signatures index failed", logging.Field{Key: "source", Value: source}, logging.Field{Key: "error", Value: err.Error()})
+		return
+	}
+	status.Version, status.Records, status.ParseErrors = stats.Version, stats.Records, stats.ParseErrors
+	status.IndexedAt = time.Now()
+}
+
 func (u *Updater) UpdateConfig(cfg Config) {
 	u.cfgMu.Lock()
 	u.cfg = cfg
@@ -274,11 +310,12 @@ func defaultSourceURLs() map[string]string {
 		SourceCISAKEV:     "https://www.cisa.gov/sites/default/files/feeds/known_exploited_vulnerabilities.json",
 		SourceExploitDB:   "https://gitlab.com/exploit-database/exploitdb/-/raw/main/files_exploits.csv",
 		// Optional sources require override URLs or mirrors.
-		SourceOSV:        "",
-		SourceMITRECAPEC: "",
-		SourceMITRECWE:   "",
-		SourceEPSS:       "",
-		SourceGHSA:       "",
+		SourceOSV:          "",
+		SourceMITRECAPEC:   "",
+		SourceMITRECWE:     "",
+		SourceEPSS:         "",
+		SourceGHSA:         "",
+		SourceContentRules: "",
 	}
 }
 
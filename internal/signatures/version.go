@@ -0,0 +1,246 @@
+package signatures
+
+import "strings"
+
+// Version formats understood by CompareVersions.
+const (
+	VersionDpkg = "dpkg"
+	VersionRPM  = "rpm"
+)
+
+// CompareVersions orders two package versions using dpkg or rpm rules and
+// returns -1, 0 or 1. Unknown formats fall back to dpkg ordering, which also
+// works for plain dotted upstream versions.
+func CompareVersions(format, a, b string) int {
+	if format == VersionRPM {
+		return compareRPM(a, b)
+	}
+	return compareDpkg(a, b)
+}
+
+// UpstreamVersion strips the epoch and the distribution revision or release,
+// leaving the version the upstream project published.
+func UpstreamVersion(format, version string) string {
+	if i := strings.Index(version, ":"); i >= 0 {
+		version = version[i+1:]
+	}
+	if i := strings.LastIndex(version, "-"); i > 0 {
+		version = version[:i]
+	}
+	if format == VersionDpkg {
+		// Debian repacks carry +dfsg/+ds suffixes that upstream never used.
+		for _, suffix := range []string{"+dfsg", "+ds", "~dfsg"} {
+			if i := strings.Index(version, suffix); i > 0 {
+				version = version[:i]
+			}
+		}
+	}
+	return version
+}
+
+func splitEpoch(version string) (string, string) {
+	if i := strings.Index(version, ":"); i >= 0 {
+		return version[:i], version[i+1:]
+	}
+	return "0", version
+}
+
+func compareDpkg(a, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if c := compareNumeric(epochA, epochB); c != 0 {
+		return c
+	}
+	upA, revA := restA, ""
+	if i := strings.LastIndex(restA, "-"); i >= 0 {
+		upA, revA = restA[:i], restA[i+1:]
+	}
+	upB, revB := restB, ""
+	if i := strings.LastIndex(restB, "-"); i >= 0 {
+		upB, revB = restB[:i], restB[i+1:]
+	}
+	if c := dpkgVerrevcmp(upA, upB); c != 0 {
+		return c
+	}
+	return dpkgVerrevcmp(revA, revB)
+}
+
+// dpkgOrder ranks a character the way dpkg does: ~ sorts before everything,
+// including the end of the string, and letters sort before other symbols.
+func dpkgOrder(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return 0
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	case c != 0:
+		return int(c) + 256
+	}
+	return 0
+}
+
+func dpkgVerrevcmp(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		firstDiff := 0
+		for i < len(a) && !isDigit(a[i]) || j < len(b) && !isDigit(b[j]) {
+			var ca, cb byte
+			if i < len(a) {
+				ca = a[i]
+			}
+			if j < len(b) {
+				cb = b[j]
+			}
+			if oa, ob := dpkgOrder(ca), dpkgOrder(cb); oa != ob {
+				return sign(oa - ob)
+			}
+			i++
+			j++
+		}
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+func compareRPM(a, b string) int {
+	epochA, restA := splitEpoch(a)
+	epochB, restB := splitEpoch(b)
+	if c := compareNumeric(epochA, epochB); c != 0 {
+		return c
+	}
+	verA, relA, _ := strings.Cut(restA, "-")
+	verB, relB, _ := strings.Cut(restB, "-")
+	if c := rpmvercmp(verA, verB); c != 0 {
+		return c
+	}
+	if relA == "" || relB == "" {
+		return 0
+	}
+	return rpmvercmp(relA, relB)
+}
+
+// rpmvercmp follows rpm's segment comparison: numeric segments beat alpha
+// ones, ~ sorts before anything and ^ after the base version.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for {
+		a = strings.TrimLeftFunc(a, isRPMSeparator)
+		b = strings.TrimLeftFunc(b, isRPMSeparator)
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if a == "" {
+				return -1
+			}
+			if b == "" {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+		numeric := isDigit(a[0])
+		segA, restA := rpmSegment(a, numeric)
+		segB, restB := rpmSegment(b, numeric)
+		if segB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		var c int
+		if numeric {
+			c = compareNumeric(segA, segB)
+		} else {
+			c = strings.Compare(segA, segB)
+		}
+		if c != 0 {
+			return c
+		}
+		a, b = restA, restB
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	}
+	return 1
+}
+
+func rpmSegment(s string, numeric bool) (string, string) {
+	i := 0
+	for i < len(s) && (numeric && isDigit(s[i]) || !numeric && isAlpha(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isRPMSeparator(r rune) bool {
+	return !(r < 128 && (isDigit(byte(r)) || isAlpha(byte(r)))) && r != '~' && r != '^'
+}
+
+// compareNumeric compares digit strings of any length without overflow.
+func compareNumeric(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package signatures
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		format, a, b string
+		want         int
+	}{
+		{VersionDpkg, "1.0", "1.0", 0},
+		{VersionDpkg, "1.0~rc1", "1.0", -1},
+		{VersionDpkg, "1.0", "1.0+b1", -1},
+		{VersionDpkg, "1:0.9", "2.0", 1},
+		{VersionDpkg, "3.0.11-1~deb12u1", "3.0.11-1~deb12u2", -1},
+		{VersionDpkg, "3.0.11-1~deb12u2", "3.0.11-1", -1},
+		{VersionDpkg, "7.88.1-10+deb12u5", "7.88.1-10+deb12u12", -1},
+		{VersionDpkg, "1.2a", "1.2", 1},
+		{VersionRPM, "1.0-1.el9", "1.0-2.el9", -1},
+		{VersionRPM, "1:3.0.7-24.el9", "3.0.7-27.el9", 1},
+		{VersionRPM, "1.0~rc1", "1.0", -1},
+		{VersionRPM, "1.0^20240101", "1.0", 1},
+		{VersionRPM, "2.10", "2.9", 1},
+		{VersionRPM, "1.0a", "1.0.1", -1},
+		{VersionRPM, "5.14.0-362.8.1.el9_3", "5.14.0-362.13.1.el9_3", -1},
+	}
+	for _, tc := range cases {
+		if got := CompareVersions(tc.format, tc.a, tc.b); got != tc.want {
+			t.Fatalf("CompareVersions(%s, %q, %q) = %d, want %d", tc.format, tc.a, tc.b, got, tc.want)
+		}
+		if got := CompareVersions(tc.format, tc.b, tc.a); got != -tc.want {
+			t.Fatalf("CompareVersions(%s, %q, %q) = %d, want %d", tc.format, tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
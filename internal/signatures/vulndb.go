@@ -0,0 +1,663 @@
+package signatures
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Advisory is a vulnerability record from OSV, GHSA or NVD reduced to what
+// package matching needs.
+type Advisory struct {
+	ID       string            `json:"id"`
+	Source   string            `json:"source"`
+	Aliases  []string          `json:"aliases,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+	Severity string            `json:"severity,omitempty"`
+	CVSS     float64           `json:"cvss,omitempty"`
+	Affected []AffectedPackage `json:"affected,omitempty"`
+}
+
+// AffectedPackage lists the vulnerable versions of one package. Upstream is
+// set for NVD CPE data, whose ranges use upstream rather than distribution
+// versions; Vendor is the CPE vendor, since NVD entries are matched on the
+// product name alone.
+type AffectedPackage struct {
+	Ecosystem string         `json:"ecosystem,omitempty"`
+	Vendor    string         `json:"vendor,omitempty"`
+	Name      string         `json:"name"`
+	Ranges    []VersionRange `json:"ranges,omitempty"`
+	Versions  []string       `json:"versions,omitempty"`
+	Upstream  bool           `json:"upstream,omitempty"`
+}
+
+// VersionRange is one affected interval. An empty Introduced means every
+// earlier version; IntroducedExclusive leaves Introduced itself out (NVD
+// versionStartExcluding). With neither Fixed nor LastAffected the range is
+// open.
+type VersionRange struct {
+	Introduced          string `json:"introduced,omitempty"`
+	IntroducedExclusive bool   `json:"introduced_exclusive,omitempty"`
+	Fixed               string `json:"fixed,omitempty"`
+	LastAffected        string `json:"last_affected,omitempty"`
+}
+
+// CVEs returns the CVE identifiers among the advisory ID and its aliases.
+func (a *Advisory) CVEs() []string {
+	var cves []string
+	for _, id := range append([]string{a.ID}, a.Aliases...) {
+		if strings.HasPrefix(id, "CVE-") && !containsString(cves, id) {
+			cves = append(cves, id)
+		}
+	}
+	sort.Strings(cves)
+	return cves
+}
+
+type KEVEntry struct {
+	CVE        string `json:"cve"`
+	Name       string `json:"name"`
+	DateAdded  string `json:"date_added"`
+	DueDate    string `json:"due_date"`
+	Ransomware bool   `json:"ransomware"`
+}
+
+type EPSSScore struct {
+	Score      float64 `json:"score"`
+	Percentile float64 `json:"percentile"`
+}
+
+// InstalledPackage is a package as reported by dpkg or rpm. Format selects
+// the version ordering; Source is the source package distribution feeds are
+// keyed by.
+type InstalledPackage struct {
+	Name      string
+	Source    string
+	Version   string
+	Ecosystem string
+	Format    string
+}
+
+type VulnMatch struct {
+	Advisory *Advisory
+	Fixed    string
+	Upstream bool
+	Vendor   string
+}
+
+// VulnOptions narrows what LoadVulnDB keeps. Ecosystems limits OSV entries
+// to those ecosystems (and their releases, so "Debian" keeps "Debian:12").
+type VulnOptions struct {
+	Ecosystems []string
+}
+
+// VulnDB holds advisories from the feeds in the signatures cache, indexed by
+// package name, plus KEV, EPSS and NVD CVSS lookups keyed by CVE.
+type VulnDB struct {
+	Advisories  int
+	Sources     []string
+	ParseErrors []string
+	advisories  []*Advisory
+	versions    map[string]string
+	packages    map[string][]*Advisory
+	ecosystems  map[string]bool
+	kev         map[string]KEVEntry
+	epss        map[string]EPSSScore
+	cvss        map[string]float64
+}
+
+func newVulnDB() *VulnDB {
+	return &VulnDB{
+		versions:   map[string]string{},
+		packages:   map[string][]*Advisory{},
+		ecosystems: map[string]bool{},
+		kev:        map[string]KEVEntry{},
+		epss:       map[string]EPSSScore{},
+		cvss:       map[string]float64{},
+	}
+}
+
+// LoadVulnDB reads the OSV, GHSA, NVD, CISA KEV and EPSS files under
+// cacheDir/<source>. Files may be plain, gzipped or zip archives, as
+// downloaded by the updater or unpacked from an airgap bundle. Files that
+// fail to parse are listed in ParseErrors and skipped.
+func LoadVulnDB(cacheDir string, opts VulnOptions) (*VulnDB, error) {
+	db := newVulnDB()
+	for _, source := range []string{SourceOSV, SourceGHSA, SourceNVD, SourceCISAKEV, SourceEPSS} {
+		found, err := db.loadSource(source, filepath.Join(cacheDir, source), opts)
+		if err != nil {
+			return nil, fmt.Errorf("load %s feed: %w", source, err)
+		}
+		if found {
+			db.Sources = append(db.Sources, source)
+		}
+	}
+	return db, nil
+}
+
+func (db *VulnDB) loadSource(source, dir string, opts VulnOptions) (bool, error) {
+	var load func(r io.Reader) error
+	switch source {
+	case SourceOSV, SourceGHSA:
+		load = func(r io.Reader) error { return db.loadOSV(source, r, opts) }
+	case SourceNVD:
+		load = db.loadNVD
+	case SourceCISAKEV:
+		load = db.loadKEV
+	case SourceEPSS:
+		load = db.loadEPSS
+	default:
+		return false, fmt.Errorf("no vulnerability parser for source %s", source)
+	}
+	return walkFeedFiles(dir, func(_ string, r io.Reader) error { return load(r) }, func(err error) {
+		db.ParseErrors = append(db.ParseErrors, source+": "+err.Error())
+	})
+}
+
+// FeedStamp summarises the size and modification time of every feed file so
+// callers can tell when LoadVulnDB needs to run again.
+func FeedStamp(cacheDir string) (string, error) {
+	var parts []string
+	for _, source := range []string{SourceOSV, SourceGHSA, SourceNVD, SourceCISAKEV, SourceEPSS} {
+		err := filepath.WalkDir(filepath.Join(cacheDir, source), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			parts = append(parts, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// HasEcosystem reports whether any OSV data was loaded for the ecosystem.
+func (db *VulnDB) HasEcosystem(ecosystem string) bool {
+	return db.ecosystems[ecosystem]
+}
+
+func (db *VulnDB) KEV(cve string) (KEVEntry, bool) {
+	entry, ok := db.kev[cve]
+	return entry, ok
+}
+
+func (db *VulnDB) EPSS(cve string) (EPSSScore, bool) {
+	score, ok := db.epss[cve]
+	return score, ok
+}
+
+// CVSS returns the NVD base score for a CVE.
+func (db *VulnDB) CVSS(cve string) (float64, bool) {
+	score, ok := db.cvss[cve]
+	return score, ok
+}
+
+// Match returns the advisories affecting pkg. Distribution entries are
+// matched by ecosystem and binary or source name; NVD CPE ranges are only
+// consulted when useUpstream is set, since backported fixes keep the
+// upstream version unchanged.
+func (db *VulnDB) Match(pkg InstalledPackage, useUpstream bool) []VulnMatch {
+	names := []string{packageKey(pkg.Name)}
+	if pkg.Source != "" && packageKey(pkg.Source) != names[0] {
+		names = append(names, packageKey(pkg.Source))
+	}
+	seen := map[*Advisory]bool{}
+	var matches []VulnMatch
+	for _, name := range names {
+		for _, advisory := range db.packages[name] {
+			if seen[advisory] {
+				continue
+			}
+			for _, affected := range advisory.Affected {
+				if packageKey(affected.Name) != name {
+					continue
+				}
+				version, format := pkg.Version, pkg.Format
+				if affected.Upstream {
+					if !useUpstream {
+						continue
+					}
+					version, format = UpstreamVersion(pkg.Format, pkg.Version), VersionDpkg
+				} else if !ecosystemMatches(affected.Ecosystem, pkg.Ecosystem) {
+					continue
+				}
+				if hit, fixed := affected.affects(format, version); hit {
+					seen[advisory] = true
+					matches = append(matches, VulnMatch{Advisory: advisory, Fixed: fixed, Upstream: affected.Upstream, Vendor: affected.Vendor})
+					break
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func (a AffectedPackage) affects(format, version string) (bool, string) {
+	for _, v := range a.Versions {
+		if CompareVersions(format, version, v) == 0 {
+			return true, ""
+		}
+	}
+	for _, r := range a.Ranges {
+		if r.Introduced != "" && r.Introduced != "0" {
+			cmp := CompareVersions(format, version, r.Introduced)
+			if cmp < 0 || cmp == 0 && r.IntroducedExclusive {
+				continue
+			}
+		}
+		switch {
+		case r.Fixed != "":
+			if CompareVersions(format, version, r.Fixed) < 0 {
+				return true, r.Fixed
+			}
+		case r.LastAffected != "":
+			if CompareVersions(format, version, r.LastAffected) <= 0 {
+				return true, ""
+			}
+		default:
+			return true, ""
+		}
+	}
+	return false, ""
+}
+
+func ecosystemMatches(ecosystem, want string) bool {
+	return ecosystem == want || strings.HasPrefix(ecosystem, want+":")
+}
+
+func packageKey(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+func (db *VulnDB) add(advisory *Advisory) {
+	db.Advisories++
+	db.advisories = append(db.advisories, advisory)
+	added := map[string]bool{}
+	for _, affected := range advisory.Affected {
+		key := packageKey(affected.Name)
+		if !added[key] {
+			added[key] = true
+			db.packages[key] = append(db.packages[key], advisory)
+		}
+	}
+}
+
+type osvRecord struct {
+	ID        string   `json:"id"`
+	Modified  string   `json:"modified"`
+	Aliases   []string `json:"aliases"`
+	Upstream  []string `json:"upstream"`
+	Summary   string   `json:"summary"`
+	Details   string   `json:"details"`
+	Withdrawn string   `json:"withdrawn"`
+	Severity  []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions          []string               `json:"versions"`
+		EcosystemSpecific map[string]interface{} `json:"ecosystem_specific"`
+		DatabaseSpecific  map[string]interface{} `json:"database_specific"`
+	} `json:"affected"`
+	DatabaseSpecific map[string]interface{} `json:"database_specific"`
+}
+
+// loadOSV accepts a single OSV record or an array of them.
+func (db *VulnDB) loadOSV(source string, r io.Reader, opts VulnOptions) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	raw = bytes.TrimSpace(raw)
+	var records []osvRecord
+	if bytes.HasPrefix(raw, []byte("[")) {
+		if err := json.Unmarshal(raw, &records); err != nil {
+			return err
+		}
+	} else {
+		var record osvRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	for _, record := range records {
+		if record.ID == "" || record.Withdrawn != "" {
+			continue
+		}
+		if record.Modified > db.versions[source] {
+			db.versions[source] = record.Modified
+		}
+		advisory := &Advisory{
+			ID:       record.ID,
+			Source:   source,
+			Aliases:  append(record.Aliases, record.Upstream...),
+			Summary:  record.Summary,
+			Severity: severityLabel(record.DatabaseSpecific["severity"]),
+		}
+		if advisory.Summary == "" {
+			advisory.Summary = firstLine(record.Details)
+		}
+		for _, severity := range record.Severity {
+			if advisory.Severity == "" && !strings.HasPrefix(severity.Type, "CVSS") {
+				advisory.Severity = severityLabel(severity.Score)
+			}
+		}
+		for _, affected := range record.Affected {
+			ecosystem := affected.Package.Ecosystem
+			if !wantEcosystem(ecosystem, opts.Ecosystems, db.ecosystems) {
+				continue
+			}
+			entry := AffectedPackage{Ecosystem: ecosystem, Name: affected.Package.Name, Versions: affected.Versions}
+			for _, r := range affected.Ranges {
+				if r.Type == "GIT" {
+					continue
+				}
+				entry.Ranges = append(entry.Ranges, osvRanges(r.Events)...)
+			}
+			if len(entry.Ranges) == 0 && len(entry.Versions) == 0 {
+				continue
+			}
+			if advisory.Severity == "" {
+				advisory.Severity = severityLabel(affected.EcosystemSpecific["urgency"])
+			}
+			if advisory.Severity == "" {
+				advisory.Severity = severityLabel(affected.DatabaseSpecific["severity"])
+			}
+			advisory.Affected = append(advisory.Affected, entry)
+		}
+		if len(advisory.Affected) > 0 {
+			db.add(advisory)
+		}
+	}
+	return nil
+}
+
+// wantEcosystem applies the VulnOptions filter and records which wanted
+// ecosystems have data.
+func wantEcosystem(ecosystem string, wanted []string, seen map[string]bool) bool {
+	if len(wanted) == 0 {
+		seen[ecosystem] = true
+		return true
+	}
+	for _, want := range wanted {
+		if ecosystemMatches(ecosystem, want) {
+			seen[want] = true
+			return true
+		}
+	}
+	return false
+}
+
+// osvRanges turns an OSV event list into intervals: each introduced event
+// opens one and the next fixed or last_affected event closes it.
+func osvRanges(events []map[string]string) []VersionRange {
+	var ranges []VersionRange
+	var current *VersionRange
+	for _, event := range events {
+		if v, ok := event["introduced"]; ok {
+			if current != nil {
+				ranges = append(ranges, *current)
+			}
+			current = &VersionRange{Introduced: v}
+			continue
+		}
+		fixed, isFixed := event["fixed"]
+		last, isLast := event["last_affected"]
+		if !isFixed && !isLast {
+			continue
+		}
+		if current == nil {
+			current = &VersionRange{}
+		}
+		current.Fixed, current.LastAffected = fixed, last
+		ranges = append(ranges, *current)
+		current = nil
+	}
+	if current != nil {
+		ranges = append(ranges, *current)
+	}
+	return ranges
+}
+
+type nvdFeed struct {
+	Timestamp       string `json:"timestamp"`
+	Vulnerabilities []struct {
+		CVE struct {
+			ID           string `json:"id"`
+			Descriptions []struct {
+				Lang  string `json:"lang"`
+				Value string `json:"value"`
+			} `json:"descriptions"`
+			Metrics        map[string][]nvdMetric `json:"metrics"`
+			Configurations []struct {
+				Nodes []struct {
+					CPEMatch []struct {
+						Vulnerable            bool   `json:"vulnerable"`
+						Criteria              string `json:"criteria"`
+						VersionStartIncluding string `json:"versionStartIncluding"`
+						VersionStartExcluding string `json:"versionStartExcluding"`
+						VersionEndIncluding   string `json:"versionEndIncluding"`
+						VersionEndExcluding   string `json:"versionEndExcluding"`
+					} `json:"cpeMatch"`
+				} `json:"nodes"`
+			} `json:"configurations"`
+		} `json:"cve"`
+	} `json:"vulnerabilities"`
+}
+
+type nvdMetric struct {
+	BaseSeverity string `json:"baseSeverity"`
+	CVSSData     struct {
+		BaseScore    float64 `json:"baseScore"`
+		BaseSeverity string  `json:"baseSeverity"`
+	} `json:"cvssData"`
+}
+
+// loadNVD reads NVD 2.0 JSON. CPE matches become upstream-version entries
+// per vendor and product; a CPE without a version or range is skipped because it
+// would match every install.
+func (db *VulnDB) loadNVD(r io.Reader) error {
+	var feed nvdFeed
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return err
+	}
+	if feed.Timestamp > db.versions[SourceNVD] {
+		db.versions[SourceNVD] = feed.Timestamp
+	}
+	for _, item := range feed.Vulnerabilities {
+		cve := item.CVE
+		if cve.ID == "" {
+			continue
+		}
+		advisory := &Advisory{ID: cve.ID, Source: SourceNVD}
+		for _, desc := range cve.Descriptions {
+			if desc.Lang == "en" {
+				advisory.Summary = firstLine(desc.Value)
+				break
+			}
+		}
+		for _, key := range []string{"cvssMetricV40", "cvssMetricV31", "cvssMetricV30", "cvssMetricV2"} {
+			if metrics := cve.Metrics[key]; len(metrics) > 0 {
+				severity := metrics[0].CVSSData.BaseSeverity
+				if severity == "" {
+					severity = metrics[0].BaseSeverity
+				}
+				advisory.CVSS = metrics[0].CVSSData.BaseScore
+				advisory.Severity = severityLabel(severity)
+				db.cvss[cve.ID] = advisory.CVSS
+				break
+			}
+		}
+		byProduct := map[string]*AffectedPackage{}
+		for _, config := range cve.Configurations {
+			for _, node := range config.Nodes {
+				for _, match := range node.CPEMatch {
+					parts := strings.Split(match.Criteria, ":")
+					if !match.Vulnerable || len(parts) < 6 || (parts[2] != "a" && parts[2] != "o") {
+						continue
+					}
+					vendor, product, version := parts[3], parts[4], parts[5]
+					r := VersionRange{
+						Introduced:          firstNonEmpty(match.VersionStartIncluding, match.VersionStartExcluding),
+						IntroducedExclusive: match.VersionStartIncluding == "" && match.VersionStartExcluding != "",
+						Fixed:               match.VersionEndExcluding,
+						LastAffected:        match.VersionEndIncluding,
+					}
+					key := vendor + ":" + product
+					entry := byProduct[key]
+					if entry == nil {
+						entry = &AffectedPackage{Vendor: vendor, Name: product, Upstream: true}
+						byProduct[key] = entry
+					}
+					switch {
+					case version != "*" && version != "-" && version != "":
+						entry.Versions = append(entry.Versions, version)
+					case r.Fixed != "" || r.LastAffected != "":
+						entry.Ranges = append(entry.Ranges, r)
+					}
+				}
+			}
+		}
+		for _, key := range sortedProducts(byProduct) {
+			if entry := byProduct[key]; len(entry.Ranges) > 0 || len(entry.Versions) > 0 {
+				advisory.Affected = append(advisory.Affected, *entry)
+			}
+		}
+		if len(advisory.Affected) > 0 {
+			db.add(advisory)
+		}
+	}
+	return nil
+}
+
+func (db *VulnDB) loadKEV(r io.Reader) error {
+	var feed struct {
+		CatalogVersion  string `json:"catalogVersion"`
+		Vulnerabilities []struct {
+			CVEID      string `json:"cveID"`
+			Name       string `json:"vulnerabilityName"`
+			DateAdded  string `json:"dateAdded"`
+			DueDate    string `json:"dueDate"`
+			Ransomware string `json:"knownRansomwareCampaignUse"`
+		} `json:"vulnerabilities"`
+	}
+	if err := json.NewDecoder(r).Decode(&feed); err != nil {
+		return err
+	}
+	db.versions[SourceCISAKEV] = feed.CatalogVersion
+	for _, item := range feed.Vulnerabilities {
+		db.kev[item.CVEID] = KEVEntry{
+			CVE:        item.CVEID,
+			Name:       item.Name,
+			DateAdded:  item.DateAdded,
+			DueDate:    item.DueDate,
+			Ransomware: strings.EqualFold(item.Ransomware, "known"),
+		}
+	}
+	return nil
+}
+
+// loadEPSS reads the FIRST EPSS CSV: an optional #model_version comment, a
+// cve,epss,percentile header, then one row per CVE.
+func (db *VulnDB) loadEPSS(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			db.versions[SourceEPSS] = strings.TrimPrefix(line, "#")
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "CVE-") {
+			continue
+		}
+		score, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		percentile, _ := strconv.ParseFloat(fields[2], 64)
+		db.epss[fields[0]] = EPSSScore{Score: score, Percentile: percentile}
+	}
+	return scanner.Err()
+}
+
+// severityLabel normalises feed severities (GHSA MODERATE, Debian urgency,
+// Ubuntu priority) to low, medium, high or critical.
+func severityLabel(value interface{}) string {
+	s, _ := value.(string)
+	switch strings.ToLower(strings.TrimSpace(strings.TrimSuffix(s, "*"))) {
+	case "critical":
+		return "critical"
+	case "high":
+		return "high"
+	case "medium", "moderate":
+		return "medium"
+	case "low", "negligible", "unimportant":
+		return "low"
+	}
+	return ""
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedProducts(m map[string]*AffectedPackage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
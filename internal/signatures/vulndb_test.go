@@ -0,0 +1,39 @@
+package signatures
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadNVDRanges(t *testing.T) {
+	db := newVulnDB()
+	feed := `{"timestamp":"2024-05-01T00:00:00","vulnerabilities":[{"cve":{"id":"CVE-2024-0001",
+		"configurations":[{"nodes":[{"cpeMatch":[
+			{"vulnerable":true,"criteria":"cpe:2.3:a:example:tool:*:*:*:*:*:*:*:*","versionStartExcluding":"2.0","versionEndExcluding":"2.4"},
+			{"vulnerable":true,"criteria":"cpe:2.3:a:other:tool:*:*:*:*:*:*:*:*","versionStartIncluding":"1.0","versionEndIncluding":"1.1"}
+		]}]}]}}]}`
+	if err := db.loadNVD(strings.NewReader(feed)); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	cases := []struct {
+		version string
+		vendor  string
+	}{
+		{"1.0", "other"},
+		{"1.1", "other"},
+		{"1.2", ""},
+		{"2.0", ""},
+		{"2.1", "example"},
+		{"2.4", ""},
+	}
+	for _, tc := range cases {
+		matches := db.Match(InstalledPackage{Name: "tool", Version: tc.version, Format: VersionDpkg}, true)
+		vendor := ""
+		if len(matches) > 0 {
+			vendor = matches[0].Vendor
+		}
+		if vendor != tc.vendor {
+			t.Fatalf("tool %s: matched vendor %q, want %q", tc.version, vendor, tc.vendor)
+		}
+	}
+}
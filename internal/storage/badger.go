@@ -80,7 +80,7 @@ func (b *BadgerStore) ForEach(bucket string, fn func(key, value []byte) error) e
 	return b.db.View(func(txn *badger.Txn) error {
 		it := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer it.Close()
-		for it.Rewind(); it.ValidForPrefix(prefix); it.Next() {
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
 			k := item.Key()
 			key := string(k[len(prefix):])
@@ -103,6 +103,24 @@ func (b *BadgerStore) Delete(bucket, key string) error {
 	})
 }
 
+// PutMany writes items through a single write batch.
+func (b *BadgerStore) PutMany(bucket string, items map[string][]byte) error {
+	if bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	batch := b.db.NewWriteBatch()
+	defer batch.Cancel()
+	for key, value := range items {
+		if key == "" {
+			return fmt.Errorf("bucket and key are required")
+		}
+		if err := batch.Set(makeKey(bucket, key), value); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
 func (b *BadgerStore) Close() error {
 	if b.db == nil {
 		return nil
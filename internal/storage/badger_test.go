@@ -39,6 +39,10 @@ func TestBadgerStoreForEach(t *testing.T) {
 	if err := store.Put("bucket", "key2", []byte("value2")); err != nil {
 		t.Fatalf("put: %v", err)
 	}
+	// A bucket that sorts before "bucket" must not end the iteration early.
+	if err := store.Put("a_bucket", "key", []byte("other")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
 
 	seen := 0
 	err = store.ForEach("bucket", func(key, value []byte) error {
@@ -71,3 +75,19 @@ func TestBadgerStoreDelete(t *testing.T) {
 		t.Fatalf("expected not found after delete")
 	}
 }
+
+func TestBadgerStoreBulk(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBadgerStore(filepath.Join(dir, "badger"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.PutMany("bucket", map[string][]byte{"key1": []byte("a"), "key2": []byte("b")}); err != nil {
+		t.Fatalf("put many: %v", err)
+	}
+	if got, err := store.Get("bucket", "key2"); err != nil || string(got) != "b" {
+		t.Fatalf("get: %q %v", got, err)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const processDeltasBucket = "process_deltas"
+
+type ProcessSummary struct {
+	PID       int       `json:"pid"`
+	PPID      int       `json:"ppid"`
+	Name      string    `json:"name"`
+	Exe       string    `json:"exe,omitempty"`
+	Cmdline   string    `json:"cmdline,omitempty"`
+	UID       int       `json:"uid"`
+	StartTime time.Time `json:"start_time"`
+	SHA256    string    `json:"sha256,omitempty"`
+}
+
+// ProcessDelta lists the processes that started or exited between two
+// consecutive process monitor runs.
+type ProcessDelta struct {
+	Timestamp time.Time        `json:"timestamp"`
+	Started   []ProcessSummary `json:"started"`
+	Exited    []ProcessSummary `json:"exited"`
+}
+
+type ProcessDeltaStore struct {
+	store Store
+}
+
+func NewProcessDeltaStore(store Store) *ProcessDeltaStore {
+	return &ProcessDeltaStore{store: store}
+}
+
+func (p *ProcessDeltaStore) Save(delta ProcessDelta) error {
+	raw, err := json.Marshal(delta)
+	if err != nil {
+		return fmt.Errorf("encode process delta: %w", err)
+	}
+	// Zero-padded nanoseconds keep keys in chronological order.
+	key := fmt.Sprintf("%020d", delta.Timestamp.UnixNano())
+	return p.store.Put(processDeltasBucket, key, raw)
+}
+
+func (p *ProcessDeltaStore) Since(since time.Time) ([]ProcessDelta, error) {
+	deltas := []ProcessDelta{}
+	err := p.store.ForEach(processDeltasBucket, func(_, value []byte) error {
+		var delta ProcessDelta
+		if err := json.Unmarshal(value, &delta); err != nil {
+			return fmt.Errorf("decode process delta: %w", err)
+		}
+		if !delta.Timestamp.Before(since) {
+			deltas = append(deltas, delta)
+		}
+		return nil
+	})
+	if err != nil {
+		if err == ErrNotFound {
+			return []ProcessDelta{}, nil
+		}
+		return nil, err
+	}
+	return deltas, nil
+}
+
+func (p *ProcessDeltaStore) PruneOlderThan(cutoff time.Time) error {
+	return p.store.ForEach(processDeltasBucket, func(key, value []byte) error {
+		var delta ProcessDelta
+		if err := json.Unmarshal(value, &delta); err != nil {
+			return nil
+		}
+		if delta.Timestamp.Before(cutoff) {
+			return p.store.Delete(processDeltasBucket, string(key))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessDeltaStoreSinceAndPrune(t *testing.T) {
+	store, err := NewBadgerStore(filepath.Join(t.TempDir(), "badger"))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer store.Close()
+
+	deltas := NewProcessDeltaStore(store)
+	now := time.Now().UTC()
+	for _, ts := range []time.Time{now.Add(-48 * time.Hour), now.Add(-time.Hour)} {
+		if err := deltas.Save(ProcessDelta{Timestamp: ts, Started: []ProcessSummary{{PID: 42, Name: "sh"}}}); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	recent, err := deltas.Since(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+	if len(recent) != 1 || recent[0].Started[0].PID != 42 {
+		t.Fatalf("expected one recent delta, got %+v", recent)
+	}
+
+	if err := deltas.PruneOlderThan(now.Add(-24 * time.Hour)); err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	all, err := deltas.Since(time.Time{})
+	if err != nil {
+		t.Fatalf("since: %v", err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("expected 1 delta after prune, got %d", len(all))
+	}
+}
@@ -11,3 +11,9 @@ type Store interface {
 	Delete(bucket, key string) error
 	Close() error
 }
+
+// BulkStore is implemented by stores that can write many keys in one pass;
+// callers fall back to Put otherwise.
+type BulkStore interface {
+	PutMany(bucket string, items map[string][]byte) error
+}
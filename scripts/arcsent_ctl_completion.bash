@@ -13,7 +13,7 @@ _arcsent_ctl_completion() {
 
   if [[ ${COMP_WORDS[1]} == "ctl" ]]; then
     if [[ ${COMP_CWORD} -eq 2 ]]; then
-      COMPREPLY=( $(compgen -W "status health scanners findings baselines results trigger signatures export metrics" -- "$cur") )
+      COMPREPLY=( $(compgen -W "status health scanners findings baselines results trigger accept signatures export metrics" -- "$cur") )
       return 0
     fi
     case "${COMP_WORDS[2]}" in
@@ -29,7 +29,7 @@ _arcsent_ctl_completion() {
         COMPREPLY=( $(compgen -W "results baselines" -- "$cur") )
         return 0
         ;;
-      trigger)
+      trigger|accept)
         return 0
         ;;
     esac